@@ -0,0 +1,158 @@
+// Command csv-transform transforms a local csv file (or stdin), writing the result to a local file (or stdout), without
+// Kafka or S3.
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+)
+
+const stdio = "-"
+
+func main() {
+	// default to the hierarchy source the service would use
+	defaultSource := config.HierarchyEndpoint
+	if len(config.HierarchySource) > 0 {
+		defaultSource = config.HierarchySource
+	}
+
+	input := flag.String("input", stdio, "the csv file to transform (- for stdin)")
+	output := flag.String("output", stdio, "the file to write the transformed csv to (- for stdout)")
+	source := flag.String("hierarchy-source", defaultSource, "the hierarchy endpoint url (containing "+config.HIERACHY_ID_PLACEHOLDER+"), or a "+config.FileSourcePrefix+" url of a directory or zip archive of hierarchy json files")
+	gzipOutput := flag.Bool("gzip", false, "gzip the transformed csv")
+	requestID := flag.String("request-id", "csv-transform", "the request id used in log messages")
+	unresolvedPolicy := flag.String("unresolved-policy", config.UnresolvedCodePolicy, "what happens when a code cannot be found in its hierarchy: blank, fail or fail-above-threshold")
+	unresolvedThreshold := flag.String("unresolved-threshold", config.UnresolvedCodeThreshold, "the number (e.g. 100) or percentage (e.g. 0.5%) of unresolved codes allowed with fail-above-threshold")
+	report := flag.String("report", "", "the file to write the json report of unresolved codes and unparsed time codes to (none if empty)")
+	headerAliases := flag.String("header-aliases", config.HeaderAliases, "alternative header names for the input columns, e.g. Observation=Value|Count,Dimension_Hierarchy=Hierarchy")
+	strict := flag.Bool("strict", config.StrictValidation, "validate every row, writing invalid rows to the -rejected file rather than failing")
+	rejectedRowLimit := flag.Int64("rejected-row-limit", config.RejectedRowLimit, "the number of rows that can be rejected with -strict before the transform fails")
+	rejected := flag.String("rejected", "", "the file to write the csv of rows rejected with -strict to (none if empty)")
+	hierarchyColumns := flag.Bool("hierarchy-columns", config.HierarchyColumns, "output the level, parent and ancestors of each code in a hierarchy")
+	timePeriodColumns := flag.Bool("time-period-columns", config.TimePeriodColumns, "output the period type, start date and end date of each code in a time hierarchy")
+	rowWorkers := flag.Int("row-workers", config.RowWorkers, "the number of workers transforming rows in parallel")
+	batchSize := flag.Int("batch-size", config.RowBatchSize, "the number of rows passed to a row worker at a time")
+	flag.Parse()
+
+	policy, err := transformer.ParseUnresolvedCodePolicy(*unresolvedPolicy, *unresolvedThreshold)
+	var aliases transformer.HeaderAliases
+	if err == nil {
+		aliases, err = transformer.ParseHeaderAliases(*headerAliases)
+	}
+	if err != nil || flag.NArg() > 0 {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// the transformer logs to stdout, so send the logs to stderr and keep stdout for the output
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+
+	start := time.Now()
+	t := &transformer.Transformer{UnresolvedCodePolicy: policy, HeaderAliases: aliases, StrictValidation: *strict, RejectedRowLimit: *rejectedRowLimit,
+		RowWorkers: *rowWorkers, BatchSize: *batchSize}
+	options := transformer.Options{HierarchyColumns: *hierarchyColumns, TimePeriodColumns: *timePeriodColumns}
+	stats, err := transform(t, options, *input, *output, stdout, *source, *gzipOutput, *requestID)
+	if stats != nil && len(*report) > 0 {
+		if reportErr := writeFile(*report, stats.ValidationReport().WriteJSON); reportErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report %s: %s\n", *report, reportErr.Error())
+		}
+	}
+	if stats != nil && len(*rejected) > 0 {
+		if rejectedErr := writeFile(*rejected, stats.WriteRejectedRows); rejectedErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write rejected rows %s: %s\n", *rejected, rejectedErr.Error())
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to transform %s: %s (request id: %s, duration: %s)\n", *input, err.Error(), *requestID, time.Since(start))
+		printStats(stats)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Transformed %s to %s (request id: %s, duration: %s)\n", *input, *output, *requestID, time.Since(start))
+	printStats(stats)
+}
+
+func printStats(stats *transformer.Stats) {
+	if stats == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "  rows read: %d, rows written: %d, bytes in: %d, bytes out: %d\n", stats.RowsRead, stats.RowsWritten, stats.BytesIn, stats.BytesOut)
+	if stats.HasRejectedRows() {
+		fmt.Fprintf(os.Stderr, "  rows rejected: %d\n", stats.RowsRejected)
+	}
+	for hierarchyID, count := range stats.UnresolvedCodes {
+		fmt.Fprintf(os.Stderr, "  unresolved codes in hierarchy %s: %d\n", hierarchyID, count)
+	}
+	if stats.UnparsedTimeCodes > 0 {
+		fmt.Fprintf(os.Stderr, "  unparsed time codes: %d\n", stats.UnparsedTimeCodes)
+	}
+}
+
+func writeFile(fileName string, write func(w io.Writer) error) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func transform(t *transformer.Transformer, options transformer.Options, input string, output string, stdout *os.File, source string, gzipOutput bool, requestID string) (*transformer.Stats, error) {
+	r := os.Stdin
+	if input != stdio {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	outputFile := stdout
+	if output != stdio {
+		f, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		outputFile = f
+	}
+
+	var w io.Writer = outputFile
+	var gzipWriter *gzip.Writer
+	if gzipOutput {
+		gzipWriter = gzip.NewWriter(outputFile)
+		w = gzipWriter
+	}
+
+	stats, err := t.Transform(context.Background(), r, w, hierarchy.NewHierarchyClientForSource(source), requestID, options)
+	if gzipWriter != nil {
+		if closeErr := gzipWriter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if output != stdio {
+		if closeErr := outputFile.Close(); err == nil {
+			err = closeErr
+		}
+		// don't leave a partial output behind
+		if err != nil {
+			os.Remove(output)
+		}
+	}
+	return stats, err
+}
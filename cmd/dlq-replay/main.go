@@ -0,0 +1,51 @@
+// Command dlq-replay sends the messages on the dead letter topic back to the transform request topic.
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+)
+
+func main() {
+	limit := flag.Int("limit", 0, "the maximum number of messages to replay (0 for no limit)")
+	idleTimeout := flag.Duration("idle-timeout", 10*time.Second, "stop once no message has been received for this long")
+	group := flag.String("group", "transform-request-dlq-replay", "the consumer group used to track which messages have been replayed")
+	flag.Parse()
+
+	config.Load()
+
+	consumerConfig := cluster.NewConfig()
+	consumerConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	consumer, err := cluster.NewConsumer([]string{config.KafkaAddr}, *group, []string{config.KafkaDeadLetterTopic}, consumerConfig)
+	if err != nil {
+		log.Error(err, nil)
+		os.Exit(1)
+	}
+
+	producerConfig := sarama.NewConfig()
+	producerConfig.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer([]string{config.KafkaAddr}, producerConfig)
+	if err != nil {
+		log.Error(err, nil)
+		consumer.Close()
+		os.Exit(1)
+	}
+
+	replayed, err := message.Replay(consumer, producer, *limit, *idleTimeout)
+	log.Debug("Finished replaying dead letters", log.Data{"replayed": replayed, "from": config.KafkaDeadLetterTopic, "to": config.KafkaConsumerTopic})
+
+	producer.Close()
+	if closeErr := consumer.Close(); closeErr != nil {
+		log.Error(closeErr, nil)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -5,18 +5,66 @@ import (
 
 	"github.com/ONSdigital/go-ns/log"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const bindAddrKey = "BIND_ADDR"
 const kafkaAddrKey = "KAFKA_ADDR"
 const kafkaConsumerGroup = "KAFKA_CONSUMER_GROUP"
 const kafkaConsumerTopic = "KAFKA_CONSUMER_TOPIC"
+const kafkaCommitInterval = "KAFKA_COMMIT_INTERVAL"
+const kafkaDeadLetterTopic = "KAFKA_DEAD_LETTER_TOPIC"
+const kafkaTransformCompleteTopic = "KAFKA_TRANSFORM_COMPLETE_TOPIC"
+const kafkaTransformFailedTopic = "KAFKA_TRANSFORM_FAILED_TOPIC"
+const transformWorkers = "TRANSFORM_WORKERS"
+const rowWorkers = "TRANSFORM_ROW_WORKERS"
+const rowBatchSize = "TRANSFORM_BATCH_SIZE"
+const preservePartitionOrder = "PRESERVE_PARTITION_ORDER"
+const retryMaxAttempts = "RETRY_MAX_ATTEMPTS"
+const retryInitialBackoff = "RETRY_INITIAL_BACKOFF"
+const retryMaxBackoff = "RETRY_MAX_BACKOFF"
+const retryBackoffMultiplier = "RETRY_BACKOFF_MULTIPLIER"
+const retryJitter = "RETRY_JITTER"
 const awsRegionKey = "AWS_REGION"
+const s3Endpoint = "S3_ENDPOINT"
+const s3ForcePathStyle = "S3_FORCE_PATH_STYLE"
+const fileStorageRoot = "FILE_STORAGE_ROOT"
 const hierarchyEndpoint = "HIERARCHY_ENDPOINT"
+const hierarchySource = "HIERARCHY_SOURCE"
+const hierarchyConnectTimeout = "HIERARCHY_CONNECT_TIMEOUT"
+const hierarchyReadTimeout = "HIERARCHY_READ_TIMEOUT"
+const hierarchyRetryMaxAttempts = "HIERARCHY_RETRY_MAX_ATTEMPTS"
+const hierarchyRetryBackoff = "HIERARCHY_RETRY_BACKOFF"
+const hierarchyBreakerErrorThreshold = "HIERARCHY_BREAKER_ERROR_THRESHOLD"
+const hierarchyBreakerTimeout = "HIERARCHY_BREAKER_TIMEOUT"
+const hierarchyCacheTTL = "HIERARCHY_CACHE_TTL"
+const hierarchyCacheMaxEntries = "HIERARCHY_CACHE_MAX_ENTRIES"
+const hierarchyCacheMaxBytes = "HIERARCHY_CACHE_MAX_BYTES"
 const useGzipCompression = "USE_GZIP"
+const spoolOutput = "SPOOL_OUTPUT"
+const unresolvedCodePolicy = "UNRESOLVED_CODE_POLICY"
+const unresolvedCodeThreshold = "UNRESOLVED_CODE_THRESHOLD"
+const validationReportFormat = "VALIDATION_REPORT_FORMAT"
+const headerAliases = "HEADER_ALIASES"
+const strictValidation = "STRICT_VALIDATION"
+const rejectedRowLimit = "REJECTED_ROW_LIMIT"
+const hierarchyColumns = "HIERARCHY_COLUMNS"
+const timePeriodColumns = "TIME_PERIOD_COLUMNS"
+const spoolDir = "SPOOL_DIR"
+const readinessS3Buckets = "READINESS_S3_BUCKETS"
+const readinessCacheTTL = "READINESS_CACHE_TTL"
+const readinessTimeout = "READINESS_TIMEOUT"
+const jobStore = "JOB_STORE"
+const jobStoreMaxJobs = "JOB_STORE_MAX_JOBS"
+const jobTimeout = "JOB_TIMEOUT"
+const shutdownGracePeriod = "SHUTDOWN_GRACE_PERIOD"
 
 const HIERACHY_ID_PLACEHOLDER = "{hierarchy_id}"
 
+// FileSourcePrefix the prefix of a HierarchySource that is a local directory or zip archive, or of a JobStore directory.
+const FileSourcePrefix = "file://"
+
 // BindAddr the address to bind to.
 var BindAddr = ":21200"
 
@@ -26,18 +74,159 @@ var KafkaAddr = "localhost:9092"
 // AWSRegion the AWS region to use.
 var AWSRegion = "eu-west-1"
 
+// S3Endpoint overrides the AWS S3 endpoint, e.g. to use an S3 compatible service such as MinIO or localstack.
+var S3Endpoint = ""
+
+// S3ForcePathStyle determines whether the bucket is given in the path (rather than the host name) of S3 requests.
+var S3ForcePathStyle = false
+
+// FileStorageRoot the directory that file:// input and output urls must be within. Empty means file urls are disabled.
+var FileStorageRoot = ""
+
 // KafkaConsumerGroup the consumer group to consume messages from.
 var KafkaConsumerGroup = "transform-request"
 
 // KafkaConsumerTopic the name of the topic to consume messages from.
 var KafkaConsumerTopic = "transform-request"
 
+// KafkaCommitInterval how often the offsets of processed messages are committed.
+var KafkaCommitInterval = 1 * time.Second
+
+// KafkaDeadLetterTopic the name of the topic to send messages that could not be processed to.
+var KafkaDeadLetterTopic = "transform-request-dlq"
+
+// KafkaTransformCompleteTopic the name of the topic to send transform complete messages to.
+var KafkaTransformCompleteTopic = "transform-complete"
+
+// KafkaTransformFailedTopic the name of the topic to send transform failed messages to.
+var KafkaTransformFailedTopic = "transform-failed"
+
+// TransformWorkers the number of transform requests to process concurrently.
+var TransformWorkers = 1
+
+// RowWorkers the number of workers transforming the rows of each request in parallel. The rows are transformed serially
+// if it is 1.
+var RowWorkers = 1
+
+// RowBatchSize the number of rows passed to a row worker at a time.
+var RowBatchSize = 1000
+
+// PreservePartitionOrder determines whether requests from the same Kafka partition are processed in the order received.
+var PreservePartitionOrder = false
+
+// RetryMaxAttempts the maximum number of times a transform request is attempted if it fails with a transient error.
+var RetryMaxAttempts = 3
+
+// RetryInitialBackoff the time to wait before the first retry.
+var RetryInitialBackoff = 1 * time.Second
+
+// RetryMaxBackoff the maximum time to wait between retries.
+var RetryMaxBackoff = 30 * time.Second
+
+// RetryBackoffMultiplier the factor the backoff is multiplied by after each retry.
+var RetryBackoffMultiplier = 2.0
+
+// RetryJitter the fraction of the backoff by which it is randomly increased or decreased.
+var RetryJitter = 0.2
+
 // HierarchyEndpoint the url of the metadata api hierarchy endpoint.
 var HierarchyEndpoint = "http://localhost:20099/hierarchies/" + HIERACHY_ID_PLACEHOLDER
 
+// HierarchySource where hierarchies are loaded from instead of the HierarchyEndpoint, e.g. "file:///path/to/hierarchies"
+// for a directory (or zip archive) of {hierarchy_id}.json files. Empty means the HierarchyEndpoint is used.
+var HierarchySource = ""
+
+// HierarchyConnectTimeout the maximum time to wait for a connection to the hierarchy endpoint.
+var HierarchyConnectTimeout = 5 * time.Second
+
+// HierarchyReadTimeout the maximum time to wait for a response from the hierarchy endpoint, including reading the body.
+var HierarchyReadTimeout = 60 * time.Second
+
+// HierarchyRetryMaxAttempts the maximum number of times a hierarchy is requested if the endpoint is unavailable.
+var HierarchyRetryMaxAttempts = 3
+
+// HierarchyRetryBackoff the time to wait before the first retry of a hierarchy request.
+var HierarchyRetryBackoff = 500 * time.Millisecond
+
+// HierarchyBreakerErrorThreshold the number of consecutive errors from the hierarchy endpoint that open the circuit breaker.
+var HierarchyBreakerErrorThreshold = 5
+
+// HierarchyBreakerTimeout how long the circuit breaker stays open before the hierarchy endpoint is tried again.
+var HierarchyBreakerTimeout = 30 * time.Second
+
+// HierarchyCacheTTL how long a hierarchy is cached for. Zero means hierarchies do not expire.
+var HierarchyCacheTTL = 1 * time.Hour
+
+// HierarchyCacheMaxEntries the maximum number of hierarchies to cache. Zero means no limit.
+var HierarchyCacheMaxEntries = 50
+
+// HierarchyCacheMaxBytes the maximum (estimated) memory used by cached hierarchies. Zero means no limit.
+var HierarchyCacheMaxBytes int64 = 256 * 1024 * 1024
+
 // UseGzipCompression determines whether files should be compressed when uploaded to S3 and served with `Content-Encoding: gzip` header.
 var UseGzipCompression = false
 
+// SpoolOutput determines whether the transformed output is written to a temporary file in SpoolDir before it is
+// uploaded, rather than streamed straight into the upload.
+var SpoolOutput = false
+
+// SpoolDir the directory temporary output files are written to when SpoolOutput is enabled.
+var SpoolDir = "/var/tmp"
+
+// UnresolvedCodePolicy what happens when a code cannot be found in its hierarchy: "blank" (the value is left blank),
+// "fail" or "fail-above-threshold" (see UnresolvedCodeThreshold).
+var UnresolvedCodePolicy = "blank"
+
+// UnresolvedCodeThreshold the number (e.g. "100"), or percentage (e.g. "0.5%"), of unresolved codes above which a
+// transform fails with the "fail-above-threshold" UnresolvedCodePolicy.
+var UnresolvedCodeThreshold = "0"
+
+// ValidationReportFormat the format of the report of unresolved codes saved alongside the output: "json" or "csv".
+var ValidationReportFormat = "json"
+
+// HeaderAliases the alternative header names accepted for the input csv columns, e.g.
+// "Observation=Value|Count,Dimension_Hierarchy=Hierarchy" (an alias of a dimension column is followed by its number).
+var HeaderAliases = ""
+
+// StrictValidation determines whether every row of the input csv is validated, with invalid rows written to a
+// quarantine file alongside the output rather than failing the transform.
+var StrictValidation = false
+
+// RejectedRowLimit the number of rows that can be rejected with StrictValidation before the transform fails.
+var RejectedRowLimit int64 = 0
+
+// HierarchyColumns determines whether the level, parent and ancestors of each code in a hierarchy are output, unless
+// overridden by the request.
+var HierarchyColumns = false
+
+// TimePeriodColumns determines whether the period type, start date and end date of each code in a time hierarchy are
+// output, unless overridden by the request.
+var TimePeriodColumns = false
+
+// ReadinessS3Buckets the S3 buckets checked by the /ready endpoint, configured as a comma separated list.
+var ReadinessS3Buckets []string
+
+// ReadinessCacheTTL how long the result of each /ready check is cached, so frequent probes don't hammer the
+// dependencies.
+var ReadinessCacheTTL = 10 * time.Second
+
+// ReadinessTimeout the maximum time to wait for each /ready check.
+var ReadinessTimeout = 5 * time.Second
+
+// JobStore where the state of each job is recorded: empty for in memory, or e.g. "file:///path/to/jobs" for a directory
+// of {requestId}.json files that survive a restart.
+var JobStore = ""
+
+// JobStoreMaxJobs the maximum number of jobs recorded, after which the oldest are removed. Zero means no limit.
+var JobStoreMaxJobs = 1000
+
+// JobTimeout the maximum time a request, including its retries, can take before it is cancelled. Zero means no limit.
+var JobTimeout time.Duration = 0
+
+// ShutdownGracePeriod how long the requests in progress are given to finish when the service is stopped, before they
+// are cancelled. It should leave time to stop before the service is killed, e.g. by the ECS stop timeout.
+var ShutdownGracePeriod = 20 * time.Second
+
 func init() {
 	if bindAddrEnv := os.Getenv(bindAddrKey); len(bindAddrEnv) > 0 {
 		BindAddr = bindAddrEnv
@@ -51,6 +240,22 @@ func init() {
 		AWSRegion = awsRegionEnv
 	}
 
+	if s3EndpointEnv := os.Getenv(s3Endpoint); len(s3EndpointEnv) > 0 {
+		S3Endpoint = s3EndpointEnv
+	}
+
+	if s3ForcePathStyleEnv := os.Getenv(s3ForcePathStyle); len(s3ForcePathStyleEnv) > 0 {
+		var err error
+		S3ForcePathStyle, err = strconv.ParseBool(s3ForcePathStyleEnv)
+		if err != nil {
+			panic("Invalid boolean value for " + s3ForcePathStyle + ": " + s3ForcePathStyleEnv)
+		}
+	}
+
+	if fileStorageRootEnv := os.Getenv(fileStorageRoot); len(fileStorageRootEnv) > 0 {
+		FileStorageRoot = fileStorageRootEnv
+	}
+
 	if consumerGroupEnv := os.Getenv(kafkaConsumerGroup); len(consumerGroupEnv) > 0 {
 		KafkaConsumerGroup = consumerGroupEnv
 	}
@@ -59,10 +264,181 @@ func init() {
 		KafkaConsumerTopic = consumerTopicEnv
 	}
 
+	if commitIntervalEnv := os.Getenv(kafkaCommitInterval); len(commitIntervalEnv) > 0 {
+		var err error
+		KafkaCommitInterval, err = time.ParseDuration(commitIntervalEnv)
+		if err != nil || KafkaCommitInterval <= 0 {
+			panic("Invalid duration value for " + kafkaCommitInterval + ": " + commitIntervalEnv)
+		}
+	}
+
+	if deadLetterTopicEnv := os.Getenv(kafkaDeadLetterTopic); len(deadLetterTopicEnv) > 0 {
+		KafkaDeadLetterTopic = deadLetterTopicEnv
+	}
+
+	if transformCompleteTopicEnv := os.Getenv(kafkaTransformCompleteTopic); len(transformCompleteTopicEnv) > 0 {
+		KafkaTransformCompleteTopic = transformCompleteTopicEnv
+	}
+
+	if transformFailedTopicEnv := os.Getenv(kafkaTransformFailedTopic); len(transformFailedTopicEnv) > 0 {
+		KafkaTransformFailedTopic = transformFailedTopicEnv
+	}
+
+	if transformWorkersEnv := os.Getenv(transformWorkers); len(transformWorkersEnv) > 0 {
+		var err error
+		TransformWorkers, err = strconv.Atoi(transformWorkersEnv)
+		if err != nil || TransformWorkers < 1 {
+			panic("Invalid positive integer value for " + transformWorkers + ": " + transformWorkersEnv)
+		}
+	}
+
+	if rowWorkersEnv := os.Getenv(rowWorkers); len(rowWorkersEnv) > 0 {
+		var err error
+		RowWorkers, err = strconv.Atoi(rowWorkersEnv)
+		if err != nil || RowWorkers < 1 {
+			panic("Invalid positive integer value for " + rowWorkers + ": " + rowWorkersEnv)
+		}
+	}
+
+	if rowBatchSizeEnv := os.Getenv(rowBatchSize); len(rowBatchSizeEnv) > 0 {
+		var err error
+		RowBatchSize, err = strconv.Atoi(rowBatchSizeEnv)
+		if err != nil || RowBatchSize < 1 {
+			panic("Invalid positive integer value for " + rowBatchSize + ": " + rowBatchSizeEnv)
+		}
+	}
+
+	if preservePartitionOrderEnv := os.Getenv(preservePartitionOrder); len(preservePartitionOrderEnv) > 0 {
+		var err error
+		PreservePartitionOrder, err = strconv.ParseBool(preservePartitionOrderEnv)
+		if err != nil {
+			panic("Invalid boolean value for " + preservePartitionOrder + ": " + preservePartitionOrderEnv)
+		}
+	}
+
+	if retryMaxAttemptsEnv := os.Getenv(retryMaxAttempts); len(retryMaxAttemptsEnv) > 0 {
+		var err error
+		RetryMaxAttempts, err = strconv.Atoi(retryMaxAttemptsEnv)
+		if err != nil || RetryMaxAttempts < 1 {
+			panic("Invalid positive integer value for " + retryMaxAttempts + ": " + retryMaxAttemptsEnv)
+		}
+	}
+
+	if retryInitialBackoffEnv := os.Getenv(retryInitialBackoff); len(retryInitialBackoffEnv) > 0 {
+		var err error
+		RetryInitialBackoff, err = time.ParseDuration(retryInitialBackoffEnv)
+		if err != nil || RetryInitialBackoff < 0 {
+			panic("Invalid duration value for " + retryInitialBackoff + ": " + retryInitialBackoffEnv)
+		}
+	}
+
+	if retryMaxBackoffEnv := os.Getenv(retryMaxBackoff); len(retryMaxBackoffEnv) > 0 {
+		var err error
+		RetryMaxBackoff, err = time.ParseDuration(retryMaxBackoffEnv)
+		if err != nil || RetryMaxBackoff < 0 {
+			panic("Invalid duration value for " + retryMaxBackoff + ": " + retryMaxBackoffEnv)
+		}
+	}
+
+	if retryBackoffMultiplierEnv := os.Getenv(retryBackoffMultiplier); len(retryBackoffMultiplierEnv) > 0 {
+		var err error
+		RetryBackoffMultiplier, err = strconv.ParseFloat(retryBackoffMultiplierEnv, 64)
+		if err != nil || RetryBackoffMultiplier < 1 {
+			panic("Invalid multiplier value (>= 1) for " + retryBackoffMultiplier + ": " + retryBackoffMultiplierEnv)
+		}
+	}
+
+	if retryJitterEnv := os.Getenv(retryJitter); len(retryJitterEnv) > 0 {
+		var err error
+		RetryJitter, err = strconv.ParseFloat(retryJitterEnv, 64)
+		if err != nil || RetryJitter < 0 || RetryJitter > 1 {
+			panic("Invalid fraction value (0 to 1) for " + retryJitter + ": " + retryJitterEnv)
+		}
+	}
+
 	if hierarchyEndpointEnv := os.Getenv(hierarchyEndpoint); len(hierarchyEndpointEnv) > 0 {
 		HierarchyEndpoint = hierarchyEndpointEnv
 	}
 
+	if hierarchySourceEnv := os.Getenv(hierarchySource); len(hierarchySourceEnv) > 0 {
+		if !strings.HasPrefix(hierarchySourceEnv, FileSourcePrefix) {
+			panic("Unsupported value for " + hierarchySource + " (expected " + FileSourcePrefix + "/path): " + hierarchySourceEnv)
+		}
+		HierarchySource = hierarchySourceEnv
+	}
+
+	if hierarchyConnectTimeoutEnv := os.Getenv(hierarchyConnectTimeout); len(hierarchyConnectTimeoutEnv) > 0 {
+		var err error
+		HierarchyConnectTimeout, err = time.ParseDuration(hierarchyConnectTimeoutEnv)
+		if err != nil || HierarchyConnectTimeout <= 0 {
+			panic("Invalid duration value for " + hierarchyConnectTimeout + ": " + hierarchyConnectTimeoutEnv)
+		}
+	}
+
+	if hierarchyReadTimeoutEnv := os.Getenv(hierarchyReadTimeout); len(hierarchyReadTimeoutEnv) > 0 {
+		var err error
+		HierarchyReadTimeout, err = time.ParseDuration(hierarchyReadTimeoutEnv)
+		if err != nil || HierarchyReadTimeout <= 0 {
+			panic("Invalid duration value for " + hierarchyReadTimeout + ": " + hierarchyReadTimeoutEnv)
+		}
+	}
+
+	if hierarchyRetryMaxAttemptsEnv := os.Getenv(hierarchyRetryMaxAttempts); len(hierarchyRetryMaxAttemptsEnv) > 0 {
+		var err error
+		HierarchyRetryMaxAttempts, err = strconv.Atoi(hierarchyRetryMaxAttemptsEnv)
+		if err != nil || HierarchyRetryMaxAttempts < 1 {
+			panic("Invalid positive integer value for " + hierarchyRetryMaxAttempts + ": " + hierarchyRetryMaxAttemptsEnv)
+		}
+	}
+
+	if hierarchyRetryBackoffEnv := os.Getenv(hierarchyRetryBackoff); len(hierarchyRetryBackoffEnv) > 0 {
+		var err error
+		HierarchyRetryBackoff, err = time.ParseDuration(hierarchyRetryBackoffEnv)
+		if err != nil || HierarchyRetryBackoff < 0 {
+			panic("Invalid duration value for " + hierarchyRetryBackoff + ": " + hierarchyRetryBackoffEnv)
+		}
+	}
+
+	if hierarchyBreakerErrorThresholdEnv := os.Getenv(hierarchyBreakerErrorThreshold); len(hierarchyBreakerErrorThresholdEnv) > 0 {
+		var err error
+		HierarchyBreakerErrorThreshold, err = strconv.Atoi(hierarchyBreakerErrorThresholdEnv)
+		if err != nil || HierarchyBreakerErrorThreshold < 1 {
+			panic("Invalid positive integer value for " + hierarchyBreakerErrorThreshold + ": " + hierarchyBreakerErrorThresholdEnv)
+		}
+	}
+
+	if hierarchyBreakerTimeoutEnv := os.Getenv(hierarchyBreakerTimeout); len(hierarchyBreakerTimeoutEnv) > 0 {
+		var err error
+		HierarchyBreakerTimeout, err = time.ParseDuration(hierarchyBreakerTimeoutEnv)
+		if err != nil || HierarchyBreakerTimeout <= 0 {
+			panic("Invalid duration value for " + hierarchyBreakerTimeout + ": " + hierarchyBreakerTimeoutEnv)
+		}
+	}
+
+	if hierarchyCacheTTLEnv := os.Getenv(hierarchyCacheTTL); len(hierarchyCacheTTLEnv) > 0 {
+		var err error
+		HierarchyCacheTTL, err = time.ParseDuration(hierarchyCacheTTLEnv)
+		if err != nil || HierarchyCacheTTL < 0 {
+			panic("Invalid duration value for " + hierarchyCacheTTL + ": " + hierarchyCacheTTLEnv)
+		}
+	}
+
+	if hierarchyCacheMaxEntriesEnv := os.Getenv(hierarchyCacheMaxEntries); len(hierarchyCacheMaxEntriesEnv) > 0 {
+		var err error
+		HierarchyCacheMaxEntries, err = strconv.Atoi(hierarchyCacheMaxEntriesEnv)
+		if err != nil || HierarchyCacheMaxEntries < 0 {
+			panic("Invalid integer value for " + hierarchyCacheMaxEntries + ": " + hierarchyCacheMaxEntriesEnv)
+		}
+	}
+
+	if hierarchyCacheMaxBytesEnv := os.Getenv(hierarchyCacheMaxBytes); len(hierarchyCacheMaxBytesEnv) > 0 {
+		var err error
+		HierarchyCacheMaxBytes, err = strconv.ParseInt(hierarchyCacheMaxBytesEnv, 10, 64)
+		if err != nil || HierarchyCacheMaxBytes < 0 {
+			panic("Invalid integer value for " + hierarchyCacheMaxBytes + ": " + hierarchyCacheMaxBytesEnv)
+		}
+	}
+
 	if useGzipCompressionEnv := os.Getenv(useGzipCompression); len(useGzipCompressionEnv) > 0 {
 		var err error
 		UseGzipCompression, err = strconv.ParseBool(useGzipCompressionEnv)
@@ -71,17 +447,177 @@ func init() {
 		}
 	}
 
+	if spoolOutputEnv := os.Getenv(spoolOutput); len(spoolOutputEnv) > 0 {
+		var err error
+		SpoolOutput, err = strconv.ParseBool(spoolOutputEnv)
+		if err != nil {
+			panic("Invalid boolean value for " + spoolOutput + ": " + spoolOutputEnv)
+		}
+	}
+
+	if spoolDirEnv := os.Getenv(spoolDir); len(spoolDirEnv) > 0 {
+		SpoolDir = spoolDirEnv
+	}
+
+	if unresolvedCodePolicyEnv := os.Getenv(unresolvedCodePolicy); len(unresolvedCodePolicyEnv) > 0 {
+		UnresolvedCodePolicy = unresolvedCodePolicyEnv
+	}
+
+	if unresolvedCodeThresholdEnv := os.Getenv(unresolvedCodeThreshold); len(unresolvedCodeThresholdEnv) > 0 {
+		UnresolvedCodeThreshold = unresolvedCodeThresholdEnv
+	}
+
+	if validationReportFormatEnv := os.Getenv(validationReportFormat); len(validationReportFormatEnv) > 0 {
+		if validationReportFormatEnv != "json" && validationReportFormatEnv != "csv" {
+			panic("Invalid value (json or csv) for " + validationReportFormat + ": " + validationReportFormatEnv)
+		}
+		ValidationReportFormat = validationReportFormatEnv
+	}
+
+	if headerAliasesEnv := os.Getenv(headerAliases); len(headerAliasesEnv) > 0 {
+		HeaderAliases = headerAliasesEnv
+	}
+
+	if strictValidationEnv := os.Getenv(strictValidation); len(strictValidationEnv) > 0 {
+		var err error
+		StrictValidation, err = strconv.ParseBool(strictValidationEnv)
+		if err != nil {
+			panic("Invalid boolean value for " + strictValidation + ": " + strictValidationEnv)
+		}
+	}
+
+	if rejectedRowLimitEnv := os.Getenv(rejectedRowLimit); len(rejectedRowLimitEnv) > 0 {
+		var err error
+		RejectedRowLimit, err = strconv.ParseInt(rejectedRowLimitEnv, 10, 64)
+		if err != nil || RejectedRowLimit < 0 {
+			panic("Invalid integer value for " + rejectedRowLimit + ": " + rejectedRowLimitEnv)
+		}
+	}
+
+	if hierarchyColumnsEnv := os.Getenv(hierarchyColumns); len(hierarchyColumnsEnv) > 0 {
+		var err error
+		HierarchyColumns, err = strconv.ParseBool(hierarchyColumnsEnv)
+		if err != nil {
+			panic("Invalid boolean value for " + hierarchyColumns + ": " + hierarchyColumnsEnv)
+		}
+	}
+
+	if timePeriodColumnsEnv := os.Getenv(timePeriodColumns); len(timePeriodColumnsEnv) > 0 {
+		var err error
+		TimePeriodColumns, err = strconv.ParseBool(timePeriodColumnsEnv)
+		if err != nil {
+			panic("Invalid boolean value for " + timePeriodColumns + ": " + timePeriodColumnsEnv)
+		}
+	}
+
+	if readinessS3BucketsEnv := os.Getenv(readinessS3Buckets); len(readinessS3BucketsEnv) > 0 {
+		for _, bucket := range strings.Split(readinessS3BucketsEnv, ",") {
+			if bucket = strings.TrimSpace(bucket); len(bucket) > 0 {
+				ReadinessS3Buckets = append(ReadinessS3Buckets, bucket)
+			}
+		}
+	}
+
+	if readinessCacheTTLEnv := os.Getenv(readinessCacheTTL); len(readinessCacheTTLEnv) > 0 {
+		var err error
+		ReadinessCacheTTL, err = time.ParseDuration(readinessCacheTTLEnv)
+		if err != nil || ReadinessCacheTTL < 0 {
+			panic("Invalid duration value for " + readinessCacheTTL + ": " + readinessCacheTTLEnv)
+		}
+	}
+
+	if readinessTimeoutEnv := os.Getenv(readinessTimeout); len(readinessTimeoutEnv) > 0 {
+		var err error
+		ReadinessTimeout, err = time.ParseDuration(readinessTimeoutEnv)
+		if err != nil || ReadinessTimeout <= 0 {
+			panic("Invalid duration value for " + readinessTimeout + ": " + readinessTimeoutEnv)
+		}
+	}
+
+	if jobStoreEnv := os.Getenv(jobStore); len(jobStoreEnv) > 0 {
+		if !strings.HasPrefix(jobStoreEnv, FileSourcePrefix) {
+			panic("Unsupported value for " + jobStore + " (expected " + FileSourcePrefix + "/path): " + jobStoreEnv)
+		}
+		JobStore = jobStoreEnv
+	}
+
+	if jobStoreMaxJobsEnv := os.Getenv(jobStoreMaxJobs); len(jobStoreMaxJobsEnv) > 0 {
+		var err error
+		JobStoreMaxJobs, err = strconv.Atoi(jobStoreMaxJobsEnv)
+		if err != nil || JobStoreMaxJobs < 0 {
+			panic("Invalid integer value for " + jobStoreMaxJobs + ": " + jobStoreMaxJobsEnv)
+		}
+	}
+
+	if jobTimeoutEnv := os.Getenv(jobTimeout); len(jobTimeoutEnv) > 0 {
+		var err error
+		JobTimeout, err = time.ParseDuration(jobTimeoutEnv)
+		if err != nil || JobTimeout < 0 {
+			panic("Invalid duration value for " + jobTimeout + ": " + jobTimeoutEnv)
+		}
+	}
+
+	if shutdownGracePeriodEnv := os.Getenv(shutdownGracePeriod); len(shutdownGracePeriodEnv) > 0 {
+		var err error
+		ShutdownGracePeriod, err = time.ParseDuration(shutdownGracePeriodEnv)
+		if err != nil || ShutdownGracePeriod < 0 {
+			panic("Invalid duration value for " + shutdownGracePeriod + ": " + shutdownGracePeriodEnv)
+		}
+	}
 }
 
 func Load() {
 	// Will call init().
 	log.Debug("dp-csv-transformer Configuration", log.Data{
-		bindAddrKey:        BindAddr,
-		kafkaAddrKey:       KafkaAddr,
-		awsRegionKey:       AWSRegion,
-		kafkaConsumerGroup: KafkaConsumerGroup,
-		kafkaConsumerTopic: KafkaConsumerTopic,
-		hierarchyEndpoint:  HierarchyEndpoint,
-		useGzipCompression: UseGzipCompression,
+		bindAddrKey:                    BindAddr,
+		kafkaAddrKey:                   KafkaAddr,
+		awsRegionKey:                   AWSRegion,
+		s3Endpoint:                     S3Endpoint,
+		s3ForcePathStyle:               S3ForcePathStyle,
+		fileStorageRoot:                FileStorageRoot,
+		kafkaConsumerGroup:             KafkaConsumerGroup,
+		kafkaConsumerTopic:             KafkaConsumerTopic,
+		kafkaCommitInterval:            KafkaCommitInterval.String(),
+		kafkaDeadLetterTopic:           KafkaDeadLetterTopic,
+		kafkaTransformCompleteTopic:    KafkaTransformCompleteTopic,
+		kafkaTransformFailedTopic:      KafkaTransformFailedTopic,
+		transformWorkers:               TransformWorkers,
+		rowWorkers:                     RowWorkers,
+		rowBatchSize:                   RowBatchSize,
+		preservePartitionOrder:         PreservePartitionOrder,
+		retryMaxAttempts:               RetryMaxAttempts,
+		retryInitialBackoff:            RetryInitialBackoff.String(),
+		retryMaxBackoff:                RetryMaxBackoff.String(),
+		retryBackoffMultiplier:         RetryBackoffMultiplier,
+		retryJitter:                    RetryJitter,
+		hierarchyEndpoint:              HierarchyEndpoint,
+		hierarchySource:                HierarchySource,
+		hierarchyConnectTimeout:        HierarchyConnectTimeout.String(),
+		hierarchyReadTimeout:           HierarchyReadTimeout.String(),
+		hierarchyRetryMaxAttempts:      HierarchyRetryMaxAttempts,
+		hierarchyRetryBackoff:          HierarchyRetryBackoff.String(),
+		hierarchyBreakerErrorThreshold: HierarchyBreakerErrorThreshold,
+		hierarchyBreakerTimeout:        HierarchyBreakerTimeout.String(),
+		hierarchyCacheTTL:              HierarchyCacheTTL.String(),
+		hierarchyCacheMaxEntries:       HierarchyCacheMaxEntries,
+		hierarchyCacheMaxBytes:         HierarchyCacheMaxBytes,
+		useGzipCompression:             UseGzipCompression,
+		spoolOutput:                    SpoolOutput,
+		spoolDir:                       SpoolDir,
+		unresolvedCodePolicy:           UnresolvedCodePolicy,
+		unresolvedCodeThreshold:        UnresolvedCodeThreshold,
+		validationReportFormat:         ValidationReportFormat,
+		headerAliases:                  HeaderAliases,
+		strictValidation:               StrictValidation,
+		rejectedRowLimit:               RejectedRowLimit,
+		hierarchyColumns:               HierarchyColumns,
+		timePeriodColumns:              TimePeriodColumns,
+		readinessS3Buckets:             ReadinessS3Buckets,
+		readinessCacheTTL:              ReadinessCacheTTL.String(),
+		readinessTimeout:               ReadinessTimeout.String(),
+		jobStore:                       JobStore,
+		jobStoreMaxJobs:                JobStoreMaxJobs,
+		jobTimeout:                     JobTimeout.String(),
+		shutdownGracePeriod:            ShutdownGracePeriod.String(),
 	})
 }
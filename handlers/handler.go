@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
@@ -11,97 +14,348 @@ import (
 
 	"fmt"
 
-	"github.com/ONSdigital/dp-dd-csv-transformer/ons_aws"
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
 	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/ONSdigital/dp-dd-csv-transformer/storage"
 	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
 	"github.com/ONSdigital/go-ns/log"
 )
 
 const csvFileExt = ".csv"
 
+// statsFileSuffix is appended to the output url to give the url the stats of the transform are saved to.
+const statsFileSuffix = ".stats.json"
+
+// validationReportFileSuffix is appended to the output url, followed by the ValidationReportFormat, to give the url the
+// validation report is saved to.
+const validationReportFileSuffix = ".validation."
+
+// rejectedRowsFileSuffix is appended to the output url to give the url the rows rejected in strict mode are saved to.
+const rejectedRowsFileSuffix = ".rejected.csv"
+
 type requestBodyReader func(r io.Reader) ([]byte, error)
 
 // TransformResponse struct defines the response for the /transformer API.
 type TransformResponse struct {
-	Message string `json:"message,omitempty"`
+	Message  string             `json:"message,omitempty"`
+	RowCount int64              `json:"rowCount,omitempty"`
+	Stats    *transformer.Stats `json:"stats,omitempty"`
+	Stage    string             `json:"stage,omitempty"`
+	Attempts []retry.Attempt    `json:"attempts,omitempty"`
+	Err      error              `json:"-"`
 }
 
-// TransformFunc defines a function (implemented by HandleRequest) that performs the transformering requested in a TransformRequest
-type TransformFunc func(event.TransformRequest) TransformResponse
+// TransformFunc defines a function (implemented by HandleRequest) that performs the transformering requested in a
+// TransformRequest, stopping if the context is done.
+type TransformFunc func(context.Context, event.TransformRequest) TransformResponse
 
 var unsupportedFileTypeErr = errors.New("Unspported file type.")
-var awsClientErr = errors.New("Error while attempting get to get from from AWS s3 bucket.")
-var awsService = ons_aws.NewService()
+var getInputErr = errors.New("Error while attempting to get the input file.")
+var storageService = storage.NewService()
 var csvTransformer transformer.CSVTransformer = transformer.NewTransformer()
+var retryPolicy = retry.NewPolicy()
+var jobRegistry = jobs.NewRegistry(jobs.NewStore())
+
+// JobTimeoutError the error of a request that did not finish within the JobTimeout.
+type JobTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e JobTimeoutError) Error() string {
+	return fmt.Sprintf("The transform did not finish within the job timeout of %s.", e.Timeout)
+}
 
 // Responses
-var transformRespUnsupportedFileType = TransformResponse{"Unspported file type. Please specify a filePath for a .csv file."}
-var transformResponseSuccess = TransformResponse{"Your request is being processed."}
+var transformRespUnsupportedFileType = TransformResponse{Message: "Unspported file type. Please specify a filePath for a .csv file.", Stage: event.StageParse, Err: unsupportedFileTypeErr}
+var transformResponseSuccess = TransformResponse{Message: "Your request is being processed."}
 
-// Performs the transforming as specified in the TransformRequest, returning a TransformResponse
-func HandleRequest(transformRequest event.TransformRequest) (resp TransformResponse) {
+// newErrorResponse creates a TransformResponse for a request that failed at the given stage with the given error.
+func newErrorResponse(stage string, err error) TransformResponse {
+	return TransformResponse{Message: err.Error(), Stage: stage, Err: err}
+}
+
+// QueueRequest records the request as queued in the job registry, once it has been received and before it is passed
+// to HandleRequest, which moves the job on through its states.
+func QueueRequest(transformRequest event.TransformRequest) {
+	jobRegistry.Queue(transformRequest.RequestID, transformRequest.InputURL.String(), transformRequest.OutputURL.String())
+}
 
+// Performs the transforming as specified in the TransformRequest, returning a TransformResponse. The request is
+// retried according to the retry policy if it fails with a retryable error. The request stops if the context is done,
+// it takes longer than the JobTimeout, it is cancelled through the job registry, or the service is shutting down. The
+// request is only recorded in the job registry if it was queued (see QueueRequest).
+func HandleRequest(ctx context.Context, transformRequest event.TransformRequest) (resp TransformResponse) {
+
+	if config.JobTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, config.JobTimeout)
+		defer cancelTimeout()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	finish, ok := inFlight.start(cancel)
+	if !ok {
+		log.ErrorC(transformRequest.RequestID, jobs.JobInterruptedErr, nil)
+		jobRegistry.Finish(transformRequest.RequestID, nil, event.StageParse, jobs.JobInterruptedErr)
+		return newErrorResponse(event.StageParse, jobs.JobInterruptedErr)
+	}
+	defer finish()
+
+	defer jobRegistry.Start(transformRequest.RequestID, cancel)()
+	jobsInFlight.Inc()
 	startTime := time.Now()
 	defer func() {
+		jobsInFlight.Dec()
+		recordResponse(resp)
+		jobRegistry.Finish(transformRequest.RequestID, resp.Stats, resp.Stage, resp.Err)
 		endTime := time.Now()
-		log.DebugC(transformRequest.RequestID, fmt.Sprintf("Processed TransformRequest, duration_ns: %d", endTime.Sub(startTime).Nanoseconds()), log.Data{"start": startTime, "end": endTime})
+		log.DebugC(transformRequest.RequestID, fmt.Sprintf("Processed TransformRequest, duration_ns: %d", endTime.Sub(startTime).Nanoseconds()), log.Data{"start": startTime, "end": endTime, "attempts": resp.Attempts, "hierarchyCache": hierarchy.GetCacheStats()})
 	}()
 
+	attempts, err := retryPolicy.Do(ctx, transformRequest.RequestID, func(attempt int) error {
+		resp = handleRequestAttempt(ctx, transformRequest)
+		return resp.Err
+	})
+	if err != nil && ctx.Err() != nil {
+		// the request stopped because it was cancelled or timed out, whichever error the attempt ended with
+		switch {
+		case inFlight.wasInterrupted():
+			err = jobs.JobInterruptedErr
+		case ctx.Err() == context.DeadlineExceeded:
+			err = JobTimeoutError{Timeout: config.JobTimeout}
+		default:
+			err = jobs.JobCancelledErr
+		}
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"stage": resp.Stage})
+		resp.Message, resp.Err = err.Error(), err
+	}
+	resp.Attempts = attempts
+	return resp
+}
+
+// handleRequestAttempt makes a single attempt at the transform.
+func handleRequestAttempt(ctx context.Context, transformRequest event.TransformRequest) (resp TransformResponse) {
+
 	if fileType := filepath.Ext(transformRequest.InputURL.GetFilePath()); fileType != csvFileExt {
 		log.ErrorC(transformRequest.RequestID, unsupportedFileTypeErr, log.Data{"expected": csvFileExt, "actual": fileType})
 		return transformRespUnsupportedFileType
 	}
 
-	awsReadCloser, err := awsService.GetCSV(transformRequest.RequestID, transformRequest.InputURL)
-	defer awsReadCloser.Close()
+	jobRegistry.SetState(transformRequest.RequestID, jobs.StateDownloading)
+	downloadStart := time.Now()
+	inputReadCloser, err := storageService.GetCSV(ctx, transformRequest.RequestID, transformRequest.InputURL)
+	observeStage(event.StageDownload, downloadStart)
 	if err != nil {
-		log.ErrorC(transformRequest.RequestID, awsClientErr, log.Data{"details": err.Error()})
-		return TransformResponse{err.Error()}
+		log.ErrorC(transformRequest.RequestID, getInputErr, log.Data{"details": err.Error()})
+		return newErrorResponse(event.StageDownload, err)
 	}
+	defer inputReadCloser.Close()
 
-	outputFileLocation := "/var/tmp/csv_transformer_" + transformRequest.RequestID + "_" + strconv.Itoa(time.Now().Nanosecond()) + ".csv"
-	outputFile, err := os.Create(outputFileLocation)
+	jobRegistry.SetState(transformRequest.RequestID, jobs.StateTransforming)
+	stage := event.StageTransform
+	defer func() {
+		if r := recover(); r != nil {
+			log.ErrorC(transformRequest.RequestID, errors.New(fmt.Sprintf("%v", r)), log.Data{"inputUrl": transformRequest.InputURL, "outputUrl": transformRequest.OutputURL})
+			resp = newErrorResponse(stage, fmt.Errorf("%s", r))
+		}
+	}()
+
+	var stats *transformer.Stats
+	transform := func(w io.Writer) error {
+		var err error
+		stats, err = csvTransformer.Transform(ctx, inputReadCloser, w, hierarchy.NewHierarchyClient(), transformRequest.RequestID, transformOptions(transformRequest))
+		recordStats(stats)
+		return err
+	}
+
+	if config.SpoolOutput {
+		stage, err = spoolOutput(ctx, transformRequest, transform)
+	} else {
+		stage, err = streamOutput(ctx, transformRequest, transform)
+	}
+	if stats != nil && stats.HasValidationIssues() {
+		saveValidationReport(ctx, transformRequest, stats.ValidationReport())
+	}
+	if stats != nil && stats.HasRejectedRows() {
+		saveRejectedRows(ctx, transformRequest, stats)
+	}
 	if err != nil {
-		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Error creating temp output file  " + outputFileLocation})
-		return TransformResponse{err.Error()}
+		resp = newErrorResponse(stage, err)
+		resp.Stats = stats
+		return resp
 	}
 
+	saveStats(ctx, transformRequest, stats)
+
+	resp = transformResponseSuccess
+	resp.RowCount = stats.RowsWritten
+	resp.Stats = stats
+	return resp
+}
+
+// transformOptions returns the options for the transform, from the config unless overridden by the request.
+func transformOptions(transformRequest event.TransformRequest) transformer.Options {
+	options := transformer.Options{HierarchyColumns: config.HierarchyColumns, TimePeriodColumns: config.TimePeriodColumns}
+	if transformRequest.HierarchyColumns != nil {
+		options.HierarchyColumns = *transformRequest.HierarchyColumns
+	}
+	if transformRequest.TimePeriodColumns != nil {
+		options.TimePeriodColumns = *transformRequest.TimePeriodColumns
+	}
+	return options
+}
+
+// saveValidationReport writes the report of unresolved codes alongside the output, in the ValidationReportFormat. It is
+// saved even if the transform failed, and a failure to save it is only logged.
+func saveValidationReport(ctx context.Context, transformRequest event.TransformRequest, report transformer.ValidationReport) {
+	var buf bytes.Buffer
+	var err error
+	if config.ValidationReportFormat == "csv" {
+		err = report.WriteCSV(&buf)
+	} else {
+		err = report.WriteJSON(&buf)
+	}
+	reportURL := transformRequest.OutputURL.WithSuffix(validationReportFileSuffix + config.ValidationReportFormat)
+	if err == nil {
+		err = storageService.SaveFile(ctx, transformRequest.RequestID, &buf, reportURL)
+	}
+	if err != nil {
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to save validation report", "reportUrl": reportURL.String()})
+	}
+}
+
+// saveRejectedRows writes the quarantine file of rows rejected in strict mode alongside the output. It is saved even if
+// the transform failed, and a failure to save it is only logged.
+func saveRejectedRows(ctx context.Context, transformRequest event.TransformRequest, stats *transformer.Stats) {
+	var buf bytes.Buffer
+	rejectedURL := transformRequest.OutputURL.WithSuffix(rejectedRowsFileSuffix)
+	err := stats.WriteRejectedRows(&buf)
+	if err == nil {
+		err = storageService.SaveFile(ctx, transformRequest.RequestID, &buf, rejectedURL)
+	}
+	if err != nil {
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to save rejected rows", "rejectedUrl": rejectedURL.String()})
+	}
+}
+
+// saveStats writes the stats to a json file alongside the output. As the transform has succeeded a failure is only
+// logged.
+func saveStats(ctx context.Context, transformRequest event.TransformRequest, stats *transformer.Stats) {
+	statsURL := transformRequest.OutputURL.WithSuffix(statsFileSuffix)
+	statsJson, err := json.MarshalIndent(stats, "", "  ")
+	if err == nil {
+		err = storageService.SaveFile(ctx, transformRequest.RequestID, bytes.NewReader(statsJson), statsURL)
+	}
+	if err != nil {
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to save stats file", "statsUrl": statsURL.String()})
+	}
+}
+
+// streamOutput pipes the output of the transform straight into the upload. If the transform fails the upload is
+// aborted, and if the upload fails the transform's writes fail, in which case the request failed at the upload. The
+// stage at which the request failed is returned with the error.
+func streamOutput(ctx context.Context, transformRequest event.TransformRequest, transform func(w io.Writer) error) (string, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	uploadErr := make(chan error, 1)
+	go func() {
+		defer observeStage(event.StageUpload, time.Now())
+		err := storageService.SaveFile(ctx, transformRequest.RequestID, pipeReader, transformRequest.OutputURL)
+		// the result is sent before the pipe is closed, so a transform whose writes fail can tell the upload has ended
+		uploadErr <- err
+		// if the upload ended before reading all of the output, don't leave the transform blocked writing to the pipe
+		pipeReader.CloseWithError(err)
+	}()
+
 	defer func() {
 		if r := recover(); r != nil {
-			log.ErrorC(transformRequest.RequestID, errors.New(fmt.Sprintf("%v", r)), log.Data{"inputUrl": transformRequest.InputURL, "outputUrl": transformRequest.OutputURL})
-			resp = TransformResponse{fmt.Sprintf("%s", r)}
+			pipeWriter.CloseWithError(fmt.Errorf("%v", r))
+			<-uploadErr
+			panic(r)
 		}
-		os.Remove(outputFileLocation)
 	}()
 
-	err = csvTransformer.Transform(awsReadCloser, bufio.NewWriter(outputFile), hierarchy.NewHierarchyClient(), transformRequest.RequestID)
+	err := transform(pipeWriter)
 	if err != nil {
-		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to transform"})
-		return TransformResponse{err.Error()}
+		select {
+		case saveErr := <-uploadErr:
+			// the upload ended first, so the transform failed because it could no longer write its output
+			if saveErr != nil {
+				log.ErrorC(transformRequest.RequestID, saveErr, log.Data{"message": "Failed to save output file", "OutputURL": transformRequest.OutputURL})
+				return event.StageUpload, saveErr
+			}
+			log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to transform"})
+		default:
+			log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to transform, aborting upload"})
+			pipeWriter.CloseWithError(err)
+			<-uploadErr
+		}
+		return event.StageTransform, err
 	}
+	pipeWriter.Close()
 
-	tmpFile, err := os.Open(outputFileLocation)
+	// the output has been uploaded as it was written, so only the end of the upload remains
+	jobRegistry.SetState(transformRequest.RequestID, jobs.StateUploading)
+	if err = <-uploadErr; err != nil {
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to save output file", "OutputURL": transformRequest.OutputURL})
+		return event.StageUpload, err
+	}
+	return "", nil
+}
+
+// spoolOutput writes the output of the transform to a temporary file, which is then uploaded. The stage at which the
+// request failed is returned with the error.
+func spoolOutput(ctx context.Context, transformRequest event.TransformRequest, transform func(w io.Writer) error) (string, error) {
+	outputFileLocation := filepath.Join(config.SpoolDir, "csv_transformer_"+transformRequest.RequestID+"_"+strconv.Itoa(time.Now().Nanosecond())+".csv")
+	outputFile, err := os.Create(outputFileLocation)
 	if err != nil {
-		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to get tmp output file for s3 uploading!", "outputFileLocation": outputFileLocation})
-		return TransformResponse{err.Error()}
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Error creating temp output file  " + outputFileLocation})
+		return event.StageTransform, err
 	}
+	defer os.Remove(outputFileLocation)
+	defer outputFile.Close()
 
-	err = awsService.SaveFile(transformRequest.RequestID, bufio.NewReader(tmpFile), transformRequest.OutputURL)
+	bufferedWriter := bufio.NewWriter(outputFile)
+	err = transform(bufferedWriter)
+	if err == nil {
+		err = bufferedWriter.Flush()
+	}
 	if err != nil {
-		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to save output file to ons_aws", "OutputURL": transformRequest.OutputURL})
-		return TransformResponse{err.Error()}
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to transform"})
+		return event.StageTransform, err
 	}
 
-	os.Remove(outputFileLocation)
+	if _, err = outputFile.Seek(0, io.SeekStart); err != nil {
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to rewind tmp output file for uploading!", "outputFileLocation": outputFileLocation})
+		return event.StageUpload, err
+	}
 
-	return transformResponseSuccess
+	jobRegistry.SetState(transformRequest.RequestID, jobs.StateUploading)
+	uploadStart := time.Now()
+	err = storageService.SaveFile(ctx, transformRequest.RequestID, bufio.NewReader(outputFile), transformRequest.OutputURL)
+	observeStage(event.StageUpload, uploadStart)
+	if err != nil {
+		log.ErrorC(transformRequest.RequestID, err, log.Data{"message": "Failed to save output file", "OutputURL": transformRequest.OutputURL})
+		return event.StageUpload, err
+	}
+	return "", nil
 }
 
 func setCSVTransformer(t transformer.CSVTransformer) {
 	csvTransformer = t
 }
 
-func setAWSClient(c ons_aws.AWSService) {
-	awsService = c
+func setStorageService(s storage.Service) {
+	storageService = s
+}
+
+func setRetryPolicy(p retry.Policy) {
+	retryPolicy = p
+}
+
+func setJobRegistry(r *jobs.Registry) {
+	jobRegistry = r
 }
@@ -2,14 +2,25 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
-	"github.com/ONSdigital/dp-dd-csv-transformer/ons_aws"
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
 	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/dp-dd-csv-transformer/metrics"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/ONSdigital/dp-dd-csv-transformer/storage"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
 	. "github.com/smartystreets/goconvey/convey"
 	"io/ioutil"
 )
@@ -18,34 +29,55 @@ var mutex = &sync.Mutex{}
 
 const PANIC_MESSAGE = "Panic!!!"
 
-// MockAWSCli mock implementation of ons_aws.Client
+// MockAWSCli mock implementation of storage.Service
 type MockAWSCli struct {
 	requestedFiles map[string]int
 	savedFiles     map[string]int
 	fileBytes      []byte
 	getCsvErr      error
+	getCsvErrs     []error
 	saveFileErr    error
+	// earlySaveErr if set, is returned by SaveFile before reading any of the output, e.g. access denied
+	earlySaveErr error
+	abortedFiles map[string]int
+	savedBytes   map[string][]byte
 }
 
 func newMockAwsClient() *MockAWSCli {
-	mock := &MockAWSCli{requestedFiles: make(map[string]int), savedFiles: make(map[string]int)}
-	setAWSClient(mock)
+	mock := &MockAWSCli{requestedFiles: make(map[string]int), savedFiles: make(map[string]int), abortedFiles: make(map[string]int), savedBytes: make(map[string][]byte)}
+	setStorageService(mock)
 	return mock
 }
 
-func (mock *MockAWSCli) GetCSV(requestId string, fileURI ons_aws.S3URL) (io.ReadCloser, error) {
+func (mock *MockAWSCli) GetCSV(ctx context.Context, requestId string, fileURI storage.URL) (io.ReadCloser, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
 	mock.requestedFiles[fileURI.String()]++
+	if len(mock.getCsvErrs) > 0 {
+		err := mock.getCsvErrs[0]
+		mock.getCsvErrs = mock.getCsvErrs[1:]
+		return ioutil.NopCloser(bytes.NewReader(mock.fileBytes)), err
+	}
 	return ioutil.NopCloser(bytes.NewReader(mock.fileBytes)), mock.getCsvErr
 }
 
-func (mock *MockAWSCli) SaveFile(requestId string, reader io.Reader, filePath ons_aws.S3URL) error {
+func (mock *MockAWSCli) SaveFile(ctx context.Context, requestId string, reader io.Reader, filePath storage.URL) error {
+	if mock.earlySaveErr != nil {
+		return mock.earlySaveErr
+	}
+	// read the output before locking, as it may be streamed from a transform that is still running
+	content, err := ioutil.ReadAll(reader)
+
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	if err != nil {
+		mock.abortedFiles[filePath.String()]++
+		return err
+	}
 	mock.savedFiles[filePath.String()]++
+	mock.savedBytes[filePath.String()] = content
 	return mock.saveFileErr
 }
 
@@ -65,11 +97,21 @@ func (mock *MockAWSCli) countOfSaveInvocations(uri string) int {
 	return mock.savedFiles[uri]
 }
 
+func (mock *MockAWSCli) countOfAbortedSaves(uri string) int {
+	return mock.abortedFiles[uri]
+}
+
 // MockCSVTransformer
 type MockCSVTransformer struct {
 	invocations int
 	shouldPanic bool
 	err         error
+	output      string
+	unresolved  map[string]int64
+	rejected    int64
+	options     transformer.Options
+	// started if set, is closed once the transform has started, which then blocks until its context is done
+	started chan struct{}
 }
 
 func newMockCSVTransformer() *MockCSVTransformer {
@@ -79,14 +121,28 @@ func newMockCSVTransformer() *MockCSVTransformer {
 }
 
 // Transform mock implementation of the Transform function.
-func (t *MockCSVTransformer) Transform(r io.Reader, w io.Writer, hc hierarchy.HierarchyClient, requestId string) error {
+func (t *MockCSVTransformer) Transform(ctx context.Context, r io.Reader, w io.Writer, hc hierarchy.HierarchyClient, requestId string, options transformer.Options) (*transformer.Stats, error) {
+	if t.started != nil {
+		close(t.started)
+		<-ctx.Done()
+		return &transformer.Stats{}, ctx.Err()
+	}
 	mutex.Lock()
 	defer mutex.Unlock()
 	t.invocations++
+	t.options = options
+	rows := int64(strings.Count(t.output, "\n"))
+	if rows > 0 {
+		rows--
+	}
+	stats := &transformer.Stats{RowsRead: rows + t.rejected, RowsWritten: rows, RowsRejected: t.rejected, UnresolvedCodes: t.unresolved}
+	if _, err := io.WriteString(w, t.output); err != nil {
+		return stats, err
+	}
 	if t.shouldPanic {
 		panic(PANIC_MESSAGE)
 	}
-	return t.err
+	return stats, t.err
 }
 
 func TestHandler(t *testing.T) {
@@ -98,9 +154,9 @@ func TestHandler(t *testing.T) {
 		outputFile := "s3://bucket/test.out"
 		transformRequest := createTransformRequest(inputFile, outputFile)
 
-		response := HandleRequest(transformRequest)
+		response := HandleRequest(context.Background(), transformRequest)
 
-		So(response, ShouldResemble, transformResponseSuccess)
+		So(withoutDetails(response), ShouldResemble, transformResponseSuccess)
 		So(1, ShouldEqual, mockAWSCli.getTotalInvocations())
 		So(1, ShouldEqual, mockAWSCli.getInvocationsByURI(inputFile))
 		So(1, ShouldEqual, mockAWSCli.countOfSaveInvocations(outputFile))
@@ -114,12 +170,12 @@ func TestHandler(t *testing.T) {
 		mockAWSCli, mockCSVTransformer := setMocks()
 		mockAWSCli.getCsvErr = errors.New(awsErrMsg)
 
-		response := HandleRequest(createTransformRequest(uri, uri))
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
 
 		So(1, ShouldEqual, mockAWSCli.getTotalInvocations())
 		So(1, ShouldEqual, mockAWSCli.getInvocationsByURI(uri))
 		So(0, ShouldEqual, mockCSVTransformer.invocations)
-		So(response, ShouldResemble, TransformResponse{awsErrMsg})
+		So(withoutDetails(response), ShouldResemble, newErrorResponse(event.StageDownload, errors.New(awsErrMsg)))
 	})
 
 	Convey("Should return appropriate error if the awsClient returns an error on save.", t, func() {
@@ -129,12 +185,26 @@ func TestHandler(t *testing.T) {
 		mockAWSCli, mockCSVTransformer := setMocks()
 		mockAWSCli.saveFileErr = errors.New(awsErrMsg)
 
-		response := HandleRequest(createTransformRequest(uri, uri))
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
 
 		So(1, ShouldEqual, mockAWSCli.getTotalInvocations())
 		So(1, ShouldEqual, mockAWSCli.getInvocationsByURI(uri))
 		So(1, ShouldEqual, mockCSVTransformer.invocations)
-		So(response, ShouldResemble, TransformResponse{awsErrMsg})
+		So(withoutDetails(response), ShouldResemble, newErrorResponse(event.StageUpload, errors.New(awsErrMsg)))
+	})
+
+	Convey("Should report the upload as the failed stage if it fails before the output has been written.", t, func() {
+		uri := "s3://bucket/target.csv"
+		awsErr := errors.New("AccessDenied")
+
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockAWSCli.earlySaveErr = awsErr
+		mockCSVTransformer.output = largeOutput()
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(1, ShouldEqual, mockCSVTransformer.invocations)
+		So(withoutDetails(response), ShouldResemble, newErrorResponse(event.StageUpload, awsErr))
 	})
 
 	Convey("Should return success response for happy path scenario", t, func() {
@@ -142,12 +212,12 @@ func TestHandler(t *testing.T) {
 
 		mockAWSCli, mockCSVTransformer := setMocks()
 
-		response := HandleRequest(createTransformRequest(uri, uri))
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
 
 		So(1, ShouldEqual, mockAWSCli.getTotalInvocations())
 		So(1, ShouldEqual, mockAWSCli.getInvocationsByURI(uri))
 		So(1, ShouldEqual, mockCSVTransformer.invocations)
-		So(response, ShouldResemble, transformResponseSuccess)
+		So(withoutDetails(response), ShouldResemble, transformResponseSuccess)
 	})
 
 	Convey("Should return appropriate error for unsupported file types", t, func() {
@@ -155,11 +225,11 @@ func TestHandler(t *testing.T) {
 
 		mockAWSCli, mockCSVTransformer := setMocks()
 
-		response := HandleRequest(createTransformRequest(uri, uri))
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
 
 		So(0, ShouldEqual, mockAWSCli.getTotalInvocations())
 		So(0, ShouldEqual, mockCSVTransformer.invocations)
-		So(response, ShouldResemble, transformRespUnsupportedFileType)
+		So(withoutDetails(response), ShouldResemble, transformRespUnsupportedFileType)
 	})
 
 	Convey("Should handle a panic.", t, func() {
@@ -170,15 +240,304 @@ func TestHandler(t *testing.T) {
 
 		mockCSVTransformer.shouldPanic = true
 
-		response := HandleRequest(createTransformRequest(inputFile, outputFile))
+		response := HandleRequest(context.Background(), createTransformRequest(inputFile, outputFile))
 
-		So(response, ShouldResemble, TransformResponse{PANIC_MESSAGE})
+		So(withoutDetails(response), ShouldResemble, newErrorResponse(event.StageTransform, errors.New(PANIC_MESSAGE)))
 		So(1, ShouldEqual, mockAWSCli.getTotalInvocations())
 		So(1, ShouldEqual, mockAWSCli.getInvocationsByURI(inputFile))
 		So(0, ShouldEqual, mockAWSCli.countOfSaveInvocations(outputFile))
 		So(1, ShouldEqual, mockCSVTransformer.invocations)
 	})
 
+	Convey("Should stream the output to the upload.", t, func() {
+		uri := "s3://bucket/target.csv"
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.output = largeOutput()
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(withoutDetails(response).Message, ShouldEqual, transformResponseSuccess.Message)
+		So(response.RowCount, ShouldEqual, 999)
+		So(string(mockAWSCli.savedBytes[uri]), ShouldEqual, mockCSVTransformer.output)
+	})
+
+	Convey("Should save the stats alongside the output.", t, func() {
+		uri := "s3://bucket/target.csv"
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.output = largeOutput()
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(response.Stats.RowsWritten, ShouldEqual, 999)
+		var saved transformer.Stats
+		So(json.Unmarshal(mockAWSCli.savedBytes[uri+statsFileSuffix], &saved), ShouldBeNil)
+		So(saved, ShouldResemble, *response.Stats)
+	})
+
+	Convey("Should save a validation report alongside the output if there are unresolved codes.", t, func() {
+		uri := "s3://bucket/target.csv"
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.unresolved = map[string]int64{"2011STATH": 3}
+		mockCSVTransformer.err = errors.New("Too many unresolved codes")
+
+		HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		var report transformer.ValidationReport
+		So(json.Unmarshal(mockAWSCli.savedBytes[uri+".validation.json"], &report), ShouldBeNil)
+		So(report.Unresolved, ShouldEqual, 3)
+	})
+
+	Convey("Should use the request's hierarchy columns option, or the config if it is not set.", t, func() {
+		uri := "s3://bucket/target.csv"
+		_, mockCSVTransformer := setMocks()
+		request := createTransformRequest(uri, uri)
+
+		HandleRequest(context.Background(), request)
+		So(mockCSVTransformer.options.HierarchyColumns, ShouldEqual, config.HierarchyColumns)
+
+		hierarchyColumns := !config.HierarchyColumns
+		request.HierarchyColumns = &hierarchyColumns
+		HandleRequest(context.Background(), request)
+		So(mockCSVTransformer.options.HierarchyColumns, ShouldEqual, hierarchyColumns)
+	})
+
+	Convey("Should save the rejected rows alongside the output if rows were rejected.", t, func() {
+		uri := "s3://bucket/target.csv"
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.output = largeOutput()
+		mockCSVTransformer.rejected = 2
+
+		HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(mockAWSCli.countOfSaveInvocations(uri+rejectedRowsFileSuffix), ShouldEqual, 1)
+		So(string(mockAWSCli.savedBytes[uri+rejectedRowsFileSuffix]), ShouldStartWith, "Row,Reason")
+	})
+
+	Convey("Should abort the upload if the transform fails.", t, func() {
+		uri := "s3://bucket/target.csv"
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.output = "partial,output\n"
+		mockCSVTransformer.err = errors.New("Invalid csv")
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(response.Stage, ShouldEqual, event.StageTransform)
+		So(0, ShouldEqual, mockAWSCli.countOfSaveInvocations(uri))
+		So(1, ShouldEqual, mockAWSCli.countOfAbortedSaves(uri))
+	})
+
+	Convey("Should upload the whole output when spooling to a temp file.", t, func() {
+		uri := "s3://bucket/target.csv"
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.output = largeOutput()
+		config.SpoolOutput, config.SpoolDir = true, os.TempDir()
+		defer func() { config.SpoolOutput = false }()
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(withoutDetails(response).Message, ShouldEqual, transformResponseSuccess.Message)
+		So(response.RowCount, ShouldEqual, 999)
+		So(string(mockAWSCli.savedBytes[uri]), ShouldEqual, mockCSVTransformer.output)
+	})
+
+	Convey("Should retry a request that fails with a retryable error.", t, func() {
+		uri := "s3://bucket/target.csv"
+		awsErrMsg := "THIS IS A TRANSIENT AWS ERROR"
+
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockAWSCli.getCsvErrs = []error{retry.NewRetryableError(errors.New(awsErrMsg))}
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(withoutDetails(response), ShouldResemble, transformResponseSuccess)
+		So(2, ShouldEqual, mockAWSCli.getInvocationsByURI(uri))
+		So(1, ShouldEqual, mockCSVTransformer.invocations)
+		So(len(response.Attempts), ShouldEqual, 2)
+		So(response.Attempts[0].Error, ShouldEqual, awsErrMsg)
+		So(response.Attempts[0].Retryable, ShouldBeTrue)
+		So(response.Attempts[1].Error, ShouldBeEmpty)
+	})
+
+	Convey("Should give up after the maximum number of attempts.", t, func() {
+		uri := "s3://bucket/target.csv"
+		awsErrMsg := "THIS IS A TRANSIENT AWS ERROR"
+
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockAWSCli.getCsvErr = retry.NewRetryableError(errors.New(awsErrMsg))
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(response.Message, ShouldEqual, awsErrMsg)
+		So(response.Stage, ShouldEqual, event.StageDownload)
+		So(3, ShouldEqual, mockAWSCli.getInvocationsByURI(uri))
+		So(0, ShouldEqual, mockCSVTransformer.invocations)
+		So(len(response.Attempts), ShouldEqual, 3)
+	})
+
+	Convey("Should not retry a request that fails with a permanent error.", t, func() {
+		uri := "s3://bucket/target.csv"
+
+		mockAWSCli, mockCSVTransformer := setMocks()
+		mockCSVTransformer.err = errors.New("Invalid csv")
+
+		response := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(response.Stage, ShouldEqual, event.StageTransform)
+		So(1, ShouldEqual, mockAWSCli.getInvocationsByURI(uri))
+		So(1, ShouldEqual, mockCSVTransformer.invocations)
+		So(len(response.Attempts), ShouldEqual, 1)
+		So(response.Attempts[0].Retryable, ShouldBeFalse)
+	})
+
+	Convey("Should count the request, and the rows it transformed, in the metrics.", t, func() {
+		uri := "s3://bucket/target.csv"
+		successes := metricValue(`csv_transformer_requests_total{outcome="success",stage=""}`)
+		failures := metricValue(`csv_transformer_requests_total{outcome="failure",stage="transform"}`)
+		rows := metricValue("csv_transformer_rows_written_total")
+
+		_, mockCSVTransformer := setMocks()
+		mockCSVTransformer.output = "Observation\n1\n2\n"
+		HandleRequest(context.Background(), createTransformRequest(uri, uri))
+		mockCSVTransformer.err = errors.New("Invalid csv")
+		HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(metricValue(`csv_transformer_requests_total{outcome="success",stage=""}`), ShouldEqual, successes+1)
+		So(metricValue(`csv_transformer_requests_total{outcome="failure",stage="transform"}`), ShouldEqual, failures+1)
+		So(metricValue("csv_transformer_rows_written_total"), ShouldEqual, rows+4)
+		So(metricValue("csv_transformer_jobs_in_flight"), ShouldEqual, 0)
+	})
+
+	Convey("Should record the states of a successful job.", t, func() {
+		uri := "s3://bucket/target.csv"
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+
+		setMocks()
+		request := createTransformRequest(uri, uri)
+		QueueRequest(request)
+		HandleRequest(context.Background(), request)
+
+		job, err := jobRegistry.Get("foo")
+		So(err, ShouldBeNil)
+		So(job.State, ShouldEqual, jobs.StateSucceeded)
+		So(job.InputURL, ShouldEqual, uri)
+		So(jobStates(job), ShouldResemble, []string{jobs.StateQueued, jobs.StateDownloading, jobs.StateTransforming, jobs.StateUploading, jobs.StateSucceeded})
+		So(job.Stats, ShouldNotBeNil)
+		So(job.Error, ShouldBeEmpty)
+	})
+
+	Convey("Should record the stage and error of a failed job, and each retry.", t, func() {
+		uri := "s3://bucket/target.csv"
+		awsErrMsg := "THIS IS AN AWS ERROR"
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+
+		mockAWSCli, _ := setMocks()
+		mockAWSCli.getCsvErr = retry.NewRetryableError(errors.New(awsErrMsg))
+		setRetryPolicy(retry.Policy{MaxAttempts: 2})
+		request := createTransformRequest(uri, uri)
+		QueueRequest(request)
+		HandleRequest(context.Background(), request)
+
+		job, err := jobRegistry.Get("foo")
+		So(err, ShouldBeNil)
+		So(jobStates(job), ShouldResemble, []string{jobs.StateQueued, jobs.StateDownloading, jobs.StateDownloading, jobs.StateFailed})
+		So(job.Stage, ShouldEqual, event.StageDownload)
+		So(job.Error, ShouldEqual, awsErrMsg)
+	})
+
+	Convey("Should not retry a request once the retries of a hierarchy request are exhausted.", t, func() {
+		uri := "s3://bucket/target.csv"
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+
+		_, mockCSVTransformer := setMocks()
+		mockCSVTransformer.err = hierarchy.RetriesExhaustedError{HierarchyID: "2011STATH", Attempts: 3, Err: hierarchy.ServerError{HierarchyID: "2011STATH", StatusCode: 503}}
+		setRetryPolicy(retry.Policy{MaxAttempts: 3})
+		resp := HandleRequest(context.Background(), createTransformRequest(uri, uri))
+
+		So(resp.Err, ShouldResemble, mockCSVTransformer.err)
+		So(len(resp.Attempts), ShouldEqual, 1)
+		So(mockCSVTransformer.invocations, ShouldEqual, 1)
+	})
+
+	Convey("Should record a queued job waiting to be processed, and only the jobs that were queued.", t, func() {
+		uri := "s3://bucket/target.csv"
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+
+		setMocks()
+		QueueRequest(createTransformRequest(uri, uri))
+		job, _ := jobRegistry.Get("foo")
+		So(job.State, ShouldEqual, jobs.StateQueued)
+
+		request, _ := event.NewTransformRequest(uri, uri, "not-queued")
+		HandleRequest(context.Background(), request)
+		job, _ = jobRegistry.Get("not-queued")
+		So(job, ShouldBeNil)
+	})
+
+	Convey("Should stop a job cancelled through the job registry, and record it as cancelled.", t, func() {
+		uri := "s3://bucket/target.csv"
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+
+		_, mockCSVTransformer := setMocks()
+		mockCSVTransformer.started = make(chan struct{})
+		responses := make(chan TransformResponse, 1)
+		go func() {
+			request := createTransformRequest(uri, uri)
+			QueueRequest(request)
+			responses <- HandleRequest(context.Background(), request)
+		}()
+		<-mockCSVTransformer.started
+		So(jobRegistry.Cancel("foo"), ShouldBeTrue)
+
+		response := <-responses
+		So(response.Err, ShouldEqual, jobs.JobCancelledErr)
+		So(response.Stage, ShouldEqual, event.StageTransform)
+		So(len(response.Attempts), ShouldEqual, 1)
+		job, _ := jobRegistry.Get("foo")
+		So(job.State, ShouldEqual, jobs.StateCancelled)
+		So(job.Stage, ShouldEqual, event.StageTransform)
+		So(jobRegistry.Cancel("foo"), ShouldBeFalse)
+	})
+
+	Convey("Should stop a job that takes longer than the job timeout.", t, func() {
+		uri := "s3://bucket/target.csv"
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+		config.JobTimeout = 10 * time.Millisecond
+		Reset(func() { config.JobTimeout = 0 })
+
+		_, mockCSVTransformer := setMocks()
+		mockCSVTransformer.started = make(chan struct{})
+		request := createTransformRequest(uri, uri)
+		QueueRequest(request)
+		response := HandleRequest(context.Background(), request)
+
+		So(response.Err, ShouldResemble, JobTimeoutError{Timeout: 10 * time.Millisecond})
+		So(response.Message, ShouldEqual, "The transform did not finish within the job timeout of 10ms.")
+		job, _ := jobRegistry.Get("foo")
+		So(job.State, ShouldEqual, jobs.StateFailed)
+	})
+
+}
+
+func jobStates(job *jobs.Job) []string {
+	var states []string
+	for _, change := range job.History {
+		states = append(states, change.State)
+	}
+	return states
+}
+
+// metricValue returns the value of the metric series (the name and any labels) served by the /metrics endpoint, or 0
+// if it has not been written.
+func metricValue(series string) float64 {
+	var buf bytes.Buffer
+	metrics.DefaultRegistry.WriteTo(&buf)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			v, _ := strconv.ParseFloat(strings.TrimPrefix(line, series+" "), 64)
+			return v
+		}
+	}
+	return 0
 }
 
 func createTransformRequest(input string, output string) event.TransformRequest {
@@ -192,5 +551,18 @@ func createTransformRequest(input string, output string) event.TransformRequest
 func setMocks() (*MockAWSCli, *MockCSVTransformer) {
 	mockAWSCli := newMockAwsClient()
 	mockCSVTransformer := newMockCSVTransformer()
+	setRetryPolicy(retry.Policy{MaxAttempts: 3})
 	return mockAWSCli, mockCSVTransformer
 }
+
+// largeOutput returns a header and 999 rows, larger than any buffer used in writing it.
+func largeOutput() string {
+	return strings.Repeat("Observation,Dimension_1_Name,Dimension_1_Value\n", 1000)
+}
+
+// withoutDetails removes the attempts and stats, which vary between runs.
+func withoutDetails(resp TransformResponse) TransformResponse {
+	resp.Attempts = nil
+	resp.Stats = nil
+	return resp
+}
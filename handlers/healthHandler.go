@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/health"
+)
+
+// HealthcheckResponse the response of the /healthcheck endpoint.
+type HealthcheckResponse struct {
+	Status string `json:"status"`
+}
+
+// HealthcheckHandler handles the /healthcheck (liveness) endpoint, returning 200 while the service can respond.
+func HealthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	WriteResponse(w, HealthcheckResponse{Status: health.StatusOK}, http.StatusOK)
+}
+
+// NewReadyHandler creates an http.HandlerFunc for the /ready (readiness) endpoint, returning the health.Report of the
+// monitor's dependencies, with 200 if they are all available or 503 if any is not.
+func NewReadyHandler(monitor *health.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := monitor.Check()
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+		WriteResponse(w, report, status)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/health"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHealthHandlers(t *testing.T) {
+
+	Convey("Should return 200 from the healthcheck endpoint.", t, func() {
+		w := httptest.NewRecorder()
+		HealthcheckHandler(w, httptest.NewRequest("GET", "/healthcheck", nil))
+
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, `{"status":"ok"}`+"\n")
+	})
+
+	Convey("Should return 200 and the status of each dependency from the ready endpoint when they are available.", t, func() {
+		monitor := health.NewMonitor(time.Minute)
+		monitor.Add("kafka", func() error { return nil })
+		w := httptest.NewRecorder()
+		NewReadyHandler(monitor)(w, httptest.NewRequest("GET", "/ready", nil))
+
+		var report health.Report
+		json.Unmarshal(w.Body.Bytes(), &report)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(report.Status, ShouldEqual, health.StatusOK)
+		So(len(report.Dependencies), ShouldEqual, 1)
+		So(report.Dependencies[0].Name, ShouldEqual, "kafka")
+	})
+
+	Convey("Should return 503 from the ready endpoint when a dependency is unavailable.", t, func() {
+		monitor := health.NewMonitor(time.Minute)
+		monitor.Add("kafka", func() error { return nil })
+		monitor.Add("s3:bucket", func() error { return errors.New("Access denied") })
+		w := httptest.NewRecorder()
+		NewReadyHandler(monitor)(w, httptest.NewRequest("GET", "/ready", nil))
+
+		var report health.Report
+		json.Unmarshal(w.Body.Bytes(), &report)
+		So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(report.Status, ShouldEqual, health.StatusUnavailable)
+		So(report.Dependencies[1].Error, ShouldEqual, "Access denied")
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// jobsPath the path of the /jobs endpoint, which is followed by a request id to get a single job.
+const jobsPath = "/jobs"
+
+const limitParam = "limit"
+
+// defaultJobsLimit the number of jobs listed if no limit is given.
+const defaultJobsLimit = 50
+
+var jobNotFoundErr = errors.New("Job not found.")
+
+// JobsResponse the error response of the /jobs endpoint.
+type JobsResponse struct {
+	Message string `json:"message"`
+}
+
+// Responses
+var jobsRespMethodNotAllowed = JobsResponse{Message: "Method not allowed. Please use GET, or DELETE to cancel a job."}
+var jobsRespInvalidLimit = JobsResponse{Message: "Invalid limit. Please specify a positive integer."}
+var jobsRespNotFound = JobsResponse{Message: jobNotFoundErr.Error()}
+var jobsRespStoreErr = JobsResponse{Message: "Unable to read the jobs."}
+var jobsRespCancelled = JobsResponse{Message: "The job is being cancelled."}
+var jobsRespNotInProgress = JobsResponse{Message: "No job in progress with the request id."}
+
+// JobsHandler handles the /jobs endpoint: GET /jobs lists the most recent jobs (up to the 'limit' query parameter),
+// GET /jobs/{requestId} returns a single job, and DELETE /jobs/{requestId} cancels a job in progress.
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := strings.Trim(strings.TrimPrefix(r.URL.Path, jobsPath), "/")
+	switch {
+	case r.Method == http.MethodGet && len(requestID) > 0:
+		getJob(w, requestID)
+		return
+	case r.Method == http.MethodDelete && len(requestID) > 0:
+		cancelJob(w, requestID)
+		return
+	case r.Method != http.MethodGet:
+		log.Error(methodNotAllowedErr, log.Data{"method": r.Method})
+		WriteResponse(w, jobsRespMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultJobsLimit
+	if limitValue := r.URL.Query().Get(limitParam); len(limitValue) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(limitValue); err != nil || limit <= 0 {
+			WriteResponse(w, jobsRespInvalidLimit, http.StatusBadRequest)
+			return
+		}
+	}
+	jobs, err := jobRegistry.List(limit)
+	if err != nil {
+		log.Error(err, log.Data{"message": "Failed to list jobs"})
+		WriteResponse(w, jobsRespStoreErr, http.StatusInternalServerError)
+		return
+	}
+	WriteResponse(w, jobs, http.StatusOK)
+}
+
+func getJob(w http.ResponseWriter, requestID string) {
+	job, err := jobRegistry.Get(requestID)
+	if err != nil {
+		log.ErrorC(requestID, err, log.Data{"message": "Failed to get job"})
+		WriteResponse(w, jobsRespStoreErr, http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		WriteResponse(w, jobsRespNotFound, http.StatusNotFound)
+		return
+	}
+	WriteResponse(w, job, http.StatusOK)
+}
+
+func cancelJob(w http.ResponseWriter, requestID string) {
+	if !jobRegistry.Cancel(requestID) {
+		WriteResponse(w, jobsRespNotInProgress, http.StatusNotFound)
+		return
+	}
+	log.DebugC(requestID, "Cancelling job", nil)
+	WriteResponse(w, jobsRespCancelled, http.StatusAccepted)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJobsHandler(t *testing.T) {
+
+	Convey("Given a registry of jobs", t, func() {
+		registry := jobs.NewRegistry(jobs.NewMemoryStore(0))
+		setJobRegistry(registry)
+		registry.Queue("first", "s3://bucket/first.csv", "s3://bucket/first.out")
+		registry.Queue("second", "s3://bucket/second.csv", "s3://bucket/second.out")
+		registry.Finish("first", nil, "download", errors.New("Not found"))
+
+		Convey("Then the jobs are listed most recent first.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("GET", "/jobs", nil))
+
+			var list []jobs.Job
+			json.Unmarshal(w.Body.Bytes(), &list)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(len(list), ShouldEqual, 2)
+			So(list[0].RequestID, ShouldEqual, "second")
+			So(list[1].RequestID, ShouldEqual, "first")
+		})
+
+		Convey("Then the list is limited by the limit parameter.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("GET", "/jobs?limit=1", nil))
+
+			var list []jobs.Job
+			json.Unmarshal(w.Body.Bytes(), &list)
+			So(len(list), ShouldEqual, 1)
+			So(list[0].RequestID, ShouldEqual, "second")
+		})
+
+		Convey("Then an invalid limit is rejected.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("GET", "/jobs?limit=-1", nil))
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Then a job is returned by its request id.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("GET", "/jobs/first", nil))
+
+			var job jobs.Job
+			json.Unmarshal(w.Body.Bytes(), &job)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(job.State, ShouldEqual, jobs.StateFailed)
+			So(job.Stage, ShouldEqual, "download")
+			So(job.Error, ShouldEqual, "Not found")
+		})
+
+		Convey("Then 404 is returned for an unknown request id.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("GET", "/jobs/unknown", nil))
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Then a job in progress is cancelled by DELETE.", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer registry.Start("second", cancel)()
+
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("DELETE", "/jobs/second", nil))
+			So(w.Code, ShouldEqual, http.StatusAccepted)
+			So(ctx.Err(), ShouldEqual, context.Canceled)
+		})
+
+		Convey("Then 404 is returned by DELETE for a job that is not in progress.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("DELETE", "/jobs/first", nil))
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Then 405 is returned for any other method.", func() {
+			w := httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("POST", "/jobs/first", nil))
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			w = httptest.NewRecorder()
+			JobsHandler(w, httptest.NewRequest("DELETE", "/jobs", nil))
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/dp-dd-csv-transformer/metrics"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+)
+
+// The outcomes of a request.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// stageBuckets the upper bounds, in seconds, of the buckets of the stage durations, which may take many minutes for a
+// large file.
+var stageBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+var (
+	requestsTotal        = metrics.NewCounter("csv_transformer_requests_total", "The number of transform requests processed, by outcome (success or failure) and the stage a failed request failed at.", "outcome", "stage")
+	jobsInFlight         = metrics.NewGauge("csv_transformer_jobs_in_flight", "The number of transform requests being processed.")
+	stageDuration        = metrics.NewHistogram("csv_transformer_stage_duration_seconds", "The time taken to download (until the input can be read), transform and upload (including the transform, if streamed) each file.", stageBuckets, "stage")
+	rowsReadTotal        = metrics.NewCounter("csv_transformer_rows_read_total", "The number of input csv rows read.")
+	rowsWrittenTotal     = metrics.NewCounter("csv_transformer_rows_written_total", "The number of output csv rows written.")
+	bytesInTotal         = metrics.NewCounter("csv_transformer_bytes_in_total", "The number of input csv bytes read.")
+	bytesOutTotal        = metrics.NewCounter("csv_transformer_bytes_out_total", "The number of output csv bytes written.")
+	unresolvedCodesTotal = metrics.NewCounter("csv_transformer_unresolved_codes_total", "The number of codes that could not be found in their hierarchy.")
+)
+
+// observeStage records the duration of a stage of a request, from the start time.
+func observeStage(stage string, start time.Time) {
+	stageDuration.Observe(time.Since(start).Seconds(), stage)
+}
+
+// recordStats adds the stats of a transform to the metrics.
+func recordStats(stats *transformer.Stats) {
+	if stats == nil {
+		return
+	}
+	stageDuration.Observe(time.Duration(stats.Timings.TotalNs).Seconds(), event.StageTransform)
+	rowsReadTotal.Add(float64(stats.RowsRead))
+	rowsWrittenTotal.Add(float64(stats.RowsWritten))
+	bytesInTotal.Add(float64(stats.BytesIn))
+	bytesOutTotal.Add(float64(stats.BytesOut))
+	for _, count := range stats.UnresolvedCodes {
+		unresolvedCodesTotal.Add(float64(count))
+	}
+}
+
+// recordResponse counts a request by the outcome of its response.
+func recordResponse(resp TransformResponse) {
+	if resp.Err != nil {
+		requestsTotal.Inc(outcomeFailure, resp.Stage)
+		return
+	}
+	requestsTotal.Inc(outcomeSuccess, "")
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// inFlight the requests in progress, which the service waits for when it shuts down.
+var inFlight = newRequestTracker()
+
+// requestTracker tracks the requests in progress, so that they can be given time to finish, and cancelled if they do
+// not, when the service shuts down. No request can start once it is shutting down.
+type requestTracker struct {
+	mutex       sync.Mutex
+	cancels     map[*context.CancelFunc]bool
+	closing     bool
+	interrupted bool
+	idle        chan struct{}
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{cancels: make(map[*context.CancelFunc]bool), idle: make(chan struct{})}
+}
+
+// start records a request starting, with the cancel function of its context, returning false if the service is
+// shutting down. finish must be called once a started request has finished.
+func (t *requestTracker) start(cancel context.CancelFunc) (finish func(), ok bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closing {
+		return nil, false
+	}
+	key := &cancel
+	t.cancels[key] = true
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.cancels, key)
+		if t.closing && len(t.cancels) == 0 {
+			close(t.idle)
+		}
+	}, true
+}
+
+// wasInterrupted returns true if the requests in progress have been cancelled because the service is shutting down.
+func (t *requestTracker) wasInterrupted() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.interrupted
+}
+
+// close stops new requests from starting, and waits for the requests in progress to finish. If they have not finished
+// once the context is done they are cancelled, and close returns false once they have stopped.
+func (t *requestTracker) close(ctx context.Context) bool {
+	t.mutex.Lock()
+	if !t.closing {
+		t.closing = true
+		if len(t.cancels) == 0 {
+			close(t.idle)
+		}
+	}
+	t.mutex.Unlock()
+
+	select {
+	case <-t.idle:
+		return true
+	case <-ctx.Done():
+	}
+
+	t.mutex.Lock()
+	t.interrupted = true
+	log.Debug("Cancelling the requests in progress", log.Data{"requests": len(t.cancels)})
+	for cancel := range t.cancels {
+		(*cancel)()
+	}
+	t.mutex.Unlock()
+	<-t.idle
+	return false
+}
+
+// Shutdown stops any new request from being processed, and waits for the requests in progress to finish. Those that
+// have not finished once the context is done are cancelled, failing with jobs.JobInterruptedErr, and Shutdown returns
+// false once they have stopped.
+func Shutdown(ctx context.Context) bool {
+	return inFlight.close(ctx)
+}
+
+func setRequestTracker(t *requestTracker) {
+	inFlight = t
+}
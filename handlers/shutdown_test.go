@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+
+	Convey("Should wait for the requests in progress to finish.", t, func() {
+		tracker := newRequestTracker()
+		ctx, cancel := context.WithCancel(context.Background())
+		finish, ok := tracker.start(cancel)
+		So(ok, ShouldBeTrue)
+		time.AfterFunc(10*time.Millisecond, finish)
+
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+		defer cancelShutdown()
+		So(tracker.close(shutdownCtx), ShouldBeTrue)
+		So(ctx.Err(), ShouldBeNil)
+		So(tracker.wasInterrupted(), ShouldBeFalse)
+	})
+
+	Convey("Should not start a request once shutting down.", t, func() {
+		tracker := newRequestTracker()
+		So(tracker.close(context.Background()), ShouldBeTrue)
+
+		_, ok := tracker.start(func() {})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Should interrupt the requests that have not finished within the grace period.", t, func() {
+		uri := "s3://bucket/target.csv"
+		setRequestTracker(newRequestTracker())
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+		Reset(func() { setRequestTracker(newRequestTracker()) })
+
+		_, mockCSVTransformer := setMocks()
+		mockCSVTransformer.started = make(chan struct{})
+		responses := make(chan TransformResponse, 1)
+		go func() {
+			request := createTransformRequest(uri, uri)
+			QueueRequest(request)
+			responses <- HandleRequest(context.Background(), request)
+		}()
+		<-mockCSVTransformer.started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		So(Shutdown(ctx), ShouldBeFalse)
+
+		response := <-responses
+		So(response.Err, ShouldEqual, jobs.JobInterruptedErr)
+		So(response.Stage, ShouldEqual, event.StageTransform)
+		job, _ := jobRegistry.Get("foo")
+		So(job.State, ShouldEqual, jobs.StateCancelled)
+
+		request := createTransformRequest(uri, uri)
+		QueueRequest(request)
+		response = HandleRequest(context.Background(), request)
+		So(response.Err, ShouldEqual, jobs.JobInterruptedErr)
+		So(statusFor(response), ShouldEqual, http.StatusServiceUnavailable)
+		So(mockCSVTransformer.invocations, ShouldEqual, 0)
+		job, _ = jobRegistry.Get("foo")
+		So(jobStates(job), ShouldResemble, []string{jobs.StateQueued, jobs.StateCancelled})
+	})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+const asyncParam = "async"
+
+var readTransformRequestBody requestBodyReader = ioutil.ReadAll
+
+var methodNotAllowedErr = errors.New("Method not allowed.")
+
+// Responses
+var transformRespMethodNotAllowed = TransformResponse{Message: "Method not allowed. Please use POST.", Err: methodNotAllowedErr}
+var transformRespReadBodyErr = TransformResponse{Message: "Unable to read the request body."}
+var transformRespUnmarshalBodyErr = TransformResponse{Message: "Unable to parse the request body. Please specify inputUrl and outputUrl."}
+
+// transformRequestBody is the json body accepted by the /transformer endpoint.
+type transformRequestBody struct {
+	InputURL          string `json:"inputUrl"`
+	OutputURL         string `json:"outputUrl"`
+	RequestID         string `json:"requestId"`
+	HierarchyColumns  *bool  `json:"hierarchyColumns"`
+	TimePeriodColumns *bool  `json:"timePeriodColumns"`
+}
+
+// NewTransformHandler creates an http.HandlerFunc for the /transformer endpoint. The request body is converted to a
+// TransformRequest and passed to the given TransformFunc. If the 'async' query parameter is true the request is
+// processed in the background and 202 Accepted is returned immediately.
+func NewTransformHandler(transform TransformFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Error(methodNotAllowedErr, log.Data{"method": r.Method})
+			WriteResponse(w, transformRespMethodNotAllowed, http.StatusMethodNotAllowed)
+			return
+		}
+
+		bytes, err := readTransformRequestBody(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			log.Error(err, log.Data{"message": "Failed to read request body"})
+			WriteResponse(w, transformRespReadBodyErr, http.StatusBadRequest)
+			return
+		}
+
+		var body transformRequestBody
+		if err := json.Unmarshal(bytes, &body); err != nil {
+			log.Error(err, log.Data{"message": "Failed to unmarshal request body"})
+			WriteResponse(w, transformRespUnmarshalBodyErr, http.StatusBadRequest)
+			return
+		}
+
+		transformRequest, err := event.NewTransformRequest(body.InputURL, body.OutputURL, body.RequestID)
+		if err != nil {
+			WriteResponse(w, newErrorResponse(event.StageParse, err), http.StatusBadRequest)
+			return
+		}
+		transformRequest.HierarchyColumns = body.HierarchyColumns
+		transformRequest.TimePeriodColumns = body.TimePeriodColumns
+		QueueRequest(transformRequest)
+
+		if async, _ := strconv.ParseBool(r.URL.Query().Get(asyncParam)); async {
+			// the request outlives the http request, so it can only be cancelled through the /jobs endpoint
+			go transform(context.Background(), transformRequest)
+			WriteResponse(w, transformResponseSuccess, http.StatusAccepted)
+			return
+		}
+
+		// the transform is cancelled if the client disconnects
+		resp := transform(r.Context(), transformRequest)
+		WriteResponse(w, resp, statusFor(resp))
+	}
+}
+
+// statusFor returns the http status code matching the given TransformResponse.
+func statusFor(resp TransformResponse) int {
+	switch resp.Err {
+	case nil:
+		return http.StatusOK
+	case unsupportedFileTypeErr:
+		return http.StatusBadRequest
+	case jobs.JobInterruptedErr:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func setReader(reader requestBodyReader) {
+	readTransformRequestBody = reader
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const validBody = `{"inputUrl": "s3://bucket/input.csv", "outputUrl": "s3://bucket/output.csv", "requestId": "foo"}`
+
+type mockTransformFunc struct {
+	requests chan event.TransformRequest
+	response TransformResponse
+}
+
+func newMockTransformFunc(response TransformResponse) *mockTransformFunc {
+	return &mockTransformFunc{requests: make(chan event.TransformRequest, 1), response: response}
+}
+
+func (m *mockTransformFunc) transform(ctx context.Context, request event.TransformRequest) TransformResponse {
+	m.requests <- request
+	return m.response
+}
+
+func postTransformRequest(transform TransformFunc, method string, url string, body string) (*httptest.ResponseRecorder, TransformResponse) {
+	r := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	NewTransformHandler(transform)(w, r)
+
+	var resp TransformResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestTransformHandler(t *testing.T) {
+
+	Convey("Should invoke the TransformFunc with the requested urls and return 200 on success.", t, func() {
+		mock := newMockTransformFunc(transformResponseSuccess)
+
+		w, resp := postTransformRequest(mock.transform, "POST", "/transformer", validBody)
+
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		So(resp, ShouldResemble, transformResponseSuccess)
+		So(len(mock.requests), ShouldEqual, 1)
+		request := <-mock.requests
+		So(request.InputURL.String(), ShouldEqual, "s3://bucket/input.csv")
+		So(request.OutputURL.String(), ShouldEqual, "s3://bucket/output.csv")
+		So(request.RequestID, ShouldEqual, "foo")
+	})
+
+	Convey("Should return 202 and process the request in the background when async is requested.", t, func() {
+		mock := newMockTransformFunc(transformResponseSuccess)
+
+		w, resp := postTransformRequest(mock.transform, "POST", "/transformer?async=true", validBody)
+
+		So(w.Code, ShouldEqual, http.StatusAccepted)
+		So(resp, ShouldResemble, transformResponseSuccess)
+		select {
+		case request := <-mock.requests:
+			So(request.RequestID, ShouldEqual, "foo")
+		case <-time.After(time.Second):
+			So("the TransformFunc was not invoked", ShouldBeEmpty)
+		}
+	})
+
+	Convey("Should record an accepted request as queued until it is processed.", t, func() {
+		setJobRegistry(jobs.NewRegistry(jobs.NewMemoryStore(0)))
+		mock := newMockTransformFunc(transformResponseSuccess)
+
+		postTransformRequest(mock.transform, "POST", "/transformer?async=true", validBody)
+
+		job, _ := jobRegistry.Get("foo")
+		So(job.State, ShouldEqual, jobs.StateQueued)
+		So(job.InputURL, ShouldEqual, "s3://bucket/input.csv")
+	})
+
+	Convey("Should return 400 for an unsupported file type.", t, func() {
+		mock := newMockTransformFunc(transformRespUnsupportedFileType)
+
+		w, resp := postTransformRequest(mock.transform, "POST", "/transformer", validBody)
+
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(resp.Message, ShouldEqual, transformRespUnsupportedFileType.Message)
+	})
+
+	Convey("Should return 500 if the transform fails.", t, func() {
+		mock := newMockTransformFunc(newErrorResponse(event.StageDownload, errors.New("THIS IS AN AWS ERROR")))
+
+		w, resp := postTransformRequest(mock.transform, "POST", "/transformer", validBody)
+
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		So(resp.Message, ShouldEqual, "THIS IS AN AWS ERROR")
+	})
+
+	Convey("Should return 405 for a method other than POST.", t, func() {
+		mock := newMockTransformFunc(transformResponseSuccess)
+
+		w, resp := postTransformRequest(mock.transform, "GET", "/transformer", "")
+
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		So(resp.Message, ShouldEqual, transformRespMethodNotAllowed.Message)
+		So(len(mock.requests), ShouldEqual, 0)
+	})
+
+	Convey("Should return 400 if the body is not valid json.", t, func() {
+		mock := newMockTransformFunc(transformResponseSuccess)
+
+		w, resp := postTransformRequest(mock.transform, "POST", "/transformer", "{not json")
+
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(resp, ShouldResemble, transformRespUnmarshalBodyErr)
+		So(len(mock.requests), ShouldEqual, 0)
+	})
+
+	Convey("Should return 400 if the request urls are invalid.", t, func() {
+		mock := newMockTransformFunc(transformResponseSuccess)
+
+		w, _ := postTransformRequest(mock.transform, "POST", "/transformer", `{"inputUrl": "s3://bucket/", "outputUrl": "s3://bucket/output.csv"}`)
+
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(len(mock.requests), ShouldEqual, 0)
+	})
+
+	Convey("Should return 400 if the request body cannot be read.", t, func() {
+		mock := newMockTransformFunc(transformResponseSuccess)
+		setReader(func(r io.Reader) ([]byte, error) {
+			return nil, errors.New("Read error")
+		})
+		defer setReader(ioutil.ReadAll)
+
+		w, resp := postTransformRequest(mock.transform, "POST", "/transformer", validBody)
+
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(resp, ShouldResemble, transformRespReadBodyErr)
+		So(len(mock.requests), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// The status of a dependency, or of the service as a whole.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// Checker checks a dependency of the service, returning an error if it is unavailable.
+type Checker func() error
+
+// DependencyStatus the result of the last check of a dependency.
+type DependencyStatus struct {
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	LatencyNs   int64     `json:"latencyNs"`
+	LastChecked time.Time `json:"lastChecked"`
+}
+
+// Report the readiness of the service: it is ok only if every dependency is ok.
+type Report struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyStatus `json:"dependencies"`
+}
+
+// Ready returns true if every dependency is ok.
+func (r Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+type dependency struct {
+	name   string
+	check  Checker
+	status DependencyStatus
+}
+
+// Monitor checks the dependencies of the service. The result of each check is cached for the TTL, so frequent probes
+// do not hammer the dependencies.
+type Monitor struct {
+	ttl          time.Duration
+	mutex        sync.Mutex
+	dependencies []*dependency
+}
+
+// NewMonitor create a new Monitor, caching the result of each check for the ttl.
+func NewMonitor(ttl time.Duration) *Monitor {
+	return &Monitor{ttl: ttl}
+}
+
+// Add adds a dependency to be checked.
+func (m *Monitor) Add(name string, check Checker) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dependencies = append(m.dependencies, &dependency{name: name, check: check})
+}
+
+// Check returns the status of every dependency, checking (concurrently) those whose cached result has expired. Calls
+// made while the dependencies are being checked wait for, and share, the results.
+func (m *Monitor) Check() Report {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	for _, d := range m.dependencies {
+		if !d.status.LastChecked.IsZero() && now.Sub(d.status.LastChecked) < m.ttl {
+			continue
+		}
+		wg.Add(1)
+		go func(d *dependency) {
+			defer wg.Done()
+			d.status = run(d.name, d.check)
+		}(d)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Dependencies: make([]DependencyStatus, 0, len(m.dependencies))}
+	for _, d := range m.dependencies {
+		if d.status.Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+		report.Dependencies = append(report.Dependencies, d.status)
+	}
+	return report
+}
+
+// run checks a dependency, timing the check.
+func run(name string, check Checker) DependencyStatus {
+	status := DependencyStatus{Name: name, Status: StatusOK, LastChecked: time.Now()}
+	err := check()
+	status.LatencyNs = time.Since(status.LastChecked).Nanoseconds()
+	if err != nil {
+		status.Status = StatusUnavailable
+		status.Error = err.Error()
+	}
+	return status
+}
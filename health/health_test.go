@@ -0,0 +1,94 @@
+package health
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingChecker counts the checks made, returning the error.
+type countingChecker struct {
+	calls int32
+	err   error
+	delay time.Duration
+}
+
+func (c *countingChecker) check() error {
+	atomic.AddInt32(&c.calls, 1)
+	time.Sleep(c.delay)
+	return c.err
+}
+
+func TestMonitor(t *testing.T) {
+
+	Convey("Given a monitor of available dependencies", t, func() {
+		kafka, s3 := &countingChecker{}, &countingChecker{}
+		m := NewMonitor(time.Minute)
+		m.Add("kafka", kafka.check)
+		m.Add("s3", s3.check)
+
+		Convey("Then the service is ready, and the status of each dependency is reported", func() {
+			report := m.Check()
+			So(report.Ready(), ShouldBeTrue)
+			So(len(report.Dependencies), ShouldEqual, 2)
+			So(report.Dependencies[0].Name, ShouldEqual, "kafka")
+			So(report.Dependencies[0].Status, ShouldEqual, StatusOK)
+			So(report.Dependencies[0].Error, ShouldBeEmpty)
+			So(report.Dependencies[0].LastChecked.IsZero(), ShouldBeFalse)
+			So(report.Dependencies[1].Name, ShouldEqual, "s3")
+		})
+
+		Convey("Then the results are cached for the ttl", func() {
+			first := m.Check()
+			second := m.Check()
+			So(second, ShouldResemble, first)
+			So(atomic.LoadInt32(&kafka.calls), ShouldEqual, 1)
+			So(atomic.LoadInt32(&s3.calls), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a monitor of an unavailable dependency", t, func() {
+		kafka, s3 := &countingChecker{}, &countingChecker{err: errors.New("no such bucket")}
+		m := NewMonitor(0)
+		m.Add("kafka", kafka.check)
+		m.Add("s3", s3.check)
+
+		Convey("Then the service is not ready, and the error is reported", func() {
+			report := m.Check()
+			So(report.Ready(), ShouldBeFalse)
+			So(report.Status, ShouldEqual, StatusUnavailable)
+			So(report.Dependencies[0].Status, ShouldEqual, StatusOK)
+			So(report.Dependencies[1].Status, ShouldEqual, StatusUnavailable)
+			So(report.Dependencies[1].Error, ShouldEqual, "no such bucket")
+		})
+
+		Convey("Then the dependencies are checked again once the ttl has expired", func() {
+			m.Check()
+			m.Check()
+			So(atomic.LoadInt32(&s3.calls), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given concurrent checks of a slow dependency", t, func() {
+		slow := &countingChecker{delay: 50 * time.Millisecond}
+		m := NewMonitor(time.Minute)
+		m.Add("slow", slow.check)
+
+		Convey("Then the dependency is only checked once", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					m.Check()
+				}()
+			}
+			wg.Wait()
+			So(atomic.LoadInt32(&slow.calls), ShouldEqual, 1)
+		})
+	})
+}
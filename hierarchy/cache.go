@@ -0,0 +1,202 @@
+package hierarchy
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+)
+
+// estimated memory used by each HierarchyEntry, in addition to its code and name, and by the entry map
+const entryOverheadBytes = 200
+
+// sharedCache is the process-wide cache used by every HierarchyClient created with NewHierarchyClient.
+var sharedCache = NewCache(config.HierarchyCacheTTL, config.HierarchyCacheMaxEntries, config.HierarchyCacheMaxBytes)
+
+// CacheStats holds the counters of a Cache.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// Cache is a thread safe, least recently used cache of hierarchies. Entries expire after the TTL, and the least
+// recently used entries are evicted once the cache holds more than maxEntries hierarchies or maxBytes (estimated) of
+// entries. A zero TTL or limit means no limit. If a hierarchy is requested while it is already being fetched, the
+// request waits for that fetch rather than fetching it again.
+//
+// As a hierarchy is requested for every row that has a code in it, a hit only takes a read lock. Rather than moving to
+// the front of the list on each hit, an entry is marked as used, and a used entry is moved to the front, instead of
+// being evicted, once it reaches the back (i.e. the "second chance" approximation of least recently used).
+type Cache struct {
+	// hits is updated atomically by readers, so is first to keep it 64-bit aligned
+	hits uint64
+
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+
+	mutex    sync.RWMutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*fetchCall
+	stats    CacheStats
+}
+
+type cacheEntry struct {
+	id        string
+	hierarchy *Hierarchy
+	expires   time.Time
+	bytes     int64
+	// used is set atomically when the entry is hit, and cleared when it is given a second chance
+	used int32
+}
+
+type fetchCall struct {
+	done      chan bool
+	hierarchy *Hierarchy
+	err       error
+	// cancelled whether the context of the caller making the fetch was done, so the error doesn't apply to the others
+	cancelled bool
+}
+
+// NewCache creates a new Cache.
+func NewCache(ttl time.Duration, maxEntries int, maxBytes int64) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		inflight:   make(map[string]*fetchCall),
+	}
+}
+
+// Get returns the cached hierarchy with the given id, calling fetch to get it if it is not cached or has expired.
+// Errors from fetch are returned to every caller waiting for the hierarchy, and are not cached, unless the fetch failed
+// because the context of the caller making it was done, in which case the next caller waiting makes the fetch again.
+// A caller stops waiting, returning the error of its context, once its context is done.
+func (c *Cache) Get(ctx context.Context, id string, fetch func(ctx context.Context, id string) (*Hierarchy, error)) (*Hierarchy, error) {
+	c.mutex.RLock()
+	h, ok := c.hit(id)
+	c.mutex.RUnlock()
+	if ok {
+		return h, nil
+	}
+
+	c.mutex.Lock()
+	// the hierarchy may have been added since the read lock was released
+	if h, ok := c.hit(id); ok {
+		c.mutex.Unlock()
+		return h, nil
+	}
+	if element, ok := c.entries[id]; ok {
+		c.remove(element)
+	}
+	c.stats.Misses++
+
+	if call, ok := c.inflight[id]; ok {
+		c.mutex.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.cancelled && ctx.Err() == nil {
+			return c.Get(ctx, id, fetch)
+		}
+		return call.hierarchy, call.err
+	}
+	call := &fetchCall{done: make(chan bool)}
+	c.inflight[id] = call
+	c.mutex.Unlock()
+
+	call.hierarchy, call.err = fetch(ctx, id)
+	call.cancelled = call.err != nil && ctx.Err() != nil
+
+	c.mutex.Lock()
+	delete(c.inflight, id)
+	if call.err == nil {
+		c.add(id, call.hierarchy)
+	}
+	c.mutex.Unlock()
+	close(call.done)
+
+	return call.hierarchy, call.err
+}
+
+// hit returns the cached hierarchy with the given id, marking it as used, or false if it is not cached or has expired.
+// Must be called with the mutex held, for reading or writing.
+func (c *Cache) hit(id string) (*Hierarchy, bool) {
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.ttl > 0 && !time.Now().Before(entry.expires) {
+		return nil, false
+	}
+	atomic.StoreInt32(&entry.used, 1)
+	atomic.AddUint64(&c.hits, 1)
+	return entry.hierarchy, true
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *Cache) Stats() CacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	stats := c.stats
+	stats.Hits = atomic.LoadUint64(&c.hits)
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// add caches the hierarchy, evicting the least recently used entries if the cache is full: an entry at the back of the
+// list that has been used since it was added, or last given a second chance, is moved to the front instead. Must be
+// called with the mutex held.
+func (c *Cache) add(id string, h *Hierarchy) {
+	entry := &cacheEntry{id: id, hierarchy: h, expires: time.Now().Add(c.ttl), bytes: estimateBytes(h)}
+	added := c.lru.PushFront(entry)
+	c.entries[id] = added
+	c.stats.Bytes += entry.bytes
+
+	for c.lru.Len() > 1 && c.isFull() {
+		back := c.lru.Back()
+		if back == added || atomic.SwapInt32(&back.Value.(*cacheEntry).used, 0) == 1 {
+			c.lru.MoveToFront(back)
+			continue
+		}
+		c.remove(back)
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) isFull() bool {
+	return (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.stats.Bytes > c.maxBytes)
+}
+
+// remove removes the element from the cache. Must be called with the mutex held.
+func (c *Cache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.id)
+	c.stats.Bytes -= entry.bytes
+}
+
+// estimateBytes estimates the memory used by the hierarchy's entries.
+func estimateBytes(h *Hierarchy) int64 {
+	var bytes int64
+	for _, entry := range h.EntryMap {
+		bytes += int64(len(entry.Code) + len(entry.Name) + entryOverheadBytes)
+	}
+	return bytes
+}
+
+// GetCacheStats returns the counters of the process-wide hierarchy cache.
+func GetCacheStats() CacheStats {
+	return sharedCache.Stats()
+}
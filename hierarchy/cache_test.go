@@ -0,0 +1,213 @@
+package hierarchy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type countingFetcher struct {
+	mutex   sync.Mutex
+	fetches map[string]int
+	delay   time.Duration
+	err     error
+}
+
+func newCountingFetcher() *countingFetcher {
+	return &countingFetcher{fetches: make(map[string]int)}
+}
+
+func (f *countingFetcher) fetch(ctx context.Context, id string) (*Hierarchy, error) {
+	f.mutex.Lock()
+	f.fetches[id]++
+	f.mutex.Unlock()
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	h := &Hierarchy{ID: id, EntryMap: map[string]*HierarchyEntry{"code": {Code: "code", Name: "name"}}}
+	return h, nil
+}
+
+func (f *countingFetcher) count(id string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.fetches[id]
+}
+
+func TestCache(t *testing.T) {
+
+	Convey("Given a cache", t, func() {
+		cache := NewCache(time.Hour, 2, 0)
+		fetcher := newCountingFetcher()
+
+		Convey("When the same hierarchy is requested twice", func() {
+			first, _ := cache.Get(context.Background(), "a", fetcher.fetch)
+			second, err := cache.Get(context.Background(), "a", fetcher.fetch)
+
+			Convey("Then it is only fetched once", func() {
+				So(err, ShouldBeNil)
+				So(second, ShouldEqual, first)
+				So(fetcher.count("a"), ShouldEqual, 1)
+				So(cache.Stats().Hits, ShouldEqual, 1)
+				So(cache.Stats().Misses, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When more hierarchies are requested than the cache can hold", func() {
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			cache.Get(context.Background(), "b", fetcher.fetch)
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			cache.Get(context.Background(), "c", fetcher.fetch)
+
+			Convey("Then the least recently used is evicted", func() {
+				So(cache.Stats().Entries, ShouldEqual, 2)
+				So(cache.Stats().Evictions, ShouldEqual, 1)
+				cache.Get(context.Background(), "a", fetcher.fetch)
+				So(fetcher.count("a"), ShouldEqual, 1)
+				cache.Get(context.Background(), "b", fetcher.fetch)
+				So(fetcher.count("b"), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When every cached hierarchy has been used since the last was added", func() {
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			cache.Get(context.Background(), "b", fetcher.fetch)
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			cache.Get(context.Background(), "b", fetcher.fetch)
+			cache.Get(context.Background(), "c", fetcher.fetch)
+
+			Convey("Then the hierarchy just added is kept, and one of the others evicted", func() {
+				So(cache.Stats().Entries, ShouldEqual, 2)
+				So(cache.Stats().Evictions, ShouldEqual, 1)
+				cache.Get(context.Background(), "c", fetcher.fetch)
+				So(fetcher.count("c"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a cached hierarchy is requested concurrently", func() {
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						cache.Get(context.Background(), "a", fetcher.fetch)
+					}
+				}()
+			}
+			wg.Wait()
+
+			Convey("Then every request is counted as a hit", func() {
+				So(cache.Stats().Hits, ShouldEqual, 1000)
+				So(fetcher.count("a"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the fetch fails", func() {
+			fetcher.err = errors.New("Error getting hierarchy")
+			_, err := cache.Get(context.Background(), "a", fetcher.fetch)
+
+			Convey("Then the error is returned and not cached", func() {
+				So(err, ShouldNotBeNil)
+				fetcher.err = nil
+				h, err := cache.Get(context.Background(), "a", fetcher.fetch)
+				So(err, ShouldBeNil)
+				So(h.ID, ShouldEqual, "a")
+				So(fetcher.count("a"), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When a hierarchy is requested concurrently", func() {
+			fetcher.delay = 50 * time.Millisecond
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cache.Get(context.Background(), "a", fetcher.fetch)
+				}()
+			}
+			wg.Wait()
+
+			Convey("Then it is only fetched once", func() {
+				So(fetcher.count("a"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the caller fetching a hierarchy is cancelled while another waits for it", func() {
+			fetcher.delay = 50 * time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			cancelled := make(chan error)
+			go func() {
+				_, err := cache.Get(ctx, "a", fetcher.fetch)
+				cancelled <- err
+			}()
+			time.Sleep(10 * time.Millisecond)
+			waited := make(chan *Hierarchy)
+			go func() {
+				h, _ := cache.Get(context.Background(), "a", fetcher.fetch)
+				waited <- h
+			}()
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+
+			Convey("Then the cancelled caller returns the context's error, and the other fetches the hierarchy again", func() {
+				So(<-cancelled, ShouldEqual, context.Canceled)
+				h := <-waited
+				So(h, ShouldNotBeNil)
+				So(h.ID, ShouldEqual, "a")
+				So(fetcher.count("a"), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When a caller waiting for a hierarchy is cancelled", func() {
+			fetcher.delay = 50 * time.Millisecond
+			go cache.Get(context.Background(), "a", fetcher.fetch)
+			time.Sleep(10 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := cache.Get(ctx, "a", fetcher.fetch)
+
+			Convey("Then it stops waiting", func() {
+				So(err, ShouldResemble, context.DeadlineExceeded)
+			})
+		})
+	})
+
+	Convey("Given a cache with a short TTL", t, func() {
+		cache := NewCache(10*time.Millisecond, 0, 0)
+		fetcher := newCountingFetcher()
+
+		Convey("Then an expired hierarchy is fetched again", func() {
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			time.Sleep(20 * time.Millisecond)
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			So(fetcher.count("a"), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a cache with a memory limit", t, func() {
+		fetcher := newCountingFetcher()
+		h, _ := fetcher.fetch(context.Background(), "size")
+		cache := NewCache(time.Hour, 0, estimateBytes(h)*2)
+
+		Convey("Then hierarchies are evicted once the limit is exceeded", func() {
+			cache.Get(context.Background(), "a", fetcher.fetch)
+			cache.Get(context.Background(), "b", fetcher.fetch)
+			So(cache.Stats().Entries, ShouldEqual, 2)
+			cache.Get(context.Background(), "c", fetcher.fetch)
+			So(cache.Stats().Entries, ShouldEqual, 2)
+			So(cache.Stats().Bytes, ShouldEqual, estimateBytes(h)*2)
+		})
+	})
+}
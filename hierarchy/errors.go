@@ -0,0 +1,69 @@
+package hierarchy
+
+import (
+	"fmt"
+)
+
+// NotFoundError is returned when the hierarchy endpoint responds with 404 Not Found, or there is no file for the
+// hierarchy in the HierarchySource.
+type NotFoundError struct {
+	HierarchyID string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("Hierarchy %s not found", e.HierarchyID)
+}
+
+// EntryNotFoundError is returned when a hierarchy has no entry with the requested code.
+type EntryNotFoundError struct {
+	HierarchyID string
+	Code        string
+}
+
+func (e EntryNotFoundError) Error() string {
+	return fmt.Sprintf("No entry found with code %s in hierarchy %s", e.Code, e.HierarchyID)
+}
+
+// ServerError is returned when the hierarchy endpoint responds with a 5xx status, or 429 Too Many Requests. It is
+// retryable.
+type ServerError struct {
+	HierarchyID string
+	StatusCode  int
+}
+
+func (e ServerError) Error() string {
+	return fmt.Sprintf("Hierarchy endpoint returned status %d for hierarchy %s", e.StatusCode, e.HierarchyID)
+}
+
+// Retryable implements retry.Retryable.
+func (e ServerError) Retryable() bool {
+	return true
+}
+
+// RetriesExhaustedError is returned when every attempt to get a hierarchy failed with a transient error, e.g. a
+// ServerError or the circuit breaker being open. It is not retryable: the hierarchy request has already been retried,
+// and retrying the transform as well would multiply the requests made to the hierarchy endpoint.
+type RetriesExhaustedError struct {
+	HierarchyID string
+	Attempts    int
+	Err         error
+}
+
+func (e RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("Unable to get hierarchy %s after %d attempts: %s", e.HierarchyID, e.Attempts, e.Err.Error())
+}
+
+// Retryable implements retry.Retryable.
+func (e RetriesExhaustedError) Retryable() bool {
+	return false
+}
+
+// UnexpectedStatusError is returned when the hierarchy endpoint responds with any other status that is not 200 OK.
+type UnexpectedStatusError struct {
+	HierarchyID string
+	StatusCode  int
+}
+
+func (e UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("Hierarchy endpoint returned unexpected status %d for hierarchy %s", e.StatusCode, e.HierarchyID)
+}
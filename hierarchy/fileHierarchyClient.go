@@ -0,0 +1,87 @@
+package hierarchy
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const hierarchyFileExt = ".json"
+const zipFileExt = ".zip"
+
+// fileHierarchyClient loads hierarchies from {hierarchy_id}.json files in a local directory or zip archive, so that
+// transforms can be run without the hierarchy endpoint.
+type fileHierarchyClient struct {
+	source string
+	cache  *Cache
+}
+
+func newFileHierarchyClient(source string, cache *Cache) *fileHierarchyClient {
+	return &fileHierarchyClient{source: source, cache: cache}
+}
+
+// GetHierarchy gets the requested hierarchy from the cache, loading it from the source if it is not cached. A
+// NotFoundError is returned if there is no file for the hierarchy.
+func (fc *fileHierarchyClient) GetHierarchy(ctx context.Context, hierarchyId string) (*Hierarchy, error) {
+	return fc.cache.Get(ctx, hierarchyId, fc.loadHierarchy)
+}
+
+// GetHierarchyValue returns the name of the entry with the given code in the requested hierarchy.
+func (fc *fileHierarchyClient) GetHierarchyValue(ctx context.Context, hierarchyId string, entryCode string) (string, error) {
+	return getHierarchyValue(ctx, fc, hierarchyId, entryCode)
+}
+
+// GetHierarchyEntry returns the entry with the given code in the requested hierarchy.
+func (fc *fileHierarchyClient) GetHierarchyEntry(ctx context.Context, hierarchyId string, entryCode string) (*HierarchyEntry, error) {
+	return getHierarchyEntry(ctx, fc, hierarchyId, entryCode)
+}
+
+// loadHierarchy reads the hierarchy's file. Reading a local file is not abandoned when the context is done.
+func (fc *fileHierarchyClient) loadHierarchy(ctx context.Context, hierarchyId string) (*Hierarchy, error) {
+	// don't allow the id to refer to a file outside the source
+	if len(hierarchyId) == 0 || strings.ContainsAny(hierarchyId, `/\`) || hierarchyId == ".." {
+		return nil, NotFoundError{hierarchyId}
+	}
+	fileName := hierarchyId + hierarchyFileExt
+
+	var body []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(fc.source), zipFileExt) {
+		body, err = readFromZip(fc.source, fileName)
+	} else {
+		body, err = ioutil.ReadFile(filepath.Join(fc.source, fileName))
+	}
+	if os.IsNotExist(err) {
+		return nil, NotFoundError{hierarchyId}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseHierarchy(body)
+}
+
+// readFromZip reads the named file from the zip archive. The file may be in any directory within the archive.
+func readFromZip(archive string, fileName string) ([]byte, error) {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if path.Base(f.Name) != fileName || f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, os.ErrNotExist
+}
@@ -0,0 +1,94 @@
+package hierarchy
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileHierarchyClient(t *testing.T) {
+
+	Convey("Given a directory of hierarchy files", t, func() {
+		dir, err := ioutil.TempDir("", "hierarchies")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "2011STATH.json"), []byte(fmt.Sprintf(hierarchyJson, "2011STATH")), 0644), ShouldBeNil)
+		client := newFileHierarchyClient(dir, NewCache(time.Hour, 0, 0))
+
+		Convey("When a value is requested", func() {
+			value, err := client.GetHierarchyValue(context.Background(), "2011STATH", "E92000001")
+
+			Convey("Then the value of the nested entry is returned", func() {
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, "England")
+			})
+		})
+
+		Convey("When a hierarchy without a file is requested", func() {
+			_, err := client.GetHierarchy(context.Background(), "unknown")
+
+			Convey("Then a NotFoundError is returned", func() {
+				So(err, ShouldResemble, NotFoundError{"unknown"})
+			})
+		})
+
+		Convey("When a hierarchy id refers to a file outside the directory", func() {
+			_, err := client.GetHierarchy(context.Background(), "../2011STATH")
+
+			Convey("Then a NotFoundError is returned", func() {
+				So(err, ShouldResemble, NotFoundError{"../2011STATH"})
+			})
+		})
+	})
+
+	Convey("Given a zip archive of hierarchy files", t, func() {
+		dir, err := ioutil.TempDir("", "hierarchies")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		archive := filepath.Join(dir, "hierarchies.zip")
+		So(writeZip(archive, "hierarchies/2011STATH.json", fmt.Sprintf(hierarchyJson, "2011STATH")), ShouldBeNil)
+		client := newFileHierarchyClient(archive, NewCache(time.Hour, 0, 0))
+
+		Convey("When a hierarchy is requested", func() {
+			h, err := client.GetHierarchy(context.Background(), "2011STATH")
+
+			Convey("Then it is loaded from the archive", func() {
+				So(err, ShouldBeNil)
+				So(h.ID, ShouldEqual, "2011STATH")
+				So(h.EntryMap["E92000001"].Name, ShouldEqual, "England")
+			})
+		})
+
+		Convey("When a hierarchy that is not in the archive is requested", func() {
+			_, err := client.GetHierarchy(context.Background(), "unknown")
+
+			Convey("Then a NotFoundError is returned", func() {
+				So(err, ShouldResemble, NotFoundError{"unknown"})
+			})
+		})
+	})
+}
+
+func writeZip(archive string, name string, content string) error {
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	entry, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = entry.Write([]byte(content)); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,42 @@
+package hierarchy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/health"
+)
+
+// NewSourceChecker creates a health.Checker for where NewHierarchyClient loads hierarchies from: the HierarchySource
+// must exist if one is configured, otherwise the HierarchyEndpoint must respond without a server error.
+func NewSourceChecker(timeout time.Duration) health.Checker {
+	return newSourceChecker(config.HierarchySource, config.HierarchyEndpoint, timeout)
+}
+
+func newSourceChecker(source string, endpoint string, timeout time.Duration) health.Checker {
+	if strings.HasPrefix(source, config.FileSourcePrefix) {
+		path := strings.TrimPrefix(source, config.FileSourcePrefix)
+		return func() error {
+			_, err := os.Stat(path)
+			return err
+		}
+	}
+	httpClient := newHttpClient(timeout, timeout)
+	// any response shows the endpoint is reachable, so don't request (and download) a hierarchy
+	url := strings.Replace(endpoint, config.HIERACHY_ID_PLACEHOLDER, "", -1)
+	return func() error {
+		res, err := httpClient.Head(url)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("Hierarchy endpoint %s responded with status %d", url, res.StatusCode)
+		}
+		return nil
+	}
+}
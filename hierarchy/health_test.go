@@ -0,0 +1,56 @@
+package hierarchy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSourceChecker(t *testing.T) {
+
+	Convey("Given a hierarchy endpoint", t, func() {
+		status := http.StatusNotFound
+		var method, path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.Path
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		check := newSourceChecker("", server.URL+"/hierarchies/"+config.HIERACHY_ID_PLACEHOLDER, time.Second)
+
+		Convey("Then the check succeeds if it responds, without requesting a hierarchy", func() {
+			So(check(), ShouldBeNil)
+			So(method, ShouldEqual, http.MethodHead)
+			So(path, ShouldEqual, "/hierarchies/")
+		})
+
+		Convey("Then the check fails if it responds with a server error", func() {
+			status = http.StatusServiceUnavailable
+			So(check(), ShouldNotBeNil)
+		})
+
+		Convey("Then the check fails if it cannot be reached", func() {
+			server.Close()
+			So(check(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a hierarchy source directory", t, func() {
+		dir, _ := ioutil.TempDir("", "hierarchies")
+		defer os.RemoveAll(dir)
+
+		Convey("Then the check succeeds if it exists", func() {
+			So(newSourceChecker(config.FileSourcePrefix+dir, "", time.Second)(), ShouldBeNil)
+		})
+
+		Convey("Then the check fails if it does not exist", func() {
+			So(newSourceChecker(config.FileSourcePrefix+dir+"/missing", "", time.Second)(), ShouldNotBeNil)
+		})
+	})
+}
@@ -1,13 +1,18 @@
 package hierarchy
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/eapache/go-resiliency/breaker"
 )
 
 type Hierarchy struct {
@@ -24,6 +29,16 @@ type HierarchyEntry struct {
 	LevelType *HierarchyLevelType `json:"levelType,omitempty"`
 	HasData   bool                `json:"hasData,omitempty"` // used only for sparsely populated hierarchy
 	Options   []*HierarchyEntry   `json:"options,omitempty"`
+	Parent    *HierarchyEntry     `json:"-"`
+}
+
+// Ancestors returns the ancestors of the entry, starting with the top level entry and ending with its parent.
+func (e *HierarchyEntry) Ancestors() []*HierarchyEntry {
+	var ancestors []*HierarchyEntry
+	for parent := e.Parent; parent != nil; parent = parent.Parent {
+		ancestors = append([]*HierarchyEntry{parent}, ancestors...)
+	}
+	return ancestors
 }
 
 type HierarchyLevelType struct {
@@ -32,71 +47,209 @@ type HierarchyLevelType struct {
 	Level int    `json:"level"`
 }
 
-// HierarchyClient defines the HierarchyClient interface.
+// HierarchyClient defines the HierarchyClient interface. A request for a hierarchy that is not cached is abandoned once
+// the context is done. A code missing from a hierarchy returns an EntryNotFoundError, to tell it apart from a failure
+// to get the hierarchy.
 type HierarchyClient interface {
-	GetHierarchy(hierarchyId string) (*Hierarchy, error)
-	GetHierarchyValue(hierarchyId string, entryCode string) (string, error)
+	GetHierarchy(ctx context.Context, hierarchyId string) (*Hierarchy, error)
+	GetHierarchyValue(ctx context.Context, hierarchyId string, entryCode string) (string, error)
+	GetHierarchyEntry(ctx context.Context, hierarchyId string, entryCode string) (*HierarchyEntry, error)
 }
 
 type hierarchyClient struct {
-	endpoint string
-	cache    map[string]*Hierarchy
+	endpoint    string
+	cache       *Cache
+	httpClient  *http.Client
+	breaker     *breaker.Breaker
+	retryPolicy retry.Policy
+}
+
+// sharedHttpClient is the http client used by every HierarchyClient created with NewHierarchyClient.
+var sharedHttpClient = newHttpClient(config.HierarchyConnectTimeout, config.HierarchyReadTimeout)
+
+// sharedBreaker is the circuit breaker used by every HierarchyClient created with NewHierarchyClient. It opens after
+// consecutive connection or server errors, so requests fail fast while the hierarchy endpoint is unavailable.
+var sharedBreaker = breaker.New(config.HierarchyBreakerErrorThreshold, 1, config.HierarchyBreakerTimeout)
+
+func newHttpClient(connectTimeout time.Duration, readTimeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: readTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			Dial:                  (&net.Dialer{Timeout: connectTimeout}).Dial,
+			TLSHandshakeTimeout:   connectTimeout,
+			ResponseHeaderTimeout: readTimeout,
+		},
+	}
 }
 
-// NewHierarchyClient Create a new HierarchyClient
+// NewHierarchyClient Create a new HierarchyClient. If a HierarchySource is configured hierarchies are loaded from it,
+// otherwise they are requested from the HierarchyEndpoint. All clients share a process-wide cache of hierarchies,
+// http client and circuit breaker.
 func NewHierarchyClient() HierarchyClient {
-	var client hierarchyClient
-	client.endpoint = config.HierarchyEndpoint
-	client.cache = make(map[string]*Hierarchy)
-	return client
-}
-
-// GetHierarchy calls the hierarchy endpoint to get the requested hierarchy, constructing a map of all entries by code.
-// A HierarchyClient stores the downloaded hierarchies in memory, so should be disposed of when no longer needed.
-func (hc hierarchyClient) GetHierarchy(hierarchyId string) (*Hierarchy, error) {
-	// do we have this hierarchy in the cache?
-	if cached, ok := hc.cache[hierarchyId]; ok {
-		return cached, nil
+	if len(config.HierarchySource) > 0 {
+		return NewHierarchyClientForSource(config.HierarchySource)
+	}
+	return NewHierarchyClientForSource(config.HierarchyEndpoint)
+}
+
+// NewHierarchyClientForSource Create a new HierarchyClient that loads hierarchies from the given source: either a
+// file:// url of a directory or zip archive of {hierarchy_id}.json files, or the url of a hierarchy endpoint containing
+// the {hierarchy_id} placeholder.
+func NewHierarchyClientForSource(source string) HierarchyClient {
+	if strings.HasPrefix(source, config.FileSourcePrefix) {
+		return newFileHierarchyClient(strings.TrimPrefix(source, config.FileSourcePrefix), sharedCache)
+	}
+	return newHierarchyClient(source, sharedCache)
+}
+
+func newHierarchyClient(endpoint string, cache *Cache) *hierarchyClient {
+	return &hierarchyClient{
+		endpoint:   endpoint,
+		cache:      cache,
+		httpClient: sharedHttpClient,
+		breaker:    sharedBreaker,
+		retryPolicy: retry.Policy{
+			MaxAttempts:    config.HierarchyRetryMaxAttempts,
+			InitialBackoff: config.HierarchyRetryBackoff,
+			MaxBackoff:     config.RetryMaxBackoff,
+			Multiplier:     config.RetryBackoffMultiplier,
+			Jitter:         config.RetryJitter,
+		},
+	}
+}
+
+// GetHierarchy gets the requested hierarchy from the cache, calling the hierarchy endpoint if it is not cached.
+// Connection errors, server errors and an open circuit breaker are retried, returning a RetriesExhaustedError if every
+// attempt fails; a NotFoundError or invalid response is not retried. None of the errors are retryable.
+func (hc *hierarchyClient) GetHierarchy(ctx context.Context, hierarchyId string) (*Hierarchy, error) {
+	return hc.cache.Get(ctx, hierarchyId, hc.fetchHierarchy)
+}
+
+// fetchHierarchy calls the hierarchy endpoint to get the requested hierarchy, retrying connection and server errors.
+func (hc *hierarchyClient) fetchHierarchy(ctx context.Context, hierarchyId string) (*Hierarchy, error) {
+	var h *Hierarchy
+	attempts, err := hc.retryPolicy.Do(ctx, hierarchyId, func(attempt int) error {
+		var err error
+		h, err = hc.callEndpoint(ctx, hierarchyId)
+		return err
+	})
+	if retry.IsRetryable(err) && ctx.Err() == nil {
+		return nil, RetriesExhaustedError{hierarchyId, len(attempts), err}
 	}
-	// get the hierarchy:
+	return h, err
+}
+
+// callEndpoint makes a single request to the hierarchy endpoint through the circuit breaker, constructing a map of all
+// entries by code. A request abandoned because the context is done is not counted as a breaker error.
+func (hc *hierarchyClient) callEndpoint(ctx context.Context, hierarchyId string) (*Hierarchy, error) {
 	endpoint := strings.Replace(hc.endpoint, config.HIERACHY_ID_PLACEHOLDER, hierarchyId, -1)
-	res, err := http.Get(endpoint)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
-	body, err := ioutil.ReadAll(res.Body)
+	var body []byte
+	var responseErr error
+	err = hc.breaker.Run(func() error {
+		start := time.Now()
+		res, err := hc.httpClient.Do(req.WithContext(ctx))
+		observeRequest(start, res)
+		if err != nil && ctx.Err() != nil {
+			responseErr = ctx.Err()
+			return nil
+		}
+		if err != nil {
+			return retry.NewRetryableError(err)
+		}
+		defer res.Body.Close()
+
+		switch {
+		case res.StatusCode == http.StatusOK:
+		case res.StatusCode == http.StatusNotFound:
+			// the endpoint is working, so don't count this as a breaker error
+			responseErr = NotFoundError{hierarchyId}
+			return nil
+		case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+			return ServerError{hierarchyId, res.StatusCode}
+		default:
+			responseErr = UnexpectedStatusError{hierarchyId, res.StatusCode}
+			return nil
+		}
+
+		body, err = ioutil.ReadAll(res.Body)
+		if err != nil && ctx.Err() != nil {
+			responseErr = ctx.Err()
+			return nil
+		}
+		if err != nil {
+			return retry.NewRetryableError(err)
+		}
+		return nil
+	})
+	if err == breaker.ErrBreakerOpen {
+		return nil, retry.NewRetryableError(fmt.Errorf("Unable to get hierarchy %s from %s: %s", hierarchyId, endpoint, err.Error()))
+	}
 	if err != nil {
 		return nil, err
 	}
+	if responseErr != nil {
+		return nil, responseErr
+	}
+
+	return parseHierarchy(body)
+}
+
+// parseHierarchy unmarshals a hierarchy, constructing a map of all entries by code.
+func parseHierarchy(body []byte) (*Hierarchy, error) {
 	var h Hierarchy
-	err = json.Unmarshal(body, &h)
+	err := json.Unmarshal(body, &h)
 	if err != nil {
 		return nil, err
 	}
 	h.EntryMap = make(map[string]*HierarchyEntry)
 	// map it
-	mapHierarchyEntries(h.EntryMap, h.Options)
-	// cache it for future requests
-	hc.cache[hierarchyId] = &h
+	mapHierarchyEntries(h.EntryMap, nil, h.Options)
 	return &h, nil
 }
 
-func mapHierarchyEntries(entryMap map[string]*HierarchyEntry, entries []*HierarchyEntry) {
+// mapHierarchyEntries adds the entries, and all of their descendants, to the map, linking each entry to its parent.
+func mapHierarchyEntries(entryMap map[string]*HierarchyEntry, parent *HierarchyEntry, entries []*HierarchyEntry) {
 	for _, entry := range entries {
+		entry.Parent = parent
 		entryMap[entry.Code] = entry
-		mapHierarchyEntries(entryMap, entry.Options)
+		mapHierarchyEntries(entryMap, entry, entry.Options)
 	}
 }
 
 // getHierarchyValue
-func (hc hierarchyClient) GetHierarchyValue(hierarchyId string, entryCode string) (string, error) {
-	h, err := hc.GetHierarchy(hierarchyId)
+func (hc *hierarchyClient) GetHierarchyValue(ctx context.Context, hierarchyId string, entryCode string) (string, error) {
+	return getHierarchyValue(ctx, hc, hierarchyId, entryCode)
+}
+
+// GetHierarchyEntry returns the entry with the given code in the requested hierarchy.
+func (hc *hierarchyClient) GetHierarchyEntry(ctx context.Context, hierarchyId string, entryCode string) (*HierarchyEntry, error) {
+	return getHierarchyEntry(ctx, hc, hierarchyId, entryCode)
+}
+
+// getHierarchyValue returns the name of the entry with the given code in the hierarchy returned by hc.
+func getHierarchyValue(ctx context.Context, hc HierarchyClient, hierarchyId string, entryCode string) (string, error) {
+	entry, err := getHierarchyEntry(ctx, hc, hierarchyId, entryCode)
 	if err != nil {
 		return "", err
 	}
+	return entry.Name, nil
+}
+
+// getHierarchyEntry returns the entry with the given code in the hierarchy returned by hc, or an EntryNotFoundError if
+// the hierarchy has no entry with the code.
+func getHierarchyEntry(ctx context.Context, hc HierarchyClient, hierarchyId string, entryCode string) (*HierarchyEntry, error) {
+	h, err := hc.GetHierarchy(ctx, hierarchyId)
+	if err != nil {
+		return nil, err
+	}
 	entry := h.EntryMap[entryCode]
 	if entry == nil {
-		return "", errors.New("No entry found with code " + entryCode + " in hierarchy " + hierarchyId)
+		return nil, EntryNotFoundError{hierarchyId, entryCode}
 	}
-	return entry.Name, nil
+	return entry, nil
 }
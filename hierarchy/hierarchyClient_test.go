@@ -0,0 +1,207 @@
+package hierarchy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/eapache/go-resiliency/breaker"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const hierarchyJson = `{"id": "%s", "name": "Geography", "type": "geography", "options": [
+	{"code": "K04000001", "name": "England and Wales", "options": [{"code": "E92000001", "name": "England"}]}
+]}`
+
+func TestHierarchyClient(t *testing.T) {
+
+	Convey("Given a hierarchy endpoint", t, func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			fmt.Fprintf(w, hierarchyJson, r.URL.Path[len("/hierarchies/"):])
+		}))
+		defer server.Close()
+		endpoint := server.URL + "/hierarchies/" + config.HIERACHY_ID_PLACEHOLDER
+		cache := NewCache(time.Hour, 0, 0)
+
+		Convey("When a value is requested", func() {
+			value, err := newHierarchyClient(endpoint, cache).GetHierarchyValue(context.Background(), "2011STATH", "E92000001")
+
+			Convey("Then the value of the nested entry is returned", func() {
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, "England")
+			})
+		})
+
+		Convey("When an entry is requested", func() {
+			entry, err := newHierarchyClient(endpoint, cache).GetHierarchyEntry(context.Background(), "2011STATH", "E92000001")
+
+			Convey("Then the entry is linked to its parent", func() {
+				So(err, ShouldBeNil)
+				So(entry.Name, ShouldEqual, "England")
+				So(entry.Parent.Code, ShouldEqual, "K04000001")
+				So(entry.Ancestors(), ShouldResemble, []*HierarchyEntry{entry.Parent})
+				So(entry.Parent.Parent, ShouldBeNil)
+			})
+		})
+
+		Convey("When two clients share a cache", func() {
+			newHierarchyClient(endpoint, cache).GetHierarchy(context.Background(), "2011STATH")
+			h, err := newHierarchyClient(endpoint, cache).GetHierarchy(context.Background(), "2011STATH")
+
+			Convey("Then the hierarchy is only requested once", func() {
+				So(err, ShouldBeNil)
+				So(h.ID, ShouldEqual, "2011STATH")
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a code is not in the hierarchy", func() {
+			_, err := newHierarchyClient(endpoint, cache).GetHierarchyValue(context.Background(), "2011STATH", "unknown")
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func newTestClient(endpoint string) *hierarchyClient {
+	client := newHierarchyClient(endpoint+"/hierarchies/"+config.HIERACHY_ID_PLACEHOLDER, NewCache(time.Hour, 0, 0))
+	client.httpClient = newHttpClient(time.Second, 100*time.Millisecond)
+	client.breaker = breaker.New(3, 1, time.Minute)
+	client.retryPolicy = retry.Policy{MaxAttempts: 2}
+	return client
+}
+
+func TestHierarchyClientErrors(t *testing.T) {
+
+	Convey("Given a hierarchy endpoint that returns 404", t, func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			http.NotFound(w, r)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).GetHierarchy(context.Background(), "unknown")
+
+		Convey("Then a NotFoundError is returned without retrying", func() {
+			So(err, ShouldResemble, NotFoundError{"unknown"})
+			So(retry.IsRetryable(err), ShouldBeFalse)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a hierarchy endpoint that returns 503", t, func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).GetHierarchy(context.Background(), "2011STATH")
+
+		Convey("Then the request is retried and a permanent RetriesExhaustedError returned, with the ServerError", func() {
+			So(err, ShouldResemble, RetriesExhaustedError{"2011STATH", 2, ServerError{"2011STATH", http.StatusServiceUnavailable}})
+			So(retry.IsRetryable(err), ShouldBeFalse)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a hierarchy endpoint that returns 400", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).GetHierarchy(context.Background(), "2011STATH")
+
+		Convey("Then a permanent UnexpectedStatusError is returned", func() {
+			So(err, ShouldResemble, UnexpectedStatusError{"2011STATH", http.StatusBadRequest})
+			So(retry.IsRetryable(err), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a hierarchy endpoint that does not respond in time", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+		}))
+		defer server.Close()
+
+		_, err := newTestClient(server.URL).GetHierarchy(context.Background(), "2011STATH")
+
+		Convey("Then the request is retried and a RetriesExhaustedError returned", func() {
+			So(err, ShouldHaveSameTypeAs, RetriesExhaustedError{})
+			So(retry.IsRetryable(err), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a hierarchy request that is cancelled", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+		}))
+		defer server.Close()
+		client := newTestClient(server.URL)
+		client.breaker = breaker.New(1, 1, time.Minute)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, err := client.GetHierarchy(ctx, "2011STATH")
+
+		Convey("Then the context's error is returned, without retrying or opening the circuit breaker", func() {
+			So(err, ShouldEqual, context.Canceled)
+			So(retry.IsRetryable(err), ShouldBeFalse)
+			So(client.breaker.Run(func() error { return nil }), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a hierarchy endpoint that keeps failing", t, func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+		client := newTestClient(server.URL)
+
+		client.GetHierarchy(context.Background(), "a")
+		client.GetHierarchy(context.Background(), "b")
+		_, err := client.GetHierarchy(context.Background(), "c")
+
+		Convey("Then the circuit breaker opens and requests fail fast", func() {
+			So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+			So(err, ShouldHaveSameTypeAs, RetriesExhaustedError{})
+			So(err.(RetriesExhaustedError).Err.Error(), ShouldContainSubstring, breaker.ErrBreakerOpen.Error())
+		})
+	})
+
+	Convey("Given a hierarchy endpoint that keeps failing, and a request that is retried if it fails with a transient error", t, func() {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+		client := newTestClient(server.URL)
+
+		attempts, err := retry.Policy{MaxAttempts: 3}.Do(context.Background(), "test", func(attempt int) error {
+			_, err := client.GetHierarchy(context.Background(), "2011STATH")
+			return err
+		})
+
+		Convey("Then the hierarchy is only requested the number of times the hierarchy request is retried", func() {
+			So(err, ShouldHaveSameTypeAs, RetriesExhaustedError{})
+			So(len(attempts), ShouldEqual, 1)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+		})
+	})
+}
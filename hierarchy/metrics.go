@@ -0,0 +1,32 @@
+package hierarchy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/metrics"
+)
+
+var requestDuration = metrics.NewHistogram("csv_transformer_hierarchy_request_duration_seconds", "The time taken by requests to the hierarchy endpoint, by response status (or error if there was no response).", metrics.DefaultBuckets, "status")
+
+func init() {
+	metrics.NewCounterFunc("csv_transformer_hierarchy_cache_hits_total", "The number of hierarchies found in the cache.", func() float64 {
+		return float64(sharedCache.Stats().Hits)
+	})
+	metrics.NewCounterFunc("csv_transformer_hierarchy_cache_misses_total", "The number of hierarchies not found in the cache.", func() float64 {
+		return float64(sharedCache.Stats().Misses)
+	})
+	metrics.NewCounterFunc("csv_transformer_hierarchy_cache_evictions_total", "The number of hierarchies evicted from the cache.", func() float64 {
+		return float64(sharedCache.Stats().Evictions)
+	})
+}
+
+// observeRequest records the duration of a request to the hierarchy endpoint, from the start time.
+func observeRequest(start time.Time, res *http.Response) {
+	status := "error"
+	if res != nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	requestDuration.Observe(time.Since(start).Seconds(), status)
+}
@@ -0,0 +1,209 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// The states of a job. A job is queued until its input is downloaded, and returns to downloading if it is retried.
+const (
+	StateQueued       = "queued"
+	StateDownloading  = "downloading"
+	StateTransforming = "transforming"
+	StateUploading    = "uploading"
+	StateSucceeded    = "succeeded"
+	StateFailed       = "failed"
+	StateCancelled    = "cancelled"
+)
+
+// JobCancelledErr the error of a job that was cancelled, e.g. by Registry.Cancel.
+var JobCancelledErr = errors.New("The job was cancelled.")
+
+// JobInterruptedErr the error of a job that was cancelled, or not started, because the service is shutting down.
+var JobInterruptedErr = errors.New("The job was interrupted as the service is shutting down.")
+
+// Job the progress of a TransformRequest.
+type Job struct {
+	RequestID string             `json:"requestId"`
+	InputURL  string             `json:"inputUrl"`
+	OutputURL string             `json:"outputUrl"`
+	State     string             `json:"state"`
+	Created   time.Time          `json:"created"`
+	Updated   time.Time          `json:"updated"`
+	History   []StateChange      `json:"history"`
+	Stats     *transformer.Stats `json:"stats,omitempty"`
+	Stage     string             `json:"stage,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// StateChange the time a job entered a state.
+type StateChange struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// Finished returns true if the job has succeeded, failed or been cancelled.
+func (j *Job) Finished() bool {
+	return j.State == StateSucceeded || j.State == StateFailed || j.State == StateCancelled
+}
+
+func (j *Job) setState(state string, now time.Time) {
+	j.State = state
+	j.Updated = now
+	j.History = append(j.History, StateChange{state, now})
+}
+
+// Store the backend of a Registry.
+type Store interface {
+	// Save creates or replaces the job with the same RequestID.
+	Save(job Job) error
+	// Get returns the job with the request id, or nil if there is none.
+	Get(requestID string) (*Job, error)
+	// List returns up to limit jobs (all of them if limit is not positive), most recently created first.
+	List(limit int) ([]Job, error)
+}
+
+// NewStore creates the Store configured by JobStore: a FileStore if it is a file:// url, otherwise a MemoryStore.
+// Either holds up to JobStoreMaxJobs jobs.
+func NewStore() Store {
+	if strings.HasPrefix(config.JobStore, config.FileSourcePrefix) {
+		return NewFileStore(strings.TrimPrefix(config.JobStore, config.FileSourcePrefix), config.JobStoreMaxJobs)
+	}
+	return NewMemoryStore(config.JobStoreMaxJobs)
+}
+
+// Registry records the progress of each TransformRequest in a Store. Requests without a request id are not recorded.
+// Tracking a job must not fail its transform, so errors from the store are logged rather than returned. The jobs in
+// progress are also held in memory, so that they can be cancelled.
+type Registry struct {
+	store Store
+	mutex sync.Mutex
+
+	runningMutex sync.Mutex
+	running      map[string]map[*runningJob]bool
+}
+
+// runningJob a job in progress, identified by its pointer as jobs may share a request id.
+type runningJob struct {
+	cancel context.CancelFunc
+}
+
+// NewRegistry creates a Registry of the jobs in the store.
+func NewRegistry(store Store) *Registry {
+	return &Registry{store: store, running: make(map[string]map[*runningJob]bool)}
+}
+
+// Queue records a new job, in the queued state, replacing any earlier job with the same request id.
+func (r *Registry) Queue(requestID string, inputURL string, outputURL string) {
+	if len(requestID) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	job := Job{RequestID: requestID, InputURL: inputURL, OutputURL: outputURL, Created: now}
+	job.setState(StateQueued, now)
+	r.save(job)
+}
+
+// SetState records the job entering a new state.
+func (r *Registry) SetState(requestID string, state string) {
+	r.update(requestID, func(job *Job) {
+		job.setState(state, time.Now())
+	})
+}
+
+// Finish records the job succeeding, or failing (or being cancelled, if the error is JobCancelledErr or
+// JobInterruptedErr) at the stage with the error, with a summary of the stats of its transform (the job is held for
+// some time, so must not keep the codes and rows collected in the stats).
+func (r *Registry) Finish(requestID string, stats *transformer.Stats, stage string, err error) {
+	r.update(requestID, func(job *Job) {
+		job.Stats = stats.Summary()
+		switch {
+		case err == JobCancelledErr || err == JobInterruptedErr:
+			job.Stage, job.Error = stage, err.Error()
+			job.setState(StateCancelled, time.Now())
+		case err != nil:
+			job.Stage, job.Error = stage, err.Error()
+			job.setState(StateFailed, time.Now())
+		default:
+			job.setState(StateSucceeded, time.Now())
+		}
+	})
+}
+
+// Start records that a job is in progress, so that it can be cancelled with the cancel function of its context (see
+// Cancel). The returned function must be called once the job has finished.
+func (r *Registry) Start(requestID string, cancel context.CancelFunc) (finished func()) {
+	if len(requestID) == 0 {
+		return func() {}
+	}
+	job := &runningJob{cancel: cancel}
+	r.runningMutex.Lock()
+	defer r.runningMutex.Unlock()
+	if r.running[requestID] == nil {
+		r.running[requestID] = make(map[*runningJob]bool)
+	}
+	r.running[requestID][job] = true
+	return func() {
+		r.runningMutex.Lock()
+		defer r.runningMutex.Unlock()
+		delete(r.running[requestID], job)
+		if len(r.running[requestID]) == 0 {
+			delete(r.running, requestID)
+		}
+	}
+}
+
+// Cancel cancels the jobs in progress with the request id, returning false if there are none. Only the jobs in
+// progress in this process can be cancelled.
+func (r *Registry) Cancel(requestID string) bool {
+	r.runningMutex.Lock()
+	defer r.runningMutex.Unlock()
+	for job := range r.running[requestID] {
+		job.cancel()
+	}
+	return len(r.running[requestID]) > 0
+}
+
+// Get returns the job with the request id, or nil if there is none.
+func (r *Registry) Get(requestID string) (*Job, error) {
+	return r.store.Get(requestID)
+}
+
+// List returns up to limit jobs, most recently created first.
+func (r *Registry) List(limit int) ([]Job, error) {
+	return r.store.List(limit)
+}
+
+func (r *Registry) update(requestID string, update func(job *Job)) {
+	if len(requestID) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	job, err := r.store.Get(requestID)
+	if err != nil {
+		log.ErrorC(requestID, err, log.Data{"message": "Failed to get job"})
+		return
+	}
+	if job == nil {
+		// e.g. evicted from the store
+		return
+	}
+	update(job)
+	r.save(*job)
+}
+
+func (r *Registry) save(job Job) {
+	if err := r.store.Save(job); err != nil {
+		log.ErrorC(job.RequestID, err, log.Data{"message": "Failed to save job", "state": job.State})
+	}
+}
@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func requestIDs(jobs []Job) []string {
+	ids := []string{}
+	for _, job := range jobs {
+		ids = append(ids, job.RequestID)
+	}
+	return ids
+}
+
+// testStore runs the tests common to every Store, with newStore creating an empty store holding up to maxJobs jobs.
+func testStore(newStore func(maxJobs int) Store) {
+
+	Convey("Then a job is saved and returned by its request id", func() {
+		store := newStore(0)
+		So(store.Save(Job{RequestID: "a", State: StateQueued}), ShouldBeNil)
+
+		job, err := store.Get("a")
+		So(err, ShouldBeNil)
+		So(job.State, ShouldEqual, StateQueued)
+	})
+
+	Convey("Then an unknown request id returns nil", func() {
+		job, err := newStore(0).Get("unknown")
+		So(err, ShouldBeNil)
+		So(job, ShouldBeNil)
+	})
+
+	Convey("Then saving a job again replaces it", func() {
+		store := newStore(0)
+		store.Save(Job{RequestID: "a", State: StateQueued})
+		store.Save(Job{RequestID: "a", State: StateSucceeded})
+
+		job, _ := store.Get("a")
+		list, _ := store.List(0)
+		So(job.State, ShouldEqual, StateSucceeded)
+		So(len(list), ShouldEqual, 1)
+	})
+
+	Convey("Then jobs are listed most recently created first, up to the limit", func() {
+		store := newStore(0)
+		registry := NewRegistry(store)
+		registry.Queue("a", "", "")
+		registry.Queue("b", "", "")
+		registry.Queue("c", "", "")
+		registry.SetState("a", StateDownloading)
+
+		all, err := store.List(0)
+		So(err, ShouldBeNil)
+		So(requestIDs(all), ShouldResemble, []string{"c", "b", "a"})
+		limited, _ := store.List(2)
+		So(requestIDs(limited), ShouldResemble, []string{"c", "b"})
+	})
+
+	Convey("Then the oldest jobs are removed beyond the maximum", func() {
+		registry := NewRegistry(newStore(2))
+		registry.Queue("a", "", "")
+		registry.Queue("b", "", "")
+		registry.Queue("c", "", "")
+
+		list, _ := registry.List(0)
+		So(requestIDs(list), ShouldResemble, []string{"c", "b"})
+		job, _ := registry.Get("a")
+		So(job, ShouldBeNil)
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	Convey("Given a MemoryStore", t, func() {
+		testStore(func(maxJobs int) Store { return NewMemoryStore(maxJobs) })
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	Convey("Given a FileStore", t, func() {
+		dir, err := ioutil.TempDir("", "jobs")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		testStore(func(maxJobs int) Store { return NewFileStore(filepath.Join(dir, "store"), maxJobs) })
+
+		Convey("Then the jobs are read by a new store in the same directory", func() {
+			NewRegistry(NewFileStore(dir, 0)).Queue("a", "s3://bucket/a.csv", "")
+
+			job, err := NewFileStore(dir, 0).Get("a")
+			So(err, ShouldBeNil)
+			So(job.InputURL, ShouldEqual, "s3://bucket/a.csv")
+		})
+
+		Convey("Then a new store indexes the jobs in the directory, in the order they were created", func() {
+			registry := NewRegistry(NewFileStore(dir, 0))
+			registry.Queue("a", "", "")
+			registry.Queue("b", "", "")
+			registry.Queue("c", "", "")
+
+			store := NewFileStore(dir, 2)
+			So(store.Save(Job{RequestID: "d", Created: time.Now()}), ShouldBeNil)
+			list, err := store.List(0)
+			So(err, ShouldBeNil)
+			So(requestIDs(list), ShouldResemble, []string{"d", "c"})
+		})
+
+		Convey("Then saving a job, or listing the most recent, does not read the other jobs", func() {
+			store := NewFileStore(dir, 2)
+			registry := NewRegistry(store)
+			registry.Queue("a", "", "")
+			registry.Queue("b", "", "")
+			So(ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte("not json"), 0644), ShouldBeNil)
+
+			registry.Queue("c", "", "")
+			list, err := store.List(1)
+			So(err, ShouldBeNil)
+			So(requestIDs(list), ShouldResemble, []string{"c"})
+		})
+
+		Convey("Then a request id cannot refer to a file outside the directory", func() {
+			store := NewFileStore(filepath.Join(dir, "store"), 0)
+			So(store.Save(Job{RequestID: "../escaped"}), ShouldBeNil)
+
+			_, err := os.Stat(filepath.Join(dir, "escaped.json"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			job, _ := store.Get("../escaped")
+			So(job, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given a registry", t, func() {
+		registry := NewRegistry(NewMemoryStore(0))
+		registry.Queue("a", "s3://bucket/a.csv", "s3://bucket/a.out")
+
+		Convey("Then a queued job is recorded", func() {
+			job, _ := registry.Get("a")
+			So(job.State, ShouldEqual, StateQueued)
+			So(job.OutputURL, ShouldEqual, "s3://bucket/a.out")
+			So(job.Finished(), ShouldBeFalse)
+			So(len(job.History), ShouldEqual, 1)
+		})
+
+		Convey("Then each state change is recorded", func() {
+			registry.SetState("a", StateDownloading)
+			registry.SetState("a", StateTransforming)
+
+			job, _ := registry.Get("a")
+			So(job.State, ShouldEqual, StateTransforming)
+			So(len(job.History), ShouldEqual, 3)
+			So(job.History[1].State, ShouldEqual, StateDownloading)
+			So(job.Updated, ShouldResemble, job.History[2].Time)
+		})
+
+		Convey("Then a successful job is recorded with its stats", func() {
+			registry.Finish("a", &transformer.Stats{RowsWritten: 3}, "", nil)
+
+			job, _ := registry.Get("a")
+			So(job.State, ShouldEqual, StateSucceeded)
+			So(job.Finished(), ShouldBeTrue)
+			So(job.Stats.RowsWritten, ShouldEqual, 3)
+			So(job.Error, ShouldBeEmpty)
+		})
+
+		Convey("Then a failed job is recorded with its stage and error", func() {
+			registry.Finish("a", nil, "upload", errors.New("Access denied"))
+
+			job, _ := registry.Get("a")
+			So(job.State, ShouldEqual, StateFailed)
+			So(job.Stage, ShouldEqual, "upload")
+			So(job.Error, ShouldEqual, "Access denied")
+		})
+
+		Convey("Then a cancelled job is recorded as cancelled and finished", func() {
+			registry.Finish("a", nil, "transform", JobCancelledErr)
+
+			job, _ := registry.Get("a")
+			So(job.State, ShouldEqual, StateCancelled)
+			So(job.Finished(), ShouldBeTrue)
+			So(job.Stage, ShouldEqual, "transform")
+		})
+
+		Convey("Then each job in progress with the request id is cancelled, until it has finished", func() {
+			first, cancelFirst := context.WithCancel(context.Background())
+			second, cancelSecond := context.WithCancel(context.Background())
+			finishFirst := registry.Start("a", cancelFirst)
+			finishSecond := registry.Start("a", cancelSecond)
+			finishSecond()
+
+			So(registry.Cancel("a"), ShouldBeTrue)
+			So(first.Err(), ShouldEqual, context.Canceled)
+			So(second.Err(), ShouldBeNil)
+			finishFirst()
+			So(registry.Cancel("a"), ShouldBeFalse)
+		})
+
+		Convey("Then a job that is not in progress cannot be cancelled", func() {
+			So(registry.Cancel("a"), ShouldBeFalse)
+			So(registry.Cancel("unknown"), ShouldBeFalse)
+		})
+
+		Convey("Then a request without a request id, or an unknown request id, is ignored", func() {
+			registry.Queue("", "s3://bucket/b.csv", "")
+			registry.SetState("unknown", StateDownloading)
+
+			list, _ := registry.List(0)
+			So(requestIDs(list), ShouldResemble, []string{"a"})
+		})
+	})
+}
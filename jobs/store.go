@@ -0,0 +1,261 @@
+package jobs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore a Store holding the most recently created jobs in memory. The jobs are lost when the service restarts.
+type MemoryStore struct {
+	maxJobs int
+	mutex   sync.Mutex
+	jobs    map[string]Job
+	// order the request ids, in the order the jobs were created
+	order []string
+}
+
+// NewMemoryStore creates a MemoryStore holding up to maxJobs jobs (or any number if maxJobs is not positive).
+func NewMemoryStore(maxJobs int) *MemoryStore {
+	return &MemoryStore{maxJobs: maxJobs, jobs: make(map[string]Job)}
+}
+
+func (s *MemoryStore) Save(job Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.jobs[job.RequestID]; ok {
+		s.jobs[job.RequestID] = job
+		if existing.Created.Equal(job.Created) {
+			return nil
+		}
+		// a new job with the same request id
+		s.remove(job.RequestID)
+	}
+	s.jobs[job.RequestID] = job
+	s.order = append(s.order, job.RequestID)
+	if s.maxJobs > 0 && len(s.order) > s.maxJobs {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+// remove removes the request id from the order, so it can be added again at the end. Must be called with the mutex
+// held.
+func (s *MemoryStore) remove(requestID string) {
+	for i, id := range s.order {
+		if id == requestID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) Get(requestID string) (*Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, ok := s.jobs[requestID]
+	if !ok {
+		return nil, nil
+	}
+	job.History = append([]StateChange(nil), job.History...)
+	return &job, nil
+}
+
+func (s *MemoryStore) List(limit int) ([]Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	jobs := []Job{}
+	for i := len(s.order) - 1; i >= 0 && (limit <= 0 || len(jobs) < limit); i-- {
+		jobs = append(jobs, s.jobs[s.order[i]])
+	}
+	return jobs, nil
+}
+
+const jobFileExt = ".json"
+
+// FileStore a Store holding each job in a json file in a directory, so the jobs survive a restart. Once there are more
+// than maxJobs files the least recently created are removed. The request ids are indexed in the order the jobs were
+// created, so that a job can be saved, and the most recent listed, without reading every file. The index is loaded
+// from the directory when the store is first used, so the directory must not be shared with another store.
+type FileStore struct {
+	dir     string
+	maxJobs int
+	mutex   sync.Mutex
+	loaded  bool
+	// created the time each indexed job was created, by request id
+	created map[string]time.Time
+	// order the request ids, in the order the jobs were created
+	order []string
+}
+
+// NewFileStore creates a FileStore in the directory, which is created when the first job is saved, holding up to
+// maxJobs jobs (or any number if maxJobs is not positive).
+func NewFileStore(dir string, maxJobs int) *FileStore {
+	return &FileStore{dir: dir, maxJobs: maxJobs}
+}
+
+// path returns the file of the job, escaping the request id so that it cannot refer to a file outside the directory.
+func (s *FileStore) path(requestID string) string {
+	return filepath.Join(s.dir, url.QueryEscape(requestID)+jobFileExt)
+}
+
+// Save writes the job to a temporary file and renames it, so a partially written job is never read.
+func (s *FileStore) Save(job Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(s.dir, ".job")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(body); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), s.path(job.RequestID)); err != nil {
+		return err
+	}
+	s.index(job)
+	return s.prune()
+}
+
+func (s *FileStore) Get(requestID string) (*Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.read(s.path(requestID))
+}
+
+func (s *FileStore) List(limit int) ([]Job, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	for i := len(s.order) - 1; i >= 0 && (limit <= 0 || len(jobs) < limit); i-- {
+		job, err := s.read(s.path(s.order[i]))
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs, nil
+}
+
+// read returns the job in the file, or nil if there is no file.
+func (s *FileStore) read(path string) (*Job, error) {
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err = json.Unmarshal(body, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// load indexes the jobs in the directory, if they have not been indexed yet. Must be called with the mutex held.
+func (s *FileStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	jobs, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	s.created = make(map[string]time.Time, len(jobs))
+	s.order = make([]string, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		s.index(jobs[i])
+	}
+	s.loaded = true
+	return nil
+}
+
+// index adds the job to the index, unless it is already indexed. A new job with the same request id as an indexed
+// job is moved to the end. Must be called with the mutex held.
+func (s *FileStore) index(job Job) {
+	if created, ok := s.created[job.RequestID]; ok {
+		if created.Equal(job.Created) {
+			return
+		}
+		for i, id := range s.order {
+			if id == job.RequestID {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+	s.created[job.RequestID] = job.Created
+	s.order = append(s.order, job.RequestID)
+}
+
+// readAll returns every job in the directory, most recently created first.
+func (s *FileStore) readAll() ([]Job, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []Job{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || filepath.Ext(file.Name()) != jobFileExt {
+			continue
+		}
+		job, err := s.read(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Stable(byCreatedDescending(jobs))
+	return jobs, nil
+}
+
+// prune removes the least recently created jobs once there are more than maxJobs. Must be called with the mutex held.
+func (s *FileStore) prune() error {
+	for s.maxJobs > 0 && len(s.order) > s.maxJobs {
+		if err := os.Remove(s.path(s.order[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(s.created, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+type byCreatedDescending []Job
+
+func (j byCreatedDescending) Len() int           { return len(j) }
+func (j byCreatedDescending) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+func (j byCreatedDescending) Less(a, b int) bool { return j[a].Created.After(j[b].Created) }
@@ -1,37 +1,121 @@
 package main
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/dp-dd-csv-transformer/handlers"
+	"github.com/ONSdigital/dp-dd-csv-transformer/health"
+	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
 	"github.com/ONSdigital/dp-dd-csv-transformer/message"
+	"github.com/ONSdigital/dp-dd-csv-transformer/metrics"
+	"github.com/ONSdigital/dp-dd-csv-transformer/ons_aws"
 	"github.com/ONSdigital/go-ns/log"
+	"github.com/Shopify/sarama"
 	"github.com/bsm/sarama-cluster"
+	gometrics "github.com/rcrowley/go-metrics"
 )
 
 func main() {
 	config.Load()
 
+	// the Kafka clients' metrics are served by the /metrics endpoint
+	kafkaMetrics := gometrics.NewRegistry()
+	metrics.RegisterGoMetrics("csv_transformer_kafka", kafkaMetrics)
+
 	consumerConfig := cluster.NewConfig()
+	consumerConfig.MetricRegistry = kafkaMetrics
+	consumerConfig.Consumer.Offsets.CommitInterval = config.KafkaCommitInterval
 	consumer, err := cluster.NewConsumer([]string{config.KafkaAddr}, config.KafkaConsumerGroup, []string{config.KafkaConsumerTopic}, consumerConfig)
 	if err != nil {
 		log.Error(err, nil)
 		os.Exit(1)
 	}
 
-	// Trap SIGINT to trigger a graceful shutdown.
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
+	producerConfig := sarama.NewConfig()
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.MetricRegistry = kafkaMetrics
+	producer, err := sarama.NewSyncProducer([]string{config.KafkaAddr}, producerConfig)
+	if err != nil {
+		log.Error(err, nil)
+		os.Exit(1)
+	}
+
+	// Trap SIGINT and SIGTERM (sent by ECS and CodeDeploy) to trigger a graceful shutdown.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	monitor := health.NewMonitor(config.ReadinessCacheTTL)
+	monitor.Add("kafka", message.NewKafkaChecker([]string{config.KafkaAddr}, config.KafkaConsumerTopic, config.ReadinessTimeout))
+	for _, bucket := range config.ReadinessS3Buckets {
+		monitor.Add("s3:"+bucket, ons_aws.NewBucketChecker(bucket, config.ReadinessTimeout))
+	}
+	monitor.Add("hierarchies", hierarchy.NewSourceChecker(config.ReadinessTimeout))
+
+	router := http.NewServeMux()
+	router.Handle("/transformer", handlers.NewTransformHandler(handlers.HandleRequest))
+	router.HandleFunc("/healthcheck", handlers.HealthcheckHandler)
+	router.Handle("/ready", handlers.NewReadyHandler(monitor))
+	router.Handle("/metrics", metrics.DefaultRegistry)
+	router.HandleFunc("/jobs", handlers.JobsHandler)
+	router.HandleFunc("/jobs/", handlers.JobsHandler)
+	server := &http.Server{Addr: config.BindAddr, Handler: router}
 	go func() {
-		<-signals
+		log.Debug("Starting http server", log.Data{"bindAddr": config.BindAddr})
+		if err := server.ListenAndServe(); err != nil {
+			log.Error(err, nil)
+			os.Exit(1)
+		}
+	}()
 
-		consumer.Close()
-		log.Debug("Graceful shutdown was successful.", nil)
-		os.Exit(0)
+	consumerCtx, stopConsuming := context.WithCancel(context.Background())
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		message.ConsumerLoop(consumerCtx, consumer, producer, handlers.HandleRequest)
 	}()
 
-	message.ConsumerLoop(consumer, handlers.HandleRequest)
+	sig := <-signals
+	log.Debug("Shutting down", log.Data{"signal": sig.String(), "gracePeriod": config.ShutdownGracePeriod.String()})
+	stopConsuming()
+	os.Exit(shutdown(signals, consumerDone, consumer, producer))
+}
+
+// shutdown waits up to the ShutdownGracePeriod (or until another signal is received) for the requests in progress to
+// finish, then cancels any that have not, removing their temporary files. Once the consumer has processed the messages
+// it received their offsets are committed, as it is closed, and the producer is closed once it has sent its messages.
+// The exit code is 0 if every request finished and the clients closed cleanly, or 1 otherwise.
+func shutdown(signals <-chan os.Signal, consumerDone <-chan struct{}, consumer *cluster.Consumer, producer sarama.SyncProducer) int {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownGracePeriod)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Debug("Cancelling the requests in progress", log.Data{"signal": sig.String()})
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
+	exitCode := 0
+	if !handlers.Shutdown(ctx) {
+		log.Debug("The requests in progress were cancelled, their messages will be consumed again on restart", nil)
+		exitCode = 1
+	}
+	<-consumerDone
+
+	if err := consumer.Close(); err != nil {
+		log.Error(err, log.Data{"message": "Failed to commit offsets and close the consumer"})
+		exitCode = 1
+	}
+	if err := producer.Close(); err != nil {
+		log.Error(err, log.Data{"message": "Failed to close the producer"})
+		exitCode = 1
+	}
+	log.Debug("Shutdown complete", log.Data{"exitCode": exitCode})
+	return exitCode
 }
@@ -0,0 +1,46 @@
+package message
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/Shopify/sarama"
+)
+
+// sendToDeadLetterTopic wraps the original message in a DeadLetter envelope, recording why and at which stage it
+// failed, and sends it to the dead letter topic.
+func sendToDeadLetterTopic(producer Producer, message *sarama.ConsumerMessage, requestID string, stage string, attempts int, cause error) error {
+	deadLetter := event.DeadLetter{
+		Payload:         message.Value,
+		RequestID:       requestID,
+		Reason:          cause.Error(),
+		Stage:           stage,
+		Attempts:        attempts,
+		Timestamp:       time.Now().UTC(),
+		SourceTopic:     message.Topic,
+		SourcePartition: message.Partition,
+		SourceOffset:    message.Offset,
+	}
+
+	bytes, err := json.Marshal(deadLetter)
+	if err != nil {
+		log.ErrorC(requestID, err, log.Data{"message": "Failed to marshal dead letter"})
+		return err
+	}
+
+	_, _, err = producer.SendMessage(&sarama.ProducerMessage{
+		Topic: config.KafkaDeadLetterTopic,
+		Key:   sarama.StringEncoder(requestID),
+		Value: sarama.ByteEncoder(bytes),
+	})
+	if err != nil {
+		log.ErrorC(requestID, err, log.Data{"message": "Failed to send message to dead letter topic", "topic": config.KafkaDeadLetterTopic})
+		return err
+	}
+
+	log.DebugC(requestID, "Sent message to dead letter topic", log.Data{"topic": config.KafkaDeadLetterTopic, "stage": stage, "reason": deadLetter.Reason})
+	return nil
+}
@@ -0,0 +1,134 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/handlers"
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func sentDeadLetters(producer *recordingProducer) []event.DeadLetter {
+	var deadLetters []event.DeadLetter
+	for _, msg := range producer.sent {
+		if msg.Topic != config.KafkaDeadLetterTopic {
+			continue
+		}
+		bytes, _ := msg.Value.Encode()
+		var deadLetter event.DeadLetter
+		json.Unmarshal(bytes, &deadLetter)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters
+}
+
+func TestDeadLetters(t *testing.T) {
+	request, _ := event.NewTransformRequest("s3://bucket/input.csv", "s3://bucket/output.csv", "foo")
+	requestJson, _ := json.Marshal(request)
+
+	Convey("Given a message that cannot be parsed", t, func() {
+		producer := &recordingProducer{}
+		message := &sarama.ConsumerMessage{Value: []byte("not json"), Topic: "transform-request", Partition: 2, Offset: 9}
+
+		err := processMessage(context.Background(), message, producer, func(context.Context, event.TransformRequest) handlers.TransformResponse {
+			panic("should not be called")
+		})
+
+		Convey("Then it is sent to the dead letter topic with the original payload and a parse failure", func() {
+			So(err, ShouldBeNil)
+			deadLetters := sentDeadLetters(producer)
+			So(len(deadLetters), ShouldEqual, 1)
+			So(string(deadLetters[0].Payload), ShouldEqual, "not json")
+			So(deadLetters[0].Stage, ShouldEqual, event.StageParse)
+			So(deadLetters[0].Reason, ShouldNotBeEmpty)
+			So(deadLetters[0].Attempts, ShouldEqual, 1)
+			So(deadLetters[0].Timestamp.IsZero(), ShouldBeFalse)
+			So(deadLetters[0].SourceTopic, ShouldEqual, "transform-request")
+			So(deadLetters[0].SourcePartition, ShouldEqual, 2)
+			So(deadLetters[0].SourceOffset, ShouldEqual, 9)
+		})
+	})
+
+	Convey("Given a transform that fails", t, func() {
+		producer := &recordingProducer{}
+		message := &sarama.ConsumerMessage{Value: requestJson}
+
+		processMessage(context.Background(), message, producer, func(context.Context, event.TransformRequest) handlers.TransformResponse {
+			return handlers.TransformResponse{Message: "THIS IS AN AWS ERROR", Stage: event.StageUpload, Attempts: make([]retry.Attempt, 3), Err: errors.New("THIS IS AN AWS ERROR")}
+		})
+
+		Convey("Then it is sent to the dead letter topic with the failing stage", func() {
+			deadLetters := sentDeadLetters(producer)
+			So(len(deadLetters), ShouldEqual, 1)
+			So(deadLetters[0].Payload, ShouldResemble, requestJson)
+			So(deadLetters[0].RequestID, ShouldEqual, "foo")
+			So(deadLetters[0].Stage, ShouldEqual, event.StageUpload)
+			So(deadLetters[0].Reason, ShouldEqual, "THIS IS AN AWS ERROR")
+			So(deadLetters[0].Attempts, ShouldEqual, 3)
+		})
+
+		Convey("And the transform failed event is still published, with the failing stage", func() {
+			So(producer.sent[len(producer.sent)-1].Topic, ShouldEqual, config.KafkaTransformFailedTopic)
+			bytes, _ := producer.sent[len(producer.sent)-1].Value.Encode()
+			var result event.TransformResult
+			json.Unmarshal(bytes, &result)
+			So(result.Stage, ShouldEqual, event.StageUpload)
+			So(result.Error, ShouldEqual, "THIS IS AN AWS ERROR")
+		})
+	})
+
+	Convey("Given a transform that succeeds", t, func() {
+		producer := &recordingProducer{}
+		message := &sarama.ConsumerMessage{Value: requestJson}
+
+		processMessage(context.Background(), message, producer, func(context.Context, event.TransformRequest) handlers.TransformResponse {
+			return handlers.TransformResponse{Message: "done"}
+		})
+
+		Convey("Then nothing is sent to the dead letter topic", func() {
+			So(len(sentDeadLetters(producer)), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a producer that cannot send messages", t, func() {
+		sendErr := errors.New("kafka: client has run out of available brokers")
+		producer := &recordingProducer{err: sendErr}
+
+		Convey("Then the error is returned for a message that cannot be parsed", func() {
+			err := processMessage(context.Background(), &sarama.ConsumerMessage{Value: []byte("not json")}, producer, func(context.Context, event.TransformRequest) handlers.TransformResponse {
+				panic("should not be called")
+			})
+			So(err, ShouldEqual, sendErr)
+		})
+
+		Convey("Then the error is returned for a failed transform, without publishing the result", func() {
+			err := processMessage(context.Background(), &sarama.ConsumerMessage{Value: requestJson}, producer, func(context.Context, event.TransformRequest) handlers.TransformResponse {
+				return handlers.TransformResponse{Stage: event.StageUpload, Err: errors.New("THIS IS AN AWS ERROR")}
+			})
+			So(err, ShouldEqual, sendErr)
+			So(len(producer.sent), ShouldEqual, 1)
+			So(producer.sent[0].Topic, ShouldEqual, config.KafkaDeadLetterTopic)
+		})
+	})
+
+	Convey("Given a transform interrupted by the service shutting down", t, func() {
+		producer := &recordingProducer{}
+		message := &sarama.ConsumerMessage{Value: requestJson}
+
+		err := processMessage(context.Background(), message, producer, func(context.Context, event.TransformRequest) handlers.TransformResponse {
+			return handlers.TransformResponse{Message: jobs.JobInterruptedErr.Error(), Stage: event.StageTransform, Err: jobs.JobInterruptedErr}
+		})
+
+		Convey("Then nothing is sent to the dead letter topic, and no result is published", func() {
+			So(err, ShouldEqual, jobs.JobInterruptedErr)
+			So(len(producer.sent), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,27 @@
+package event
+
+import (
+	"time"
+)
+
+// The stages at which processing of a TransformRequest can fail.
+const (
+	StageParse     = "parse"
+	StageDownload  = "download"
+	StageTransform = "transform"
+	StageUpload    = "upload"
+)
+
+// DeadLetter is the envelope sent to the dead letter topic for a message that could not be processed. Payload holds
+// the original message value (base64 encoded in json) so that it can be replayed unchanged.
+type DeadLetter struct {
+	Payload         []byte    `json:"payload"`
+	RequestID       string    `json:"requestId,omitempty"`
+	Reason          string    `json:"reason"`
+	Stage           string    `json:"stage"`
+	Attempts        int       `json:"attempts"`
+	Timestamp       time.Time `json:"timestamp"`
+	SourceTopic     string    `json:"sourceTopic"`
+	SourcePartition int32     `json:"sourcePartition"`
+	SourceOffset    int64     `json:"sourceOffset"`
+}
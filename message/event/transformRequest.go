@@ -3,26 +3,35 @@ package event
 import (
 	"fmt"
 
-	"github.com/ONSdigital/dp-dd-csv-transformer/ons_aws"
+	"github.com/ONSdigital/dp-dd-csv-transformer/storage"
 	"github.com/ONSdigital/go-ns/log"
 )
 
 type TransformRequest struct {
-	InputURL  ons_aws.S3URL `json:"inputUrl"`
-	OutputURL ons_aws.S3URL `json:"outputUrl"`
-	RequestID string    `json:"requestId"`
+	InputURL  storage.URL `json:"inputUrl"`
+	OutputURL storage.URL `json:"outputUrl"`
+	RequestID string      `json:"requestId"`
+	// HierarchyColumns overrides the HierarchyColumns config for this request, if set.
+	HierarchyColumns *bool `json:"hierarchyColumns,omitempty"`
+	// TimePeriodColumns overrides the TimePeriodColumns config for this request, if set.
+	TimePeriodColumns *bool `json:"timePeriodColumns,omitempty"`
 }
 
 var NilRequest = TransformRequest{}
 
 func NewTransformRequest(inputUrl string, outputUrl string, requestId string) (TransformRequest, error) {
-	var input, output ons_aws.S3URL
+	var input, output storage.URL
 	var err error
-	if input, err = ons_aws.NewS3URL(inputUrl); err != nil {
+	if input, err = storage.NewURL(inputUrl); err != nil {
 		log.Error(err, log.Data{"Details": "Invalid inputUrl"})
 		return NilRequest, err
 	}
-	if output, err = ons_aws.NewS3URL(outputUrl); err != nil {
+	if output, err = storage.NewURL(outputUrl); err != nil {
+		log.Error(err, log.Data{"Details": "Invalid outputUrl"})
+		return NilRequest, err
+	}
+	if !output.IsWritable() {
+		err = storage.ReadOnlyError{URL: outputUrl}
 		log.Error(err, log.Data{"Details": "Invalid outputUrl"})
 		return NilRequest, err
 	}
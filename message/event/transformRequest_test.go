@@ -57,6 +57,27 @@ func TestNewValidatesOutputURL(t *testing.T) {
 	})
 }
 
+func TestNewAcceptsOtherStorage(t *testing.T) {
+	Convey("Given a call to NewTransformRequest with an https input and a file output", t, func() {
+		var transformerRequest, err = NewTransformRequest("https://example.com/input.csv", "file:///data/output.csv", "foo")
+		Convey("Then the request is created", func() {
+			So(err, ShouldBeNil)
+			So(transformerRequest.InputURL.String(), ShouldEqual, "https://example.com/input.csv")
+			So(transformerRequest.OutputURL.GetFilePath(), ShouldEqual, "/data/output.csv")
+		})
+	})
+}
+
+func TestNewValidatesOutputURLIsWritable(t *testing.T) {
+	Convey("Given a call to NewTransformRequest with an https output", t, func() {
+		var transformerRequest, err = NewTransformRequest(inputUrl, "https://example.com/output.csv", "foo")
+		Convey("Then returned request is nil and err is not", func() {
+			So(err, ShouldNotEqual, nil)
+			So(transformerRequest, ShouldResemble, NilRequest)
+		})
+	})
+}
+
 func TestTransformRequestCanBeMarshaledAndUnmarshaled(t *testing.T) {
 	var transformerRequest, _ = NewTransformRequest(inputUrl, outputUrl, "foo")
 
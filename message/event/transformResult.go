@@ -0,0 +1,49 @@
+package event
+
+import (
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/ONSdigital/dp-dd-csv-transformer/storage"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+)
+
+// TransformResult is the event published once a TransformRequest has been processed. Stage and Error are only set if
+// the transform failed.
+type TransformResult struct {
+	RequestID  string             `json:"requestId"`
+	InputURL   storage.URL        `json:"inputUrl"`
+	OutputURL  storage.URL        `json:"outputUrl"`
+	RowCount   int64              `json:"rowCount"`
+	DurationNs int64              `json:"durationNs"`
+	Stats      *transformer.Stats `json:"stats,omitempty"`
+	Attempts   []retry.Attempt    `json:"attempts,omitempty"`
+	Stage      string             `json:"stage,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// NewTransformResult creates a TransformResult for the given request. stats may be nil if the transform was not
+// started. A nil err indicates success, otherwise stage is the stage the transform failed at.
+func NewTransformResult(request TransformRequest, stats *transformer.Stats, duration time.Duration, attempts []retry.Attempt, stage string, err error) TransformResult {
+	result := TransformResult{
+		RequestID:  request.RequestID,
+		InputURL:   request.InputURL,
+		OutputURL:  request.OutputURL,
+		DurationNs: duration.Nanoseconds(),
+		Stats:      stats,
+		Attempts:   attempts,
+	}
+	if stats != nil {
+		result.RowCount = stats.RowsWritten
+	}
+	if err != nil {
+		result.Stage = stage
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Failed returns true if the transform failed.
+func (r *TransformResult) Failed() bool {
+	return len(r.Error) > 0
+}
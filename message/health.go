@@ -0,0 +1,34 @@
+package message
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/health"
+	"github.com/Shopify/sarama"
+)
+
+// NewKafkaChecker creates a health.Checker that connects to the Kafka brokers, and checks that the topic the consumer
+// reads from has partitions.
+func NewKafkaChecker(addrs []string, topic string, timeout time.Duration) health.Checker {
+	return func() error {
+		kafkaConfig := sarama.NewConfig()
+		kafkaConfig.Net.DialTimeout = timeout
+		kafkaConfig.Net.ReadTimeout = timeout
+		kafkaConfig.Net.WriteTimeout = timeout
+		kafkaConfig.Metadata.Retry.Max = 0
+		client, err := sarama.NewClient(addrs, kafkaConfig)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		if len(partitions) == 0 {
+			return fmt.Errorf("Topic %s has no partitions", topic)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+package message
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKafkaChecker(t *testing.T) {
+
+	Convey("Given a broker with the consumer topic", t, func() {
+		broker := sarama.NewMockBroker(t, 1)
+		defer broker.Close()
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("transform-request", 0, broker.BrokerID()),
+		})
+
+		Convey("Then the check succeeds", func() {
+			So(NewKafkaChecker([]string{broker.Addr()}, "transform-request", time.Second)(), ShouldBeNil)
+		})
+
+		Convey("Then the check fails for a topic that does not exist", func() {
+			So(NewKafkaChecker([]string{broker.Addr()}, "unknown", time.Second)(), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given no broker", t, func() {
+		broker := sarama.NewMockBroker(t, 1)
+		addr := broker.Addr()
+		broker.Close()
+
+		Convey("Then the check fails", func() {
+			So(NewKafkaChecker([]string{addr}, "transform-request", time.Second)(), ShouldNotBeNil)
+		})
+	})
+}
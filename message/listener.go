@@ -1,38 +1,102 @@
 package message
 
 import (
+	"context"
 	"encoding/json"
 
 	"fmt"
+	"time"
 
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/dp-dd-csv-transformer/handlers"
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
 	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
 	"github.com/ONSdigital/go-ns/log"
 	"github.com/Shopify/sarama"
 )
 
-func ConsumerLoop(listener Listener, transformerer handlers.TransformFunc) {
-	for message := range listener.Messages() {
-		log.Debug("Message received from Kafka: "+string(message.Value), nil)
-		processMessage(message, transformerer)
+// ConsumerLoop processes the messages received from the listener using config.TransformWorkers concurrent workers.
+// The offset of a message is marked, so that it is committed at the next commit interval, once it and all earlier
+// messages on its partition have been processed. A dead letter or result that cannot be sent is retried with backoff
+// until it is sent, holding its worker, so no offset is left behind a failed send while later messages are processed.
+// If the process dies mid-transform, the transform is interrupted by the service shutting down, or the send is still
+// failing once the context is done, the offset is not marked (nor those of the later messages on its partition), and
+// the message will be consumed again on restart. No more messages are received once the context is done, and
+// ConsumerLoop returns once all received messages have been processed, or the listener is closed.
+func ConsumerLoop(ctx context.Context, listener Listener, producer Producer, transformerer handlers.TransformFunc) {
+	tracker := newOffsetTracker(listener)
+	producer = newRetryingProducer(ctx, producer)
+	pool := newWorkerPool(config.TransformWorkers, config.PreservePartitionOrder, func(message *sarama.ConsumerMessage) {
+		if err := processMessage(context.Background(), message, producer, transformerer); err != nil {
+			log.Error(err, log.Data{"message": "Not marking the offset of the message", "partition": message.Partition, "offset": message.Offset})
+			return
+		}
+		tracker.complete(message)
+	})
+	defer pool.close()
+
+	for {
+		select {
+		case message, ok := <-listener.Messages():
+			if !ok {
+				return
+			}
+			log.Debug("Message received from Kafka: "+string(message.Value), nil)
+			tracker.add(message)
+			queueRequest(message)
+			pool.dispatch(message)
+		case <-ctx.Done():
+			log.Debug("Stopped receiving messages from Kafka", nil)
+			return
+		}
+	}
+}
+
+// queueRequest records the request in the message as queued, while it waits for a free worker. A message that cannot
+// be parsed is left to processMessage.
+func queueRequest(message *sarama.ConsumerMessage) {
+	var transformRequest event.TransformRequest
+	if err := json.Unmarshal(message.Value, &transformRequest); err == nil {
+		handlers.QueueRequest(transformRequest)
 	}
 }
 
-func processMessage(message *sarama.ConsumerMessage, transformer handlers.TransformFunc) error {
+// processMessage transforms the request in the message, sending the message to the dead letter topic if it cannot be
+// parsed or the transform fails, and publishes the result. An error is returned if the transform was interrupted, or
+// the dead letter or result could not be sent, in which case the message has not been fully processed.
+func processMessage(ctx context.Context, message *sarama.ConsumerMessage, producer Producer, transformer handlers.TransformFunc) error {
 
 	var transformRequest event.TransformRequest
 	if err := json.Unmarshal(message.Value, &transformRequest); err != nil {
 		log.Error(err, nil)
-		return err
+		return sendToDeadLetterTopic(producer, message, "", event.StageParse, 1, err)
 	}
 
 	log.Debug(fmt.Sprintf("About to process:%s", transformRequest.String()), nil)
-	transformer(transformRequest)
+	startTime := time.Now()
+	resp := transformer(ctx, transformRequest)
 	log.Debug(fmt.Sprintf("Finished processing:%s", transformRequest.String()), nil)
 
-	return nil
+	if resp.Err == jobs.JobInterruptedErr {
+		// neither a failure nor a result: the request will be consumed again once the service restarts
+		return resp.Err
+	}
+
+	if resp.Err != nil {
+		attempts := len(resp.Attempts)
+		if attempts < 1 {
+			attempts = 1
+		}
+		if err := sendToDeadLetterTopic(producer, message, transformRequest.RequestID, resp.Stage, attempts, resp.Err); err != nil {
+			return err
+		}
+	}
+
+	return publishResult(producer, event.NewTransformResult(transformRequest, resp.Stats, time.Since(startTime), resp.Attempts, resp.Stage, resp.Err))
 }
 
+// Listener defines the interface of the Kafka consumer, implemented by cluster.Consumer.
 type Listener interface {
 	Messages() <-chan *sarama.ConsumerMessage
+	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
 }
@@ -1,11 +1,17 @@
 package message_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/dp-dd-csv-transformer/handlers"
+	"github.com/ONSdigital/dp-dd-csv-transformer/jobs"
 	"github.com/ONSdigital/dp-dd-csv-transformer/message"
 	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
 	"github.com/Shopify/sarama"
@@ -15,7 +21,7 @@ import (
 
 var messagesProcessed = 0
 
-func mockFilterFunc(transformerRequest event.TransformRequest) handlers.TransformResponse {
+func mockFilterFunc(ctx context.Context, transformerRequest event.TransformRequest) handlers.TransformResponse {
 	messagesProcessed++
 	return handlers.TransformResponse{Message: "done"}
 }
@@ -33,10 +39,11 @@ func TestProcessor(t *testing.T) {
 	mockConsumer.ExpectConsumePartition(topicName, 0, 0).YieldMessage(&sarama.ConsumerMessage{Value: []byte(messageJson)})
 
 	mockListener := newMocklistener(mockConsumer, topicName)
+	mockProducer := newMockProducer()
 
 	Convey("Given a mock consumer and transformerer", t, func() {
 		messagesProcessed = 0
-		go message.ConsumerLoop(mockListener, mockFilterFunc)
+		go message.ConsumerLoop(context.Background(), mockListener, mockProducer, mockFilterFunc)
 		loop := 0
 
 		// Give this at least 300 milli-seconds to run before asserting the message was processed
@@ -48,23 +55,255 @@ func TestProcessor(t *testing.T) {
 			loop++
 		}
 		So(messagesProcessed, ShouldEqual, 1)
+		select {
+		case sent := <-mockProducer.messages:
+			So(sent.Topic, ShouldEqual, config.KafkaTransformCompleteTopic)
+		case <-time.After(time.Second):
+			So("no result was published", ShouldBeEmpty)
+		}
 		mockConsumer.Close()
 	})
 
 }
 
+func TestOffsetIsMarkedOnceProcessed(t *testing.T) {
+	request, _ := event.NewTransformRequest("s3://bucket/file.csv", "s3://bucket/file.csv", "foo")
+	messageJson, _ := json.Marshal(request)
+
+	Convey("Given a transform that is in progress", t, func() {
+		messages := make(chan *sarama.ConsumerMessage, 1)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		release := make(chan bool)
+		blockingTransform := func(ctx context.Context, transformRequest event.TransformRequest) handlers.TransformResponse {
+			<-release
+			return handlers.TransformResponse{Message: "done"}
+		}
+		go message.ConsumerLoop(context.Background(), mockListener, newMockProducer(), blockingTransform)
+		messages <- &sarama.ConsumerMessage{Value: messageJson, Offset: 7}
+
+		Convey("Then the offset is not marked until the transform has finished", func() {
+			select {
+			case <-mockListener.marked:
+				So("the offset was marked before the transform finished", ShouldBeEmpty)
+			case <-time.After(100 * time.Millisecond):
+			}
+			close(release)
+			select {
+			case marked := <-mockListener.marked:
+				So(marked.Offset, ShouldEqual, 7)
+			case <-time.After(time.Second):
+				So("the offset was not marked", ShouldBeEmpty)
+			}
+			close(messages)
+		})
+	})
+
+	Convey("Given a message that cannot be parsed", t, func() {
+		messages := make(chan *sarama.ConsumerMessage, 1)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		go message.ConsumerLoop(context.Background(), mockListener, newMockProducer(), mockFilterFunc)
+		messages <- &sarama.ConsumerMessage{Value: []byte("not json"), Offset: 3}
+
+		Convey("Then the offset is marked so that it is not consumed again", func() {
+			select {
+			case marked := <-mockListener.marked:
+				So(marked.Offset, ShouldEqual, 3)
+			case <-time.After(time.Second):
+				So("the offset was not marked", ShouldBeEmpty)
+			}
+			close(messages)
+		})
+	})
+}
+
+func TestConsumerLoopShutdown(t *testing.T) {
+	request, _ := event.NewTransformRequest("s3://bucket/file.csv", "s3://bucket/file.csv", "foo")
+	messageJson, _ := json.Marshal(request)
+
+	Convey("Given a consumer loop whose context is cancelled", t, func() {
+		messages := make(chan *sarama.ConsumerMessage, 1)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			message.ConsumerLoop(ctx, mockListener, newMockProducer(), mockFilterFunc)
+		}()
+		cancel()
+
+		Convey("Then it returns without the listener being closed, and no more messages are received", func() {
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				So("the consumer loop did not return", ShouldBeEmpty)
+			}
+			messages <- &sarama.ConsumerMessage{Value: messageJson, Offset: 5}
+			So(len(messages), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a transform interrupted by the service shutting down", t, func() {
+		messages := make(chan *sarama.ConsumerMessage, 1)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		interruptedTransform := func(ctx context.Context, transformRequest event.TransformRequest) handlers.TransformResponse {
+			return handlers.TransformResponse{Err: jobs.JobInterruptedErr}
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			message.ConsumerLoop(context.Background(), mockListener, newMockProducer(), interruptedTransform)
+		}()
+		messages <- &sarama.ConsumerMessage{Value: messageJson, Offset: 8}
+		close(messages)
+
+		Convey("Then the offset is not marked, so that the message is consumed again on restart", func() {
+			<-done
+			So(len(mockListener.marked), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestOutcomeIsSentBeforeTheOffsetIsMarked(t *testing.T) {
+	request, _ := event.NewTransformRequest("s3://bucket/file.csv", "s3://bucket/file.csv", "foo")
+	messageJson, _ := json.Marshal(request)
+
+	Convey("Given a producer that fails to send the first messages, and then recovers", t, func() {
+		initialBackoff := config.RetryInitialBackoff
+		config.RetryInitialBackoff = time.Millisecond
+		Reset(func() { config.RetryInitialBackoff = initialBackoff })
+
+		messages := make(chan *sarama.ConsumerMessage, 3)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		producer := &flakyProducer{failures: 2, attempts: make(chan bool, 10)}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			message.ConsumerLoop(context.Background(), mockListener, producer, mockFilterFunc)
+		}()
+		messages <- &sarama.ConsumerMessage{Value: []byte("not json"), Offset: 1}
+		messages <- &sarama.ConsumerMessage{Value: messageJson, Offset: 2}
+		messages <- &sarama.ConsumerMessage{Value: messageJson, Offset: 3}
+		close(messages)
+
+		Convey("Then the send is retried, and the later messages are processed and have their offsets marked", func() {
+			<-done
+			So(len(producer.attempts), ShouldEqual, 5)
+			var latest *sarama.ConsumerMessage
+			for len(mockListener.marked) > 0 {
+				latest = <-mockListener.marked
+			}
+			So(latest, ShouldNotBeNil)
+			So(latest.Offset, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given a message that cannot be parsed, and a producer that cannot send it to the dead letter topic", t, func() {
+		messages := make(chan *sarama.ConsumerMessage, 1)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		producer := &flakyProducer{failures: -1, attempts: make(chan bool, 10)}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			message.ConsumerLoop(ctx, mockListener, producer, mockFilterFunc)
+		}()
+		messages <- &sarama.ConsumerMessage{Value: []byte("not json"), Offset: 4}
+
+		Convey("Then the send is retried until the service shuts down, and the offset is not marked", func() {
+			<-producer.attempts
+			cancel()
+			<-done
+			So(len(mockListener.marked), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestRequestIsQueuedOnceReceived(t *testing.T) {
+	request, _ := event.NewTransformRequest("s3://bucket/file.csv", "s3://bucket/file.csv", "queued-request")
+	messageJson, _ := json.Marshal(request)
+
+	Convey("Given a message received while the only worker is busy", t, func() {
+		messages := make(chan *sarama.ConsumerMessage, 2)
+		mockListener := mockListener{messages: messages, marked: make(chan *sarama.ConsumerMessage, 10)}
+		started := make(chan bool, 2)
+		release := make(chan bool)
+		blockingTransform := func(ctx context.Context, transformRequest event.TransformRequest) handlers.TransformResponse {
+			started <- true
+			<-release
+			return handlers.TransformResponse{Message: "done"}
+		}
+		go message.ConsumerLoop(context.Background(), mockListener, newMockProducer(), blockingTransform)
+		busy, _ := event.NewTransformRequest("s3://bucket/busy.csv", "s3://bucket/busy.csv", "busy")
+		busyJson, _ := json.Marshal(busy)
+		messages <- &sarama.ConsumerMessage{Value: busyJson, Offset: 1}
+		<-started
+		messages <- &sarama.ConsumerMessage{Value: messageJson, Offset: 2}
+
+		Convey("Then its job is recorded as queued before it is processed", func() {
+			var job jobs.Job
+			for i := 0; i < 100 && len(job.State) == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+				w := httptest.NewRecorder()
+				handlers.JobsHandler(w, httptest.NewRequest("GET", "/jobs/queued-request", nil))
+				json.Unmarshal(w.Body.Bytes(), &job)
+			}
+			So(job.State, ShouldEqual, jobs.StateQueued)
+			So(len(started), ShouldEqual, 0)
+			close(release)
+			close(messages)
+		})
+	})
+}
+
 func newMocklistener(consumer *mocks.Consumer, topic string) mockListener {
 	partitionConsumer, _ := consumer.ConsumePartition(topic, 0, 0)
 	return mockListener{
 		messages: partitionConsumer.Messages(),
+		marked:   make(chan *sarama.ConsumerMessage, 10),
 	}
 }
 
 type mockListener struct {
 	message.Listener
 	messages <-chan *sarama.ConsumerMessage
+	marked   chan *sarama.ConsumerMessage
 }
 
 func (listener mockListener) Messages() <-chan *sarama.ConsumerMessage {
 	return listener.messages
 }
+
+func (listener mockListener) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	listener.marked <- msg
+}
+
+type mockProducer struct {
+	messages chan *sarama.ProducerMessage
+}
+
+func newMockProducer() mockProducer {
+	return mockProducer{messages: make(chan *sarama.ProducerMessage, 10)}
+}
+
+func (producer mockProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	producer.messages <- msg
+	return 0, 0, nil
+}
+
+// flakyProducer fails to send the given number of messages (or every message, if negative), then sends them.
+type flakyProducer struct {
+	mutex    sync.Mutex
+	failures int
+	attempts chan bool
+}
+
+func (producer *flakyProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+	producer.attempts <- true
+	if producer.failures != 0 {
+		producer.failures--
+		return 0, 0, errors.New("kafka: client has run out of available brokers")
+	}
+	return 0, 0, nil
+}
@@ -0,0 +1,61 @@
+package message
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// offsetTracker keeps track of the messages that are being processed, so that the offset of a message is only marked
+// once it, and every earlier message on the same partition, has been processed.
+type offsetTracker struct {
+	listener Listener
+	mutex    sync.Mutex
+	pending  map[topicPartition][]*sarama.ConsumerMessage
+	done     map[*sarama.ConsumerMessage]bool
+}
+
+func newOffsetTracker(listener Listener) *offsetTracker {
+	return &offsetTracker{
+		listener: listener,
+		pending:  make(map[topicPartition][]*sarama.ConsumerMessage),
+		done:     make(map[*sarama.ConsumerMessage]bool),
+	}
+}
+
+// add records that the message has been received. Messages must be added in the order they are consumed.
+func (t *offsetTracker) add(message *sarama.ConsumerMessage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tp := topicPartition{message.Topic, message.Partition}
+	t.pending[tp] = append(t.pending[tp], message)
+}
+
+// complete records that the message has been processed, and marks the offset of the latest message on the partition
+// for which all earlier messages have also been processed.
+func (t *offsetTracker) complete(message *sarama.ConsumerMessage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.done[message] = true
+
+	tp := topicPartition{message.Topic, message.Partition}
+	pending := t.pending[tp]
+	var latest *sarama.ConsumerMessage
+	for len(pending) > 0 && t.done[pending[0]] {
+		latest = pending[0]
+		delete(t.done, latest)
+		pending = pending[1:]
+	}
+	t.pending[tp] = pending
+
+	if latest != nil {
+		t.listener.MarkOffset(latest, "")
+	}
+}
@@ -0,0 +1,55 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOffsetTracker(t *testing.T) {
+
+	Convey("Given three messages on a partition and one on another partition", t, func() {
+		listener := newRecordingListener()
+		tracker := newOffsetTracker(listener)
+		first := &sarama.ConsumerMessage{Topic: "t", Partition: 0, Offset: 10}
+		second := &sarama.ConsumerMessage{Topic: "t", Partition: 0, Offset: 11}
+		third := &sarama.ConsumerMessage{Topic: "t", Partition: 0, Offset: 12}
+		other := &sarama.ConsumerMessage{Topic: "t", Partition: 1, Offset: 20}
+		for _, message := range []*sarama.ConsumerMessage{first, second, third, other} {
+			tracker.add(message)
+		}
+
+		Convey("When a later message completes before an earlier one", func() {
+			tracker.complete(third)
+
+			Convey("Then no offset is marked", func() {
+				So(len(listener.marked), ShouldEqual, 0)
+			})
+
+			Convey("And once the earlier messages complete the latest offset is marked", func() {
+				tracker.complete(second)
+				So(len(listener.marked), ShouldEqual, 0)
+				tracker.complete(first)
+				So(listener.marked, ShouldResemble, []int64{12})
+			})
+		})
+
+		Convey("When messages complete in order", func() {
+			tracker.complete(first)
+			tracker.complete(second)
+
+			Convey("Then each offset is marked", func() {
+				So(listener.marked, ShouldResemble, []int64{10, 11})
+			})
+		})
+
+		Convey("When a message on another partition completes", func() {
+			tracker.complete(other)
+
+			Convey("Then its offset is marked regardless of the first partition", func() {
+				So(listener.marked, ShouldResemble, []int64{20})
+			})
+		})
+	})
+}
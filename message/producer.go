@@ -0,0 +1,76 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/Shopify/sarama"
+)
+
+// Producer defines the interface used to send messages to Kafka, implemented by sarama.SyncProducer.
+type Producer interface {
+	SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
+}
+
+// retryingProducer retries each message, with the backoff of the retry configuration, until it is sent or the context
+// is done. A message whose dead letter or result is not sent leaves its offset unmarked, which stops the offsets of the
+// later messages on its partition from being committed, so the send is retried rather than moving on.
+type retryingProducer struct {
+	Producer
+	ctx    context.Context
+	policy retry.Policy
+}
+
+func newRetryingProducer(ctx context.Context, producer Producer) retryingProducer {
+	policy := retry.NewPolicy()
+	policy.MaxAttempts = math.MaxInt32
+	return retryingProducer{Producer: producer, ctx: ctx, policy: policy}
+}
+
+// SendMessage sends the message, retrying until it is sent. Once the context is done a single attempt is made.
+func (p retryingProducer) SendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	var key string
+	if msg.Key != nil {
+		bytes, _ := msg.Key.Encode()
+		key = string(bytes)
+	}
+	_, err = p.policy.Do(p.ctx, key, func(attempt int) error {
+		var sendErr error
+		partition, offset, sendErr = p.Producer.SendMessage(msg)
+		return retry.NewRetryableError(sendErr)
+	})
+	return partition, offset, err
+}
+
+// publishResult sends the TransformResult to the transform complete topic, or to the transform failed topic if the
+// transform was not successful.
+func publishResult(producer Producer, result event.TransformResult) error {
+	topic := config.KafkaTransformCompleteTopic
+	if result.Failed() {
+		topic = config.KafkaTransformFailedTopic
+	}
+
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		log.ErrorC(result.RequestID, err, log.Data{"message": "Failed to marshal transform result"})
+		return err
+	}
+
+	partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(result.RequestID),
+		Value: sarama.ByteEncoder(bytes),
+	})
+	if err != nil {
+		log.ErrorC(result.RequestID, err, log.Data{"message": "Failed to publish transform result", "topic": topic})
+		return err
+	}
+
+	log.DebugC(result.RequestID, "Published transform result", log.Data{"topic": topic, "partition": partition, "offset": offset})
+	return nil
+}
@@ -0,0 +1,83 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingProducer struct {
+	sent []*sarama.ProducerMessage
+	err  error
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent)), p.err
+}
+
+func TestPublishResult(t *testing.T) {
+	request, _ := event.NewTransformRequest("s3://bucket/input.csv", "s3://bucket/output.csv", "foo")
+
+	Convey("Given a successful transform result", t, func() {
+		producer := &recordingProducer{}
+		result := event.NewTransformResult(request, &transformer.Stats{RowsRead: 12, RowsWritten: 12}, 3*time.Second, []retry.Attempt{{Attempt: 1, DurationNs: 5}}, "", nil)
+
+		err := publishResult(producer, result)
+
+		Convey("Then it is sent to the transform complete topic, keyed by request id", func() {
+			So(err, ShouldBeNil)
+			So(len(producer.sent), ShouldEqual, 1)
+			So(producer.sent[0].Topic, ShouldEqual, config.KafkaTransformCompleteTopic)
+			So(producer.sent[0].Key, ShouldEqual, sarama.StringEncoder("foo"))
+		})
+
+		Convey("And the message contains the result", func() {
+			bytes, _ := producer.sent[0].Value.Encode()
+			var sent event.TransformResult
+			So(json.Unmarshal(bytes, &sent), ShouldBeNil)
+			So(sent, ShouldResemble, result)
+			So(sent.RowCount, ShouldEqual, 12)
+			So(sent.Stats.RowsRead, ShouldEqual, 12)
+			So(sent.DurationNs, ShouldEqual, (3 * time.Second).Nanoseconds())
+			So(sent.Attempts, ShouldResemble, []retry.Attempt{{Attempt: 1, DurationNs: 5}})
+			So(sent.Failed(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a failed transform result", t, func() {
+		producer := &recordingProducer{}
+		result := event.NewTransformResult(request, nil, time.Second, nil, event.StageUpload, errors.New("THIS IS AN AWS ERROR"))
+
+		err := publishResult(producer, result)
+
+		Convey("Then it is sent to the transform failed topic with the stage and error", func() {
+			So(err, ShouldBeNil)
+			So(len(producer.sent), ShouldEqual, 1)
+			So(producer.sent[0].Topic, ShouldEqual, config.KafkaTransformFailedTopic)
+			bytes, _ := producer.sent[0].Value.Encode()
+			var sent event.TransformResult
+			json.Unmarshal(bytes, &sent)
+			So(sent.Stage, ShouldEqual, event.StageUpload)
+			So(sent.Error, ShouldEqual, "THIS IS AN AWS ERROR")
+		})
+	})
+
+	Convey("Given the producer returns an error", t, func() {
+		producer := &recordingProducer{err: errors.New("Kafka error")}
+
+		err := publishResult(producer, event.NewTransformResult(request, nil, time.Second, nil, "", nil))
+
+		Convey("Then the error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
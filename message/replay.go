@@ -0,0 +1,48 @@
+package message
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/ONSdigital/go-ns/log"
+	"github.com/Shopify/sarama"
+)
+
+// Replay sends the original payload of each DeadLetter received from the listener back to the transform request topic.
+// It stops once limit messages have been replayed (0 for no limit), or when no message has been received for the
+// idle timeout, returning the number of messages replayed. The offset of a dead letter is marked once it has been
+// replayed, so each is only replayed once per consumer group.
+func Replay(listener Listener, producer Producer, limit int, idleTimeout time.Duration) (int, error) {
+	replayed := 0
+	for limit == 0 || replayed < limit {
+		select {
+		case message, ok := <-listener.Messages():
+			if !ok {
+				return replayed, nil
+			}
+			var deadLetter event.DeadLetter
+			if err := json.Unmarshal(message.Value, &deadLetter); err != nil {
+				log.Error(err, log.Data{"message": "Skipping invalid dead letter", "offset": message.Offset})
+				listener.MarkOffset(message, "")
+				continue
+			}
+			_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+				Topic: config.KafkaConsumerTopic,
+				Key:   sarama.StringEncoder(deadLetter.RequestID),
+				Value: sarama.ByteEncoder(deadLetter.Payload),
+			})
+			if err != nil {
+				log.ErrorC(deadLetter.RequestID, err, log.Data{"message": "Failed to replay dead letter", "offset": message.Offset})
+				return replayed, err
+			}
+			listener.MarkOffset(message, "")
+			replayed++
+			log.DebugC(deadLetter.RequestID, "Replayed dead letter", log.Data{"stage": deadLetter.Stage, "reason": deadLetter.Reason, "topic": config.KafkaConsumerTopic})
+		case <-time.After(idleTimeout):
+			return replayed, nil
+		}
+	}
+	return replayed, nil
+}
@@ -0,0 +1,102 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/message/event"
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingListener struct {
+	messages chan *sarama.ConsumerMessage
+	marked   []int64
+}
+
+func newRecordingListener(values ...[]byte) *recordingListener {
+	listener := &recordingListener{messages: make(chan *sarama.ConsumerMessage, len(values))}
+	for i, value := range values {
+		listener.messages <- &sarama.ConsumerMessage{Value: value, Offset: int64(i)}
+	}
+	return listener
+}
+
+func (l *recordingListener) Messages() <-chan *sarama.ConsumerMessage {
+	return l.messages
+}
+
+func (l *recordingListener) MarkOffset(msg *sarama.ConsumerMessage, metadata string) {
+	l.marked = append(l.marked, msg.Offset)
+}
+
+func deadLetterJson(payload string, requestID string) []byte {
+	bytes, _ := json.Marshal(event.DeadLetter{Payload: []byte(payload), RequestID: requestID, Stage: event.StageDownload})
+	return bytes
+}
+
+func TestReplay(t *testing.T) {
+
+	Convey("Given two dead letters", t, func() {
+		listener := newRecordingListener(deadLetterJson(`{"requestId": "1"}`, "1"), deadLetterJson(`{"requestId": "2"}`, "2"))
+		producer := &recordingProducer{}
+
+		replayed, err := Replay(listener, producer, 0, 50*time.Millisecond)
+
+		Convey("Then the original payloads are sent to the transform request topic", func() {
+			So(err, ShouldBeNil)
+			So(replayed, ShouldEqual, 2)
+			So(len(producer.sent), ShouldEqual, 2)
+			So(producer.sent[0].Topic, ShouldEqual, config.KafkaConsumerTopic)
+			So(producer.sent[0].Value, ShouldResemble, sarama.ByteEncoder(`{"requestId": "1"}`))
+			So(producer.sent[1].Value, ShouldResemble, sarama.ByteEncoder(`{"requestId": "2"}`))
+		})
+
+		Convey("And both offsets are marked", func() {
+			So(listener.marked, ShouldResemble, []int64{0, 1})
+		})
+	})
+
+	Convey("Given a limit", t, func() {
+		listener := newRecordingListener(deadLetterJson("1", "1"), deadLetterJson("2", "2"))
+		producer := &recordingProducer{}
+
+		replayed, err := Replay(listener, producer, 1, 50*time.Millisecond)
+
+		Convey("Then only that many dead letters are replayed", func() {
+			So(err, ShouldBeNil)
+			So(replayed, ShouldEqual, 1)
+			So(listener.marked, ShouldResemble, []int64{0})
+		})
+	})
+
+	Convey("Given an invalid dead letter", t, func() {
+		listener := newRecordingListener([]byte("not json"), deadLetterJson("2", "2"))
+		producer := &recordingProducer{}
+
+		replayed, err := Replay(listener, producer, 0, 50*time.Millisecond)
+
+		Convey("Then it is skipped", func() {
+			So(err, ShouldBeNil)
+			So(replayed, ShouldEqual, 1)
+			So(len(producer.sent), ShouldEqual, 1)
+			So(listener.marked, ShouldResemble, []int64{0, 1})
+		})
+	})
+
+	Convey("Given the producer fails", t, func() {
+		listener := newRecordingListener(deadLetterJson("1", "1"))
+		producer := &recordingProducer{err: errors.New("Kafka error")}
+
+		replayed, err := Replay(listener, producer, 0, 50*time.Millisecond)
+
+		Convey("Then the error is returned and the offset is not marked", func() {
+			So(err, ShouldNotBeNil)
+			So(replayed, ShouldEqual, 0)
+			So(len(listener.marked), ShouldEqual, 0)
+		})
+	})
+}
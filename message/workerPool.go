@@ -0,0 +1,60 @@
+package message
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// workerPool processes messages using a fixed number of goroutines. Dispatching a message blocks while every worker
+// is busy, so the consumer stops fetching until a worker is free. If partition order is preserved, all messages from a
+// partition are processed by the same worker, in the order they were received.
+type workerPool struct {
+	queues                 []chan *sarama.ConsumerMessage
+	preservePartitionOrder bool
+	wg                     sync.WaitGroup
+}
+
+func newWorkerPool(workers int, preservePartitionOrder bool, process func(*sarama.ConsumerMessage)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	pool := &workerPool{preservePartitionOrder: preservePartitionOrder}
+
+	queueCount := 1
+	if preservePartitionOrder {
+		queueCount = workers
+	}
+	for i := 0; i < queueCount; i++ {
+		pool.queues = append(pool.queues, make(chan *sarama.ConsumerMessage))
+	}
+
+	for i := 0; i < workers; i++ {
+		queue := pool.queues[i%queueCount]
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for message := range queue {
+				process(message)
+			}
+		}()
+	}
+	return pool
+}
+
+// dispatch passes the message to a worker, blocking until one is available.
+func (pool *workerPool) dispatch(message *sarama.ConsumerMessage) {
+	queue := pool.queues[0]
+	if pool.preservePartitionOrder {
+		queue = pool.queues[int(message.Partition)%len(pool.queues)]
+	}
+	queue <- message
+}
+
+// close waits for all dispatched messages to be processed, then stops the workers.
+func (pool *workerPool) close() {
+	for _, queue := range pool.queues {
+		close(queue)
+	}
+	pool.wg.Wait()
+}
@@ -0,0 +1,79 @@
+package message
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWorkerPool(t *testing.T) {
+
+	Convey("Given a pool of three workers", t, func() {
+		release := make(chan bool)
+		started := make(chan int64, 10)
+		pool := newWorkerPool(3, false, func(message *sarama.ConsumerMessage) {
+			started <- message.Offset
+			<-release
+		})
+
+		Convey("Then three messages are processed concurrently", func() {
+			for i := 0; i < 3; i++ {
+				pool.dispatch(&sarama.ConsumerMessage{Offset: int64(i)})
+			}
+			for i := 0; i < 3; i++ {
+				select {
+				case <-started:
+				case <-time.After(time.Second):
+					So("a message was not processed concurrently", ShouldBeEmpty)
+				}
+			}
+
+			Convey("And dispatching a fourth blocks until a worker is free", func() {
+				dispatched := make(chan bool)
+				go func() {
+					pool.dispatch(&sarama.ConsumerMessage{Offset: 3})
+					close(dispatched)
+				}()
+				select {
+				case <-dispatched:
+					So("dispatch did not block", ShouldBeEmpty)
+				case <-time.After(100 * time.Millisecond):
+				}
+				close(release)
+				<-dispatched
+				pool.close()
+				So(len(started), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a pool of workers that preserves partition order", t, func() {
+		var mutex sync.Mutex
+		processed := make(map[int32][]int64)
+		pool := newWorkerPool(2, true, func(message *sarama.ConsumerMessage) {
+			if message.Offset == 0 {
+				// make the first message on each partition the slowest
+				time.Sleep(20 * time.Millisecond)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			processed[message.Partition] = append(processed[message.Partition], message.Offset)
+		})
+
+		for offset := int64(0); offset < 5; offset++ {
+			for partition := int32(0); partition < 4; partition++ {
+				pool.dispatch(&sarama.ConsumerMessage{Partition: partition, Offset: offset})
+			}
+		}
+		pool.close()
+
+		Convey("Then the messages on each partition are processed in order", func() {
+			for partition := int32(0); partition < 4; partition++ {
+				So(processed[partition], ShouldResemble, []int64{0, 1, 2, 3, 4})
+			}
+		})
+	})
+}
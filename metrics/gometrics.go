@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bufio"
+	"regexp"
+	"sort"
+	"strings"
+
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// goMetricLabel matches the names of go-metrics, as used by sarama, that are for a broker or topic.
+var goMetricLabel = regexp.MustCompile(`^(.+)-for-(broker|topic)-(.+)$`)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// summaryQuantiles the quantiles written for go-metrics histograms and timers.
+var summaryQuantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// RegisterGoMetrics registers the metrics of a go-metrics registry in the DefaultRegistry (see Registry.RegisterGoMetrics).
+func RegisterGoMetrics(prefix string, source gometrics.Registry) {
+	DefaultRegistry.RegisterGoMetrics(prefix, source)
+}
+
+// RegisterGoMetrics registers the metrics of a go-metrics registry, e.g. the MetricRegistry of the sarama Kafka
+// clients, with names prefixed by the given prefix. The metrics are read when they are collected, so metrics added to
+// the source later are included. A metric whose name ends with -for-broker-<id> or -for-topic-<topic> is written with a
+// broker or topic label. Meters are written as counters of their count, and histograms and timers as summaries.
+func (r *Registry) RegisterGoMetrics(prefix string, source gometrics.Registry) {
+	r.register(prefix, &goMetricsCollector{prefix: prefix, source: source})
+}
+
+type goMetricsCollector struct {
+	prefix string
+	source gometrics.Registry
+}
+
+// goMetric a go-metrics metric, with the label parsed from its name.
+type goMetric struct {
+	labels      []string
+	labelValues []string
+	metric      interface{}
+}
+
+func (c *goMetricsCollector) collect(w *bufio.Writer) {
+	var names []string
+	byName := make(map[string]interface{})
+	c.source.Each(func(name string, metric interface{}) {
+		names = append(names, name)
+		byName[name] = metric
+	})
+	sort.Strings(names)
+
+	// group the metrics by name, without the label
+	var familyNames []string
+	families := make(map[string][]goMetric)
+	for _, name := range names {
+		m := goMetric{metric: byName[name]}
+		if match := goMetricLabel.FindStringSubmatch(name); match != nil {
+			name, m.labels, m.labelValues = match[1], []string{match[2]}, []string{match[3]}
+		}
+		if _, ok := families[name]; !ok {
+			familyNames = append(familyNames, name)
+		}
+		families[name] = append(families[name], m)
+	}
+	sort.Strings(familyNames)
+	for _, name := range familyNames {
+		c.collectFamily(w, name, families[name])
+	}
+}
+
+// collectFamily writes the metrics with the same name, which are assumed to have the same type.
+func (c *goMetricsCollector) collectFamily(w *bufio.Writer, name string, metrics []goMetric) {
+	fullName := c.prefix + "_" + strings.ToLower(invalidNameChars.ReplaceAllString(name, "_"))
+	help := "The " + name + " go-metric"
+	switch metrics[0].metric.(type) {
+	case gometrics.Meter:
+		fullName = strings.TrimSuffix(fullName, "_rate") + "_total"
+		writeHeader(w, fullName, help+" count", typeCounter)
+	case gometrics.Histogram, gometrics.Timer:
+		writeHeader(w, fullName, help, typeSummary)
+	default:
+		writeHeader(w, fullName, help, typeGauge)
+	}
+	for _, m := range metrics {
+		switch metric := m.metric.(type) {
+		case gometrics.Meter:
+			writeSample(w, fullName, m.labels, m.labelValues, float64(metric.Count()))
+		case gometrics.Counter:
+			writeSample(w, fullName, m.labels, m.labelValues, float64(metric.Count()))
+		case gometrics.Gauge:
+			writeSample(w, fullName, m.labels, m.labelValues, float64(metric.Value()))
+		case gometrics.GaugeFloat64:
+			writeSample(w, fullName, m.labels, m.labelValues, metric.Value())
+		case gometrics.Histogram:
+			snapshot := metric.Snapshot()
+			writeSummary(w, fullName, m, snapshot.Percentiles(summaryQuantiles), float64(snapshot.Sum()), snapshot.Count())
+		case gometrics.Timer:
+			snapshot := metric.Snapshot()
+			writeSummary(w, fullName, m, snapshot.Percentiles(summaryQuantiles), float64(snapshot.Sum()), snapshot.Count())
+		}
+	}
+}
+
+func writeSummary(w *bufio.Writer, name string, m goMetric, quantiles []float64, sum float64, count int64) {
+	labels := append(append([]string(nil), m.labels...), "quantile")
+	for i, q := range summaryQuantiles {
+		writeSample(w, name, labels, append(append([]string(nil), m.labelValues...), formatFloat(q)), quantiles[i])
+	}
+	writeSample(w, name+"_sum", m.labels, m.labelValues, sum)
+	writeSample(w, name+"_count", m.labels, m.labelValues, float64(count))
+}
@@ -0,0 +1,321 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The types of metric, as written in the Prometheus text format.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+	typeSummary   = "summary"
+)
+
+// DefaultBuckets the upper bounds, in seconds, of the buckets of a Histogram timing a request.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// collector writes metrics in the Prometheus text format.
+type collector interface {
+	collect(w *bufio.Writer)
+}
+
+// Registry holds metrics, and writes them in the Prometheus text format (version 0.0.4) in the order registered.
+type Registry struct {
+	mutex      sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// DefaultRegistry the registry served by the /metrics endpoint, used by the package level functions.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry create a new, empty, Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a collector of the named metric, panicking if the name has already been registered.
+func (r *Registry) register(name string, c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.names[name] {
+		panic("Duplicate metric name: " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mutex.Unlock()
+
+	counting := &countingWriter{w: w}
+	bw := bufio.NewWriter(counting)
+	for _, c := range collectors {
+		c.collect(bw)
+	}
+	err := bw.Flush()
+	return counting.count, err
+}
+
+// ServeHTTP serves the /metrics endpoint.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
+
+// series the value of a metric with one set of label values. A histogram also counts the observations in each bucket.
+type series struct {
+	labelValues []string
+	value       float64
+	count       uint64
+	buckets     []uint64
+}
+
+// family a metric and its series, keyed by their label values.
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	buckets    []float64
+	mutex      sync.Mutex
+	series     map[string]*series
+}
+
+func newFamily(name string, help string, metricType string, labels []string, buckets []float64) *family {
+	f := &family{name: name, help: help, metricType: metricType, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	if len(labels) == 0 {
+		// a metric without labels is written (as zero) before it is first updated
+		f.get()
+	}
+	return f
+}
+
+// get returns the series with the label values, creating it if necessary. Must be called with the mutex held (or
+// before the family is shared).
+func (f *family) get(labelValues ...string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("Metric %s has labels %v, but was given values %v", f.name, f.labels, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues}
+		if f.metricType == typeHistogram {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) update(labelValues []string, update func(s *series)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	update(f.get(labelValues...))
+}
+
+func (f *family) collect(w *bufio.Writer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	writeHeader(w, f.name, f.help, f.metricType)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.metricType != typeHistogram {
+			writeSample(w, f.name, f.labels, s.labelValues, s.value)
+			continue
+		}
+		labels := append(append([]string(nil), f.labels...), "le")
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.buckets[i]
+			writeSample(w, f.name+"_bucket", labels, append(append([]string(nil), s.labelValues...), formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(w, f.name+"_bucket", labels, append(append([]string(nil), s.labelValues...), "+Inf"), float64(s.count))
+		writeSample(w, f.name+"_sum", f.labels, s.labelValues, s.value)
+		writeSample(w, f.name+"_count", f.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// Counter a metric that only increases, e.g. the number of requests.
+type Counter struct {
+	f *family
+}
+
+// NewCounter creates and registers a Counter in the DefaultRegistry.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewCounter creates and registers a Counter, with the given label names.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(name, help, typeCounter, labels, nil)}
+	r.register(name, c.f)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a (non-negative) value to the counter with the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("Counter %s cannot be decreased by %v", c.f.name, v))
+	}
+	c.f.update(labelValues, func(s *series) { s.value += v })
+}
+
+// Gauge a metric that can go up and down, e.g. the number of requests in progress.
+type Gauge struct {
+	f *family
+}
+
+// NewGauge creates and registers a Gauge in the DefaultRegistry.
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labels...)
+}
+
+// NewGauge creates and registers a Gauge, with the given label names.
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, typeGauge, labels, nil)}
+	r.register(name, g.f)
+	return g
+}
+
+// Set sets the gauge with the given label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value = v })
+}
+
+// Add adds a value, which may be negative, to the gauge with the given label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value += v })
+}
+
+// Inc adds one to the gauge with the given label values.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the gauge with the given label values.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram a metric counting observations, e.g. request durations, in buckets.
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram creates and registers a Histogram in the DefaultRegistry.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram creates and registers a Histogram with the given (increasing) bucket upper bounds and label names.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{newFamily(name, help, typeHistogram, labels, buckets)}
+	r.register(name, h.f)
+	return h
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.update(labelValues, func(s *series) {
+		s.value += v
+		s.count++
+		if i := sort.SearchFloat64s(h.f.buckets, v); i < len(h.f.buckets) {
+			s.buckets[i]++
+		}
+	})
+}
+
+// funcCollector a metric without labels whose value is read when it is collected.
+type funcCollector struct {
+	name       string
+	help       string
+	metricType string
+	value      func() float64
+}
+
+func (c *funcCollector) collect(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, c.metricType)
+	writeSample(w, c.name, nil, nil, c.value())
+}
+
+// NewCounterFunc registers a counter in the DefaultRegistry whose value is read from a function, e.g. a count kept
+// elsewhere.
+func NewCounterFunc(name string, help string, value func() float64) {
+	DefaultRegistry.register(name, &funcCollector{name, help, typeCounter, value})
+}
+
+// NewGaugeFunc registers a gauge in the DefaultRegistry whose value is read from a function.
+func NewGaugeFunc(name string, help string, value func() float64) {
+	DefaultRegistry.register(name, &funcCollector{name, help, typeGauge, value})
+}
+
+func writeHeader(w *bufio.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeSample(w *bufio.Writer, name string, labels []string, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelValueEscaper.Replace(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
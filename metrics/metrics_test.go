@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeString(r *Registry) string {
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	return buf.String()
+}
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Given a counter and a gauge", t, func() {
+		r := NewRegistry()
+		requests := r.NewCounter("requests_total", "The number of requests.", "outcome")
+		inFlight := r.NewGauge("in_flight", "The number of requests in progress.")
+
+		Convey("Then a metric without labels is written before it is updated", func() {
+			So(writeString(r), ShouldEqual, "# HELP requests_total The number of requests.\n"+
+				"# TYPE requests_total counter\n"+
+				"# HELP in_flight The number of requests in progress.\n"+
+				"# TYPE in_flight gauge\n"+
+				"in_flight 0\n")
+		})
+
+		Convey("Then each set of label values is written in order", func() {
+			requests.Inc("success")
+			requests.Add(2, "failure")
+			requests.Inc("success")
+			inFlight.Inc()
+			inFlight.Inc()
+			inFlight.Dec()
+			So(writeString(r), ShouldEqual, "# HELP requests_total The number of requests.\n"+
+				"# TYPE requests_total counter\n"+
+				"requests_total{outcome=\"failure\"} 2\n"+
+				"requests_total{outcome=\"success\"} 2\n"+
+				"# HELP in_flight The number of requests in progress.\n"+
+				"# TYPE in_flight gauge\n"+
+				"in_flight 1\n")
+		})
+
+		Convey("Then label values are escaped", func() {
+			requests.Inc("a \"quoted\\\" value\n")
+			So(writeString(r), ShouldContainSubstring, `requests_total{outcome="a \"quoted\\\" value\n"} 1`)
+		})
+
+		Convey("Then the wrong number of label values, or a duplicate name, panics", func() {
+			So(func() { requests.Inc() }, ShouldPanic)
+			So(func() { requests.Add(-1, "success") }, ShouldPanic)
+			So(func() { r.NewGauge("requests_total", "") }, ShouldPanic)
+		})
+	})
+
+	Convey("Given a histogram", t, func() {
+		r := NewRegistry()
+		duration := r.NewHistogram("duration_seconds", "The duration.", []float64{0.1, 1}, "stage")
+		duration.Observe(0.05, "upload")
+		duration.Observe(0.1, "upload")
+		duration.Observe(0.5, "upload")
+		duration.Observe(2, "upload")
+
+		Convey("Then the buckets are cumulative", func() {
+			So(writeString(r), ShouldEqual, "# HELP duration_seconds The duration.\n"+
+				"# TYPE duration_seconds histogram\n"+
+				"duration_seconds_bucket{stage=\"upload\",le=\"0.1\"} 2\n"+
+				"duration_seconds_bucket{stage=\"upload\",le=\"1\"} 3\n"+
+				"duration_seconds_bucket{stage=\"upload\",le=\"+Inf\"} 4\n"+
+				"duration_seconds_sum{stage=\"upload\"} 2.65\n"+
+				"duration_seconds_count{stage=\"upload\"} 4\n")
+		})
+	})
+
+	Convey("Given a go-metrics registry", t, func() {
+		r := NewRegistry()
+		source := gometrics.NewRegistry()
+		r.RegisterGoMetrics("kafka", source)
+		gometrics.GetOrRegisterMeter("request-rate", source).Mark(3)
+		gometrics.GetOrRegisterMeter("request-rate-for-broker-2", source).Mark(1)
+		gometrics.GetOrRegisterMeter("request-rate-for-broker-1", source).Mark(2)
+		gometrics.GetOrRegisterGauge("connections", source).Update(4)
+		gometrics.GetOrRegisterHistogram("request-size", source, gometrics.NewUniformSample(10)).Update(100)
+
+		Convey("Then the metrics are written with broker labels", func() {
+			So(writeString(r), ShouldEqual, "# HELP kafka_connections The connections go-metric\n"+
+				"# TYPE kafka_connections gauge\n"+
+				"kafka_connections 4\n"+
+				"# HELP kafka_request_total The request-rate go-metric count\n"+
+				"# TYPE kafka_request_total counter\n"+
+				"kafka_request_total 3\n"+
+				"kafka_request_total{broker=\"1\"} 2\n"+
+				"kafka_request_total{broker=\"2\"} 1\n"+
+				"# HELP kafka_request_size The request-size go-metric\n"+
+				"# TYPE kafka_request_size summary\n"+
+				"kafka_request_size{quantile=\"0.5\"} 100\n"+
+				"kafka_request_size{quantile=\"0.75\"} 100\n"+
+				"kafka_request_size{quantile=\"0.95\"} 100\n"+
+				"kafka_request_size{quantile=\"0.99\"} 100\n"+
+				"kafka_request_size_sum 100\n"+
+				"kafka_request_size_count 1\n")
+		})
+	})
+
+	Convey("Given the metrics endpoint", t, func() {
+		r := NewRegistry()
+		r.NewCounter("requests_total", "The number of requests.").Inc()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+		Convey("Then the metrics are returned in the Prometheus text format", func() {
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/plain; version=0.0.4")
+			So(w.Body.String(), ShouldEndWith, "requests_total 1\n")
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package ons_aws
 
 import (
+	"context"
 	"io"
 
 	"compress/gzip"
@@ -8,6 +9,7 @@ import (
 	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/go-ns/log"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -16,13 +18,44 @@ import (
 
 const CONTENT_ENCODING_GZIP = "gzip"
 
-// AWSClient interface defining the AWS client.
+// AWSClient interface defining the AWS client. Errors that may succeed if retried implement retry.Retryable. Requests,
+// including reading the body of a file, are abandoned once the context is done.
 type AWSService interface {
 	// GetFile get the requested file from AWS. The client is responsible for closing the reader.
-	GetCSV(requestID string, s3url S3URL) (io.ReadCloser, error)
-	SaveFile(requestID string, reader io.Reader, s3url S3URL) error
+	GetCSV(ctx context.Context, requestID string, s3url S3URL) (io.ReadCloser, error)
+	SaveFile(ctx context.Context, requestID string, reader io.Reader, s3url S3URL) error
 }
 
+// newAWSConfig creates the config for the AWS sdk, using the S3Endpoint if one is configured.
+func newAWSConfig() *aws.Config {
+	awsConfig := &aws.Config{Region: aws.String(config.AWSRegion)}
+	if len(config.S3Endpoint) > 0 {
+		awsConfig.Endpoint = aws.String(config.S3Endpoint)
+	}
+	if config.S3ForcePathStyle {
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	return awsConfig
+}
+
+// newSession creates a session for the AWS sdk whose requests are sent with the context, so they are abandoned once it
+// is done. The vendored sdk predates its own context support, so the context is set on each http request as it is sent
+// (including retries). Aborting a multipart upload is not abandoned, so that its parts are not left behind.
+func newSession(ctx context.Context) (*session.Session, error) {
+	s, err := session.NewSession(newAWSConfig())
+	if err != nil {
+		return nil, err
+	}
+	s.Handlers.Send.PushFront(func(r *request.Request) {
+		if r.Operation.Name != abortMultipartUploadOperation {
+			r.HTTPRequest = r.HTTPRequest.WithContext(ctx)
+		}
+	})
+	return s, nil
+}
+
+const abortMultipartUploadOperation = "AbortMultipartUpload"
+
 // Client AWS client implementation.
 type Service struct{}
 
@@ -31,7 +64,7 @@ func NewService() AWSService {
 	return &Service{}
 }
 
-func (cli *Service) SaveFile(requestID string, reader io.Reader, s3url S3URL) error {
+func (cli *Service) SaveFile(ctx context.Context, requestID string, reader io.Reader, s3url S3URL) error {
 
 	startTime := time.Now()
 	defer func() {
@@ -39,7 +72,12 @@ func (cli *Service) SaveFile(requestID string, reader io.Reader, s3url S3URL) er
 		log.DebugC(requestID, fmt.Sprintf("SaveFile, duration_ns: %d", endTime.Sub(startTime).Nanoseconds()), log.Data{})
 	}()
 
-	uploader := s3manager.NewUploader(session.New(&aws.Config{Region: aws.String(config.AWSRegion)}))
+	session, err := newSession(ctx)
+	if err != nil {
+		log.ErrorC(requestID, err, nil)
+		return classifyError(err)
+	}
+	uploader := s3manager.NewUploader(session)
 
 	var contentEncoding *string = nil
 	var uploadInput io.Reader = reader
@@ -59,6 +97,8 @@ func (cli *Service) SaveFile(requestID string, reader io.Reader, s3url S3URL) er
 				log.DebugC(requestID, fmt.Sprintf("Copied %d bytes via gzip", bytesWritten), nil)
 			}
 		}()
+		// if the upload ends before reading all of the compressed output, don't leave the compression blocked
+		defer pipeReader.Close()
 		uploadInput = pipeReader
 		// The Go AWS SDK takes a *string for headers, and Go won't let you take a pointer to a string literal/constant
 		contentEncoding = new(string)
@@ -74,7 +114,7 @@ func (cli *Service) SaveFile(requestID string, reader io.Reader, s3url S3URL) er
 
 	if err != nil {
 		log.Error(err, log.Data{"message": "Failed to upload"})
-		return err
+		return classifyError(err)
 	}
 
 	log.Debug("Upload successful", log.Data{
@@ -85,20 +125,18 @@ func (cli *Service) SaveFile(requestID string, reader io.Reader, s3url S3URL) er
 }
 
 // GetFile get the requested file from AWS. The client is responsible for closing the reader.
-func (cli *Service) GetCSV(requestID string, s3url S3URL) (io.ReadCloser, error) {
+func (cli *Service) GetCSV(ctx context.Context, requestID string, s3url S3URL) (io.ReadCloser, error) {
 	startTime := time.Now()
 	defer func() {
 		endTime := time.Now()
 		log.DebugC(requestID, fmt.Sprintf("GetCSV, duration_ns: %d", endTime.Sub(startTime).Nanoseconds()), log.Data{})
 	}()
 
-	session, err := session.NewSession(&aws.Config{
-		Region: aws.String(config.AWSRegion),
-	})
+	session, err := newSession(ctx)
 
 	if err != nil {
 		log.ErrorC(requestID, err, nil)
-		return nil, err
+		return nil, classifyError(err)
 	}
 
 	s3Service := s3.New(session)
@@ -114,8 +152,8 @@ func (cli *Service) GetCSV(requestID string, s3url S3URL) (io.ReadCloser, error)
 
 	if err != nil {
 		log.ErrorC(requestID, err, log.Data{"request": request})
-		return nil, err
+		return nil, classifyError(err)
 	}
 
-	return result.Body, nil
+	return classifyingReadCloser{result.Body}, nil
 }
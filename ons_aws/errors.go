@@ -0,0 +1,63 @@
+package ons_aws
+
+import (
+	"io"
+	"net"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// transientErrorCodes are the AWS error codes indicating that a request may succeed if retried.
+var transientErrorCodes = map[string]bool{
+	"RequestError":         true,
+	"RequestTimeout":       true,
+	"RequestTimeTooSkewed": true,
+	"InternalError":        true,
+	"ServiceUnavailable":   true,
+	"SlowDown":             true,
+	"Throttling":           true,
+	"ThrottlingException":  true,
+	"RequestLimitExceeded": true,
+}
+
+// classifyError marks throttling, server side and connection errors as retryable. All other errors, e.g. a missing
+// key or access denied, are returned unchanged and so are permanent.
+func classifyError(err error) error {
+	if isTransient(err) {
+		return retry.NewRetryableError(err)
+	}
+	return err
+}
+
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case awserr.RequestFailure:
+		if e.StatusCode() >= 500 || e.StatusCode() == 429 {
+			return true
+		}
+		return transientErrorCodes[e.Code()]
+	case awserr.Error:
+		if transientErrorCodes[e.Code()] {
+			return true
+		}
+		return e.OrigErr() != nil && isTransient(e.OrigErr())
+	case net.Error:
+		return true
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// classifyingReadCloser classifies the errors returned while reading an S3 object, so that a connection lost part way
+// through a download is retryable.
+type classifyingReadCloser struct {
+	io.ReadCloser
+}
+
+func (r classifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = classifyError(err)
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package ons_aws
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestClassifyError(t *testing.T) {
+
+	Convey("Throttling, server side and connection errors are retryable", t, func() {
+		So(retry.IsRetryable(classifyError(awserr.New("SlowDown", "Please reduce your request rate.", nil))), ShouldBeTrue)
+		So(retry.IsRetryable(classifyError(awserr.NewRequestFailure(awserr.New("InternalError", "", nil), 500, "id"))), ShouldBeTrue)
+		So(retry.IsRetryable(classifyError(awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 503, "id"))), ShouldBeTrue)
+		So(retry.IsRetryable(classifyError(awserr.New("RequestError", "send request failed", &net.OpError{Op: "dial", Err: errors.New("refused")}))), ShouldBeTrue)
+		So(retry.IsRetryable(classifyError(io.ErrUnexpectedEOF)), ShouldBeTrue)
+	})
+
+	Convey("Client errors are permanent", t, func() {
+		So(retry.IsRetryable(classifyError(awserr.NewRequestFailure(awserr.New("NoSuchKey", "", nil), 404, "id"))), ShouldBeFalse)
+		So(retry.IsRetryable(classifyError(awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), 403, "id"))), ShouldBeFalse)
+		So(retry.IsRetryable(classifyError(errors.New("Unknown error"))), ShouldBeFalse)
+	})
+
+	Convey("A connection lost while reading an object is retryable", t, func() {
+		reader := classifyingReadCloser{ioutil.NopCloser(failingReader{io.ErrUnexpectedEOF})}
+		_, err := reader.Read(make([]byte, 10))
+		So(retry.IsRetryable(err), ShouldBeTrue)
+	})
+
+	Convey("The end of an object is not an error", t, func() {
+		reader := classifyingReadCloser{ioutil.NopCloser(failingReader{io.EOF})}
+		_, err := reader.Read(make([]byte, 10))
+		So(err, ShouldEqual, io.EOF)
+	})
+}
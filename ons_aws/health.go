@@ -0,0 +1,24 @@
+package ons_aws
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/health"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// NewBucketChecker creates a health.Checker that checks the S3 bucket exists and can be accessed.
+func NewBucketChecker(bucket string, timeout time.Duration) health.Checker {
+	return func() error {
+		awsConfig := newAWSConfig().WithHTTPClient(&http.Client{Timeout: timeout}).WithMaxRetries(0)
+		session, err := session.NewSession(awsConfig)
+		if err != nil {
+			return err
+		}
+		_, err = s3.New(session).HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		return err
+	}
+}
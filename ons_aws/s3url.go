@@ -42,6 +42,9 @@ func parseUrl(s string) (*url.URL, error) {
 	if u, err = url.Parse(s); err != nil {
 		return nil, err
 	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("URL '%s' is not an s3 URL", s)
+	}
 	if (len(u.Host)) < 1 {
 		return nil, fmt.Errorf("URL '%s' does not contain a Bucket", s)
 	}
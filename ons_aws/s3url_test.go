@@ -113,3 +113,19 @@ func TestInvalidPath(t *testing.T) {
 
 	})
 }
+
+func TestInvalidScheme(t *testing.T) {
+
+	Convey("Given url that is not an s3 url", t, func() {
+
+		s3url, err := NewS3URL("https://host/file")
+
+		Convey("Then the s3url should be NilS3URL", func() {
+			So(s3url, ShouldResemble, NilS3URL)
+		})
+		Convey("and err should not be nil", func() {
+			So(err, ShouldNotEqual, nil)
+		})
+
+	})
+}
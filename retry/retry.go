@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// Retryable is implemented by errors that report whether the operation that caused them may succeed if retried.
+type Retryable interface {
+	Retryable() bool
+}
+
+type retryableError struct {
+	error
+}
+
+func (e retryableError) Retryable() bool {
+	return true
+}
+
+// NewRetryableError wraps the error to mark it as transient, i.e. the operation that caused it may succeed if retried.
+// A nil error is returned unchanged.
+func NewRetryableError(err error) error {
+	if err == nil || IsRetryable(err) {
+		return err
+	}
+	return retryableError{err}
+}
+
+// IsRetryable returns true if the error reports that it is transient. Errors that do not implement Retryable are
+// considered permanent.
+func IsRetryable(err error) bool {
+	r, ok := err.(Retryable)
+	return ok && r.Retryable()
+}
+
+// Attempt records the outcome of a single attempt of an operation.
+type Attempt struct {
+	Attempt    int    `json:"attempt"`
+	Error      string `json:"error,omitempty"`
+	Retryable  bool   `json:"retryable,omitempty"`
+	DurationNs int64  `json:"durationNs"`
+	BackoffNs  int64  `json:"backoffNs,omitempty"`
+}
+
+// Policy defines how many times an operation is attempted, and how long to wait between attempts. The wait before
+// attempt n+1 is InitialBackoff * Multiplier^(n-1), capped at MaxBackoff, then randomly adjusted by up to +/- Jitter
+// (a fraction of the backoff).
+type Policy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+}
+
+// NewPolicy creates a Policy using the retry configuration.
+func NewPolicy() Policy {
+	return Policy{
+		MaxAttempts:    config.RetryMaxAttempts,
+		InitialBackoff: config.RetryInitialBackoff,
+		MaxBackoff:     config.RetryMaxBackoff,
+		Multiplier:     config.RetryBackoffMultiplier,
+		Jitter:         config.RetryJitter,
+	}
+}
+
+// Backoff returns the time to wait after the given (1-based) attempt has failed.
+func (p Policy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff = backoff * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(backoff)
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable, or MaxAttempts have been made, returning the
+// history of attempts and the error from the last attempt. No further attempt is made once the context is done, and
+// the wait between attempts ends early, returning the context's error.
+func (p Policy) Do(ctx context.Context, requestID string, fn func(attempt int) error) ([]Attempt, error) {
+	var attempts []Attempt
+	for n := 1; ; n++ {
+		startTime := time.Now()
+		err := fn(n)
+		attempt := Attempt{Attempt: n, DurationNs: time.Since(startTime).Nanoseconds()}
+		if err == nil {
+			attempts = append(attempts, attempt)
+			return attempts, nil
+		}
+
+		attempt.Error = err.Error()
+		attempt.Retryable = IsRetryable(err)
+		if !attempt.Retryable || n >= p.MaxAttempts || ctx.Err() != nil {
+			attempts = append(attempts, attempt)
+			log.ErrorC(requestID, err, log.Data{"message": "Giving up", "attempt": n, "maxAttempts": p.MaxAttempts, "retryable": attempt.Retryable})
+			return attempts, err
+		}
+
+		backoff := p.Backoff(n)
+		attempt.BackoffNs = backoff.Nanoseconds()
+		attempts = append(attempts, attempt)
+		log.ErrorC(requestID, err, log.Data{"message": "Retrying after transient failure", "attempt": n, "maxAttempts": p.MaxAttempts, "backoff": backoff.String()})
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIsRetryable(t *testing.T) {
+
+	Convey("Given an error marked as retryable", t, func() {
+		err := NewRetryableError(errors.New("Throttled"))
+
+		Convey("Then it is retryable and keeps the original message", func() {
+			So(IsRetryable(err), ShouldBeTrue)
+			So(err.Error(), ShouldEqual, "Throttled")
+		})
+	})
+
+	Convey("Given a plain error", t, func() {
+		Convey("Then it is not retryable", func() {
+			So(IsRetryable(errors.New("Invalid csv")), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a nil error", t, func() {
+		Convey("Then marking it as retryable returns nil", func() {
+			So(NewRetryableError(nil), ShouldBeNil)
+		})
+	})
+}
+
+func TestBackoff(t *testing.T) {
+
+	Convey("Given a policy without jitter", t, func() {
+		policy := Policy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+		Convey("Then the backoff increases exponentially up to the maximum", func() {
+			So(policy.Backoff(1), ShouldEqual, time.Second)
+			So(policy.Backoff(2), ShouldEqual, 2*time.Second)
+			So(policy.Backoff(3), ShouldEqual, 4*time.Second)
+			So(policy.Backoff(4), ShouldEqual, 5*time.Second)
+		})
+	})
+
+	Convey("Given a policy with jitter", t, func() {
+		policy := Policy{MaxAttempts: 5, InitialBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+
+		Convey("Then the backoff is within the jitter range", func() {
+			for i := 0; i < 20; i++ {
+				So(policy.Backoff(2), ShouldBeBetweenOrEqual, time.Second, 3*time.Second)
+			}
+		})
+	})
+}
+
+func TestDo(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+
+	Convey("Given an operation that succeeds after a transient failure", t, func() {
+		calls := 0
+		attempts, err := policy.Do(context.Background(), "test", func(attempt int) error {
+			calls++
+			if attempt == 1 {
+				return NewRetryableError(errors.New("Throttled"))
+			}
+			return nil
+		})
+
+		Convey("Then it is retried and the attempt history recorded", func() {
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 2)
+			So(len(attempts), ShouldEqual, 2)
+			So(attempts[0].Attempt, ShouldEqual, 1)
+			So(attempts[0].Error, ShouldEqual, "Throttled")
+			So(attempts[0].Retryable, ShouldBeTrue)
+			So(attempts[0].BackoffNs, ShouldBeGreaterThan, 0)
+			So(attempts[1].Attempt, ShouldEqual, 2)
+			So(attempts[1].Error, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an operation that always fails with a transient error", t, func() {
+		calls := 0
+		attempts, err := policy.Do(context.Background(), "test", func(attempt int) error {
+			calls++
+			return NewRetryableError(errors.New("Throttled"))
+		})
+
+		Convey("Then it is attempted MaxAttempts times and the last error returned", func() {
+			So(err, ShouldNotBeNil)
+			So(calls, ShouldEqual, 3)
+			So(len(attempts), ShouldEqual, 3)
+			So(attempts[2].BackoffNs, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given an operation that fails with a permanent error", t, func() {
+		calls := 0
+		attempts, err := policy.Do(context.Background(), "test", func(attempt int) error {
+			calls++
+			return errors.New("Invalid csv")
+		})
+
+		Convey("Then it is not retried", func() {
+			So(err.Error(), ShouldEqual, "Invalid csv")
+			So(calls, ShouldEqual, 1)
+			So(len(attempts), ShouldEqual, 1)
+			So(attempts[0].Retryable, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given an operation that fails with a transient error once the context is cancelled", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		attempts, err := policy.Do(ctx, "test", func(attempt int) error {
+			calls++
+			cancel()
+			return NewRetryableError(errors.New("Throttled"))
+		})
+
+		Convey("Then it is not retried", func() {
+			So(err.Error(), ShouldEqual, "Throttled")
+			So(calls, ShouldEqual, 1)
+			So(len(attempts), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given the context is cancelled while waiting to retry", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		slowPolicy := Policy{MaxAttempts: 3, InitialBackoff: time.Hour}
+		time.AfterFunc(10*time.Millisecond, cancel)
+		attempts, err := slowPolicy.Do(ctx, "test", func(attempt int) error {
+			return NewRetryableError(errors.New("Throttled"))
+		})
+
+		Convey("Then the wait ends with the context's error", func() {
+			So(err, ShouldEqual, context.Canceled)
+			So(len(attempts), ShouldEqual, 1)
+		})
+	})
+}
@@ -10,15 +10,57 @@ CONFIG=$(aws --region $AWS_REGION ec2 describe-tags --filters "Name=resource-id,
 
 (aws s3 cp s3://$CONFIG_BUCKET/dp-dd-csv-transformer/$CONFIG.asc . && gpg --decrypt $CONFIG.asc > $CONFIG) || exit $?
 
-source $CONFIG && docker run -d                    \
-  --env=AWS_REGION=$AWS_REGION                     \
-  --env=BIND_ADDR=$BIND_ADDR                       \
-  --env=KAFKA_ADDR=$KAFKA_ADDR                     \
-  --env=KAFKA_CONSUMER_GROUP=$KAFKA_CONSUMER_GROUP \
-  --env=KAFKA_CONSUMER_TOPIC=$KAFKA_CONSUMER_TOPIC \
-  --env=HIERARCHY_ENDPOINT=$HIERARCHY_ENDPOINT     \
-  --env=USE_GZIP=$USE_GZIP                         \
-  --name=dp-dd-csv-transformer                     \
-  --net=$DOCKER_NETWORK                            \
-  --restart=always                                 \
+source $CONFIG && docker run -d                                              \
+  --env=AWS_REGION=$AWS_REGION                                               \
+  --env=S3_ENDPOINT=$S3_ENDPOINT                                             \
+  --env=S3_FORCE_PATH_STYLE=$S3_FORCE_PATH_STYLE                             \
+  --env=BIND_ADDR=$BIND_ADDR                                                 \
+  --env=KAFKA_ADDR=$KAFKA_ADDR                                               \
+  --env=KAFKA_CONSUMER_GROUP=$KAFKA_CONSUMER_GROUP                           \
+  --env=KAFKA_CONSUMER_TOPIC=$KAFKA_CONSUMER_TOPIC                           \
+  --env=KAFKA_COMMIT_INTERVAL=$KAFKA_COMMIT_INTERVAL                         \
+  --env=KAFKA_DEAD_LETTER_TOPIC=$KAFKA_DEAD_LETTER_TOPIC                     \
+  --env=KAFKA_TRANSFORM_COMPLETE_TOPIC=$KAFKA_TRANSFORM_COMPLETE_TOPIC       \
+  --env=KAFKA_TRANSFORM_FAILED_TOPIC=$KAFKA_TRANSFORM_FAILED_TOPIC           \
+  --env=HIERARCHY_ENDPOINT=$HIERARCHY_ENDPOINT                               \
+  --env=TRANSFORM_WORKERS=$TRANSFORM_WORKERS                                 \
+  --env=TRANSFORM_ROW_WORKERS=$TRANSFORM_ROW_WORKERS                         \
+  --env=TRANSFORM_BATCH_SIZE=$TRANSFORM_BATCH_SIZE                           \
+  --env=PRESERVE_PARTITION_ORDER=$PRESERVE_PARTITION_ORDER                   \
+  --env=HIERARCHY_SOURCE=$HIERARCHY_SOURCE                                   \
+  --env=HIERARCHY_CONNECT_TIMEOUT=$HIERARCHY_CONNECT_TIMEOUT                 \
+  --env=HIERARCHY_READ_TIMEOUT=$HIERARCHY_READ_TIMEOUT                       \
+  --env=HIERARCHY_RETRY_MAX_ATTEMPTS=$HIERARCHY_RETRY_MAX_ATTEMPTS           \
+  --env=HIERARCHY_RETRY_BACKOFF=$HIERARCHY_RETRY_BACKOFF                     \
+  --env=HIERARCHY_BREAKER_ERROR_THRESHOLD=$HIERARCHY_BREAKER_ERROR_THRESHOLD \
+  --env=HIERARCHY_BREAKER_TIMEOUT=$HIERARCHY_BREAKER_TIMEOUT                 \
+  --env=HIERARCHY_CACHE_TTL=$HIERARCHY_CACHE_TTL                             \
+  --env=HIERARCHY_CACHE_MAX_ENTRIES=$HIERARCHY_CACHE_MAX_ENTRIES             \
+  --env=HIERARCHY_CACHE_MAX_BYTES=$HIERARCHY_CACHE_MAX_BYTES                 \
+  --env=RETRY_MAX_ATTEMPTS=$RETRY_MAX_ATTEMPTS                               \
+  --env=RETRY_INITIAL_BACKOFF=$RETRY_INITIAL_BACKOFF                         \
+  --env=RETRY_MAX_BACKOFF=$RETRY_MAX_BACKOFF                                 \
+  --env=RETRY_BACKOFF_MULTIPLIER=$RETRY_BACKOFF_MULTIPLIER                   \
+  --env=RETRY_JITTER=$RETRY_JITTER                                           \
+  --env=USE_GZIP=$USE_GZIP                                                   \
+  --env=SPOOL_OUTPUT=$SPOOL_OUTPUT                                           \
+  --env=SPOOL_DIR=$SPOOL_DIR                                                 \
+  --env=UNRESOLVED_CODE_POLICY=$UNRESOLVED_CODE_POLICY                       \
+  --env=UNRESOLVED_CODE_THRESHOLD=$UNRESOLVED_CODE_THRESHOLD                 \
+  --env=VALIDATION_REPORT_FORMAT=$VALIDATION_REPORT_FORMAT                   \
+  --env=HEADER_ALIASES=$HEADER_ALIASES                                       \
+  --env=STRICT_VALIDATION=$STRICT_VALIDATION                                 \
+  --env=REJECTED_ROW_LIMIT=$REJECTED_ROW_LIMIT                               \
+  --env=HIERARCHY_COLUMNS=$HIERARCHY_COLUMNS                                 \
+  --env=TIME_PERIOD_COLUMNS=$TIME_PERIOD_COLUMNS                             \
+  --env=READINESS_S3_BUCKETS=$READINESS_S3_BUCKETS                           \
+  --env=READINESS_CACHE_TTL=$READINESS_CACHE_TTL                             \
+  --env=READINESS_TIMEOUT=$READINESS_TIMEOUT                                 \
+  --env=JOB_STORE=$JOB_STORE                                                 \
+  --env=JOB_STORE_MAX_JOBS=$JOB_STORE_MAX_JOBS                               \
+  --env=JOB_TIMEOUT=$JOB_TIMEOUT                                             \
+  --env=SHUTDOWN_GRACE_PERIOD=$SHUTDOWN_GRACE_PERIOD                         \
+  --name=dp-dd-csv-transformer                                               \
+  --net=$DOCKER_NETWORK                                                      \
+  --restart=always                                                           \
   $ECR_REPOSITORY_URI/dp-dd-csv-transformer:$GIT_COMMIT
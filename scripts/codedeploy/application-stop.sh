@@ -3,5 +3,6 @@
 CONTAINER_ID=$(docker ps | grep dp-dd-csv-transformer | awk '{print $1}')
 
 if [[ -n $CONTAINER_ID ]]; then
-  docker stop $CONTAINER_ID
+  # allow the requests in progress the SHUTDOWN_GRACE_PERIOD (20s by default) to finish before the container is killed
+  docker stop --time=30 $CONTAINER_ID
 fi
@@ -3,3 +3,14 @@
 if [[ $(docker inspect --format="{{ .State.Running }}" dp-dd-csv-transformer) == "false" ]]; then
   exit 1;
 fi
+
+ADDR=$(docker inspect --format="{{ range .NetworkSettings.Networks }}{{ .IPAddress }}{{ end }}" dp-dd-csv-transformer)
+
+# wait for the service to start responding
+for i in $(seq 1 30); do
+  if curl -sf http://$ADDR:21200/healthcheck > /dev/null; then
+    exit 0;
+  fi
+  sleep 1
+done
+exit 1
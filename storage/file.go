@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+var fileStorageDisabledErr = errors.New("File URLs are not enabled. Set FILE_STORAGE_ROOT to the directory that may be read from and written to.")
+
+// fileService reads and writes local files. Only files within the root directory can be accessed, so that a request
+// cannot read or overwrite any other file on the host.
+type fileService struct {
+	root string
+}
+
+func (s *fileService) GetCSV(ctx context.Context, requestID string, u URL) (io.ReadCloser, error) {
+	path, err := s.resolve(u)
+	if err != nil {
+		return nil, err
+	}
+	log.DebugC(requestID, "Opening local .csv file", log.Data{"path": path})
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return contextReadCloser{contextReader{ctx, file}, file}, nil
+}
+
+// contextReadCloser reads a file until the context is done.
+type contextReadCloser struct {
+	contextReader
+	io.Closer
+}
+
+// SaveFile writes the file to a temporary file in the same directory, then renames it, so that a partial file is never
+// left at the url, including if the context is done before the file has been written.
+func (s *fileService) SaveFile(ctx context.Context, requestID string, reader io.Reader, u URL) error {
+	path, err := s.resolve(u)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = io.Copy(tmpFile, contextReader{ctx, reader}); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return err
+	}
+	log.DebugC(requestID, "Saved local file", log.Data{"path": path})
+	return nil
+}
+
+// resolve returns the path of the file url, checking that it is within the root directory.
+func (s *fileService) resolve(u URL) (string, error) {
+	if len(s.root) == 0 {
+		return "", fileStorageDisabledErr
+	}
+	path := filepath.Clean(filepath.FromSlash(u.GetFilePath()))
+	rel, err := filepath.Rel(filepath.Clean(s.root), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("URL '%s' is not within FILE_STORAGE_ROOT", u.String())
+	}
+	return path, nil
+}
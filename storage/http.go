@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	"github.com/ONSdigital/go-ns/log"
+)
+
+const httpConnectTimeout = 10 * time.Second
+const httpResponseHeaderTimeout = 60 * time.Second
+
+// HttpStatusError is returned when an http(s) input file could not be downloaded. Server errors and 429 Too Many
+// Requests are retryable.
+type HttpStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e HttpStatusError) Error() string {
+	return fmt.Sprintf("Request for '%s' returned status %d", e.URL, e.StatusCode)
+}
+
+// Retryable implements retry.Retryable.
+func (e HttpStatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// httpService downloads input files from http(s) urls. Files cannot be saved.
+type httpService struct {
+	client *http.Client
+}
+
+func newHttpService() *httpService {
+	// no overall timeout, as large files may take some time to download
+	return &httpService{client: &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			Dial:                  (&net.Dialer{Timeout: httpConnectTimeout}).Dial,
+			TLSHandshakeTimeout:   httpConnectTimeout,
+			ResponseHeaderTimeout: httpResponseHeaderTimeout,
+		},
+	}}
+}
+
+func (s *httpService) GetCSV(ctx context.Context, requestID string, u URL) (io.ReadCloser, error) {
+	log.DebugC(requestID, "Requesting .csv file", log.Data{"url": u.String()})
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, retry.NewRetryableError(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, HttpStatusError{u.String(), res.StatusCode}
+	}
+	return retryableReadCloser{res.Body}, nil
+}
+
+func (s *httpService) SaveFile(ctx context.Context, requestID string, reader io.Reader, u URL) error {
+	return ReadOnlyError{u.String()}
+}
+
+// retryableReadCloser marks errors returned while reading the response body as retryable, so that a connection lost
+// part way through a download is retried.
+type retryableReadCloser struct {
+	io.ReadCloser
+}
+
+func (r retryableReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = retry.NewRetryableError(err)
+	}
+	return n, err
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
+	"github.com/ONSdigital/dp-dd-csv-transformer/ons_aws"
+)
+
+// Service defines the interface used to read input files and save output files. Errors that may succeed if retried
+// implement retry.Retryable. Downloads, including reading the body of a file, and saves are abandoned once the context
+// is done.
+type Service interface {
+	// GetCSV get the requested file. The client is responsible for closing the reader.
+	GetCSV(ctx context.Context, requestID string, u URL) (io.ReadCloser, error)
+	SaveFile(ctx context.Context, requestID string, reader io.Reader, u URL) error
+}
+
+// UnsupportedSchemeError is returned when there is no storage for the scheme of a url.
+type UnsupportedSchemeError struct {
+	URL string
+}
+
+func (e UnsupportedSchemeError) Error() string {
+	return fmt.Sprintf("Unsupported storage for URL '%s'", e.URL)
+}
+
+// ReadOnlyError is returned when a file is saved to a url that can only be read from.
+type ReadOnlyError struct {
+	URL string
+}
+
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("Unable to save to read-only URL '%s'", e.URL)
+}
+
+// schemeService dispatches each request to the Service for the scheme of the url.
+type schemeService struct {
+	services map[string]Service
+}
+
+// NewService create a new Service supporting s3 (through the AWS sdk, or the configured S3Endpoint), file (within the
+// FileStorageRoot) and read-only http(s) urls.
+func NewService() Service {
+	h := newHttpService()
+	return &schemeService{services: map[string]Service{
+		SchemeS3:    &s3Service{aws: ons_aws.NewService()},
+		SchemeFile:  &fileService{root: config.FileStorageRoot},
+		SchemeHTTP:  h,
+		SchemeHTTPS: h,
+	}}
+}
+
+func (s *schemeService) GetCSV(ctx context.Context, requestID string, u URL) (io.ReadCloser, error) {
+	service, ok := s.services[u.Scheme()]
+	if !ok {
+		return nil, UnsupportedSchemeError{u.String()}
+	}
+	return service.GetCSV(ctx, requestID, u)
+}
+
+func (s *schemeService) SaveFile(ctx context.Context, requestID string, reader io.Reader, u URL) error {
+	service, ok := s.services[u.Scheme()]
+	if !ok {
+		return UnsupportedSchemeError{u.String()}
+	}
+	return service.SaveFile(ctx, requestID, reader, u)
+}
+
+// s3Service stores files in S3 using an ons_aws.AWSService.
+type s3Service struct {
+	aws ons_aws.AWSService
+}
+
+func (s *s3Service) GetCSV(ctx context.Context, requestID string, u URL) (io.ReadCloser, error) {
+	s3url, err := ons_aws.NewS3URL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return s.aws.GetCSV(ctx, requestID, s3url)
+}
+
+func (s *s3Service) SaveFile(ctx context.Context, requestID string, reader io.Reader, u URL) error {
+	s3url, err := ons_aws.NewS3URL(u.String())
+	if err != nil {
+		return err
+	}
+	return s.aws.SaveFile(ctx, requestID, reader, s3url)
+}
+
+// contextReader a reader that fails, with the error of the context, once the context is done. It is used where reads
+// cannot otherwise be interrupted, e.g. reading or writing local files.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileService(t *testing.T) {
+
+	Convey("Given a file service with a root directory", t, func() {
+		root, err := ioutil.TempDir("", "storage")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		service := &fileService{root: root}
+		u, _ := NewURL("file://" + filepath.ToSlash(filepath.Join(root, "output", "file.csv")))
+
+		Convey("When a file is saved and read back", func() {
+			err := service.SaveFile(context.Background(), "foo", bytes.NewBufferString("a,b,c\n"), u)
+			So(err, ShouldBeNil)
+			reader, err := service.GetCSV(context.Background(), "foo", u)
+			So(err, ShouldBeNil)
+			defer reader.Close()
+			content, _ := ioutil.ReadAll(reader)
+
+			Convey("Then the content is unchanged", func() {
+				So(string(content), ShouldEqual, "a,b,c\n")
+			})
+		})
+
+		Convey("When a file is saved after the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := service.SaveFile(ctx, "foo", bytes.NewBufferString("a,b,c\n"), u)
+
+			Convey("Then the context's error is returned and no file is left", func() {
+				So(err, ShouldEqual, context.Canceled)
+				_, statErr := os.Stat(filepath.Join(root, "output", "file.csv"))
+				So(os.IsNotExist(statErr), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a file outside the root directory is requested", func() {
+			outside, _ := NewURL("file://" + filepath.ToSlash(filepath.Join(root, "..", "file.csv")))
+			_, err := service.GetCSV(context.Background(), "foo", outside)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a file service without a root directory", t, func() {
+		service := &fileService{}
+		u, _ := NewURL("file:///tmp/file.csv")
+
+		Convey("Then file urls cannot be saved to", func() {
+			So(service.SaveFile(context.Background(), "foo", bytes.NewBufferString(""), u), ShouldEqual, fileStorageDisabledErr)
+		})
+	})
+}
+
+func TestHttpService(t *testing.T) {
+
+	Convey("Given an http server", t, func() {
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			fmt.Fprint(w, "a,b,c\n")
+		}))
+		defer server.Close()
+		service := newHttpService()
+		u, _ := NewURL(server.URL + "/file.csv")
+
+		Convey("When the file is requested", func() {
+			reader, err := service.GetCSV(context.Background(), "foo", u)
+			So(err, ShouldBeNil)
+			defer reader.Close()
+			content, _ := ioutil.ReadAll(reader)
+
+			Convey("Then the content is returned", func() {
+				So(string(content), ShouldEqual, "a,b,c\n")
+			})
+		})
+
+		Convey("When the server returns a server error", func() {
+			status = http.StatusServiceUnavailable
+			_, err := service.GetCSV(context.Background(), "foo", u)
+
+			Convey("Then a retryable error is returned", func() {
+				So(err, ShouldResemble, HttpStatusError{u.String(), http.StatusServiceUnavailable})
+				So(retry.IsRetryable(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the file is not found", func() {
+			status = http.StatusNotFound
+			_, err := service.GetCSV(context.Background(), "foo", u)
+
+			Convey("Then a permanent error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(retry.IsRetryable(err), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the request is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := service.GetCSV(ctx, "foo", u)
+
+			Convey("Then the context's error is returned, which is not retryable", func() {
+				So(err, ShouldEqual, context.Canceled)
+				So(retry.IsRetryable(err), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a file is saved", func() {
+			err := service.SaveFile(context.Background(), "foo", bytes.NewBufferString(""), u)
+
+			Convey("Then a ReadOnlyError is returned", func() {
+				So(err, ShouldResemble, ReadOnlyError{u.String()})
+			})
+		})
+	})
+}
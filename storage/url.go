@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The url schemes supported by the storage Service.
+const (
+	SchemeS3    = "s3"
+	SchemeFile  = "file"
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// URL the location of an input or output file: s3://bucket/key, file:///path, or (for input only) http(s)://host/path.
+type URL struct {
+	URL *url.URL
+}
+
+var NilURL = URL{nil}
+
+func NewURL(s string) (URL, error) {
+	u, err := parseUrl(s)
+	if err != nil {
+		return NilURL, err
+	}
+	return URL{u}, nil
+}
+
+func (s *URL) UnmarshalJSON(b []byte) (err error) {
+	url, err := parseUrl(strings.Trim(string(b), "\"'"))
+	if err != nil {
+		return err
+	}
+	s.URL = url
+	return nil
+}
+
+func (s URL) MarshalJSON() ([]byte, error) {
+	if s.URL == nil {
+		return []byte("null"), nil
+	}
+	return []byte("\"" + s.URL.String() + "\""), nil
+}
+
+func parseUrl(s string) (*url.URL, error) {
+	var u *url.URL
+	var err error
+	if u, err = url.Parse(s); err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case SchemeS3, SchemeHTTP, SchemeHTTPS:
+		if (len(u.Host)) < 1 {
+			return nil, fmt.Errorf("URL '%s' does not contain a Bucket or Host", s)
+		}
+	case SchemeFile:
+		if len(u.Host) > 0 && u.Host != "localhost" {
+			return nil, fmt.Errorf("URL '%s' is not a local file", s)
+		}
+	default:
+		return nil, fmt.Errorf("URL '%s' has an unsupported scheme (expected s3, file, http or https)", s)
+	}
+	if (len(strings.TrimLeft(u.Path, "/"))) < 1 {
+		return nil, fmt.Errorf("URL '%s' does not contain a FilePath", s)
+	}
+	return u, nil
+}
+
+// Scheme returns the scheme of the url, e.g. SchemeS3.
+func (s *URL) Scheme() string {
+	return s.URL.Scheme
+}
+
+// IsWritable returns true if a file can be saved to the url.
+func (s *URL) IsWritable() bool {
+	return s.URL.Scheme == SchemeS3 || s.URL.Scheme == SchemeFile
+}
+
+// GetBucketName returns the bucket of an s3 url, or the host of an http(s) url.
+func (s *URL) GetBucketName() string {
+	return s.URL.Host
+}
+
+// GetFilePath returns the key of an s3 url, the path of an http(s) url (without the leading /), or the absolute path of
+// a file url.
+func (s *URL) GetFilePath() string {
+	if s.URL.Scheme == SchemeFile {
+		return s.URL.Path
+	}
+	return strings.TrimPrefix(s.URL.Path, "/")
+}
+
+// WithSuffix returns a copy of the url with the suffix appended to its path, e.g. for a file alongside it.
+func (s *URL) WithSuffix(suffix string) URL {
+	u := *s.URL
+	u.Path += suffix
+	u.RawPath = ""
+	return URL{&u}
+}
+
+func (s *URL) String() string {
+	var u url.URL = *s.URL
+	return u.String()
+}
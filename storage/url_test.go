@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewURL(t *testing.T) {
+
+	Convey("Given a valid s3 url", t, func() {
+		u, err := NewURL("s3://bucket/folder/file.csv")
+
+		Convey("Then the url should have the correct bucket and filename", func() {
+			So(err, ShouldBeNil)
+			So(u.Scheme(), ShouldEqual, SchemeS3)
+			So(u.GetBucketName(), ShouldEqual, "bucket")
+			So(u.GetFilePath(), ShouldEqual, "folder/file.csv")
+			So(u.IsWritable(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a valid file url", t, func() {
+		u, err := NewURL("file:///data/file.csv")
+
+		Convey("Then the url should have the absolute path of the file", func() {
+			So(err, ShouldBeNil)
+			So(u.Scheme(), ShouldEqual, SchemeFile)
+			So(u.GetFilePath(), ShouldEqual, "/data/file.csv")
+			So(u.IsWritable(), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a valid https url", t, func() {
+		u, err := NewURL("https://example.com/data/file.csv")
+
+		Convey("Then the url should be read-only", func() {
+			So(err, ShouldBeNil)
+			So(u.GetFilePath(), ShouldEqual, "data/file.csv")
+			So(u.IsWritable(), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given invalid urls", t, func() {
+		for _, s := range []string{"/file", "s3://bucket/", "ftp://host/file.csv", "file://remote-host/file.csv", "https:///file.csv"} {
+			u, err := NewURL(s)
+
+			Convey("Then "+s+" should be NilURL and err should not be nil", func() {
+				So(u, ShouldResemble, NilURL)
+				So(err, ShouldNotBeNil)
+			})
+		}
+	})
+}
+
+func TestURLCanBeMarshaledAndUnmarshaled(t *testing.T) {
+
+	Convey("Given a url marshaled to json", t, func() {
+		original, _ := NewURL("file:///data/file.csv")
+		marshaled, _ := json.Marshal(original)
+
+		Convey("Then the unmarshaled object should resemble the original", func() {
+			var unmarshaled URL
+			err := json.Unmarshal(marshaled, &unmarshaled)
+			So(err, ShouldBeNil)
+			So(unmarshaled, ShouldResemble, original)
+		})
+	})
+}
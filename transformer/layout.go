@@ -0,0 +1,206 @@
+package transformer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The columns recognised in the header row of an input csv. The dimension columns are numbered, e.g.
+// Dimension_Hierarchy_1, and every dimension must have all three.
+const (
+	ColumnObservation        = "Observation"
+	ColumnDataMarking        = "Data_Marking"
+	ColumnObservationType    = "Observation_Type_Value"
+	ColumnDimensionHierarchy = "Dimension_Hierarchy"
+	ColumnDimensionName      = "Dimension_Name"
+	ColumnDimensionValue     = "Dimension_Value"
+)
+
+var observationColumns = []string{ColumnObservation, ColumnDataMarking, ColumnObservationType}
+var dimensionColumnNames = []string{ColumnDimensionHierarchy, ColumnDimensionName, ColumnDimensionValue}
+
+// HeaderAliases the alternative header names accepted for each column, keyed by the column name, e.g.
+// {"Observation": ["Value"], "Dimension_Hierarchy": ["Hierarchy"]}. An alias of a dimension column is followed by the
+// dimension number, e.g. Hierarchy_1. Header names are matched ignoring case and surrounding spaces.
+type HeaderAliases map[string][]string
+
+// ParseHeaderAliases parses aliases in the form "Observation=Value|Count,Dimension_Hierarchy=Hierarchy".
+func ParseHeaderAliases(s string) (HeaderAliases, error) {
+	aliases := HeaderAliases{}
+	if len(strings.TrimSpace(s)) == 0 {
+		return aliases, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		column := strings.TrimSpace(parts[0])
+		if !isColumnName(column) {
+			return nil, fmt.Errorf("Invalid header alias '%s' (expected one of %s, %s)", entry, strings.Join(observationColumns, ", "), strings.Join(dimensionColumnNames, ", "))
+		}
+		if len(parts) < 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("Invalid header alias '%s' (expected %s=alias)", entry, column)
+		}
+		for _, alias := range strings.Split(parts[1], "|") {
+			aliases[column] = append(aliases[column], strings.TrimSpace(alias))
+		}
+	}
+	return aliases, nil
+}
+
+func isColumnName(name string) bool {
+	for _, columns := range [][]string{observationColumns, dimensionColumnNames} {
+		for _, column := range columns {
+			if name == column {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// names returns the lower case names matching the column.
+func (a HeaderAliases) names(column string) []string {
+	names := []string{strings.ToLower(column)}
+	for _, alias := range a[column] {
+		names = append(names, strings.ToLower(alias))
+	}
+	return names
+}
+
+// LayoutError is returned when the header row does not describe a layout that can be transformed.
+type LayoutError struct {
+	Reason string
+}
+
+func (e LayoutError) Error() string {
+	return "Invalid csv header: " + e.Reason
+}
+
+// dimensionColumns the indexes of the hierarchy, name and value columns of a dimension.
+type dimensionColumns struct {
+	hierarchy int
+	name      int
+	value     int
+}
+
+// layout the columns of an input csv, parsed from its header row. Columns that are neither observation nor dimension
+// columns are passed through unchanged, after the dimensions.
+type layout struct {
+	observation     int
+	dataMarking     int
+	observationType int
+	dimensions      []dimensionColumns
+	passthrough     []int
+	headers         []string
+}
+
+// parseLayout maps the header row to the columns of the csv, returning a LayoutError if a column is missing or
+// duplicated, or a dimension is incomplete.
+func parseLayout(headers []string, aliases HeaderAliases) (*layout, error) {
+	found := make(map[string]int)
+	dimensions := make(map[int]map[string]int)
+	l := &layout{headers: headers}
+
+	for i, header := range headers {
+		// ignore any byte order mark at the start of the file
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")))
+		column, number, err := matchColumn(name, aliases)
+		if err != nil {
+			return nil, LayoutError{fmt.Sprintf("column %d (%s) %s", i+1, header, err.Error())}
+		}
+		if len(column) == 0 {
+			l.passthrough = append(l.passthrough, i)
+			continue
+		}
+		columns := found
+		if number > 0 {
+			if dimensions[number] == nil {
+				dimensions[number] = make(map[string]int)
+			}
+			columns = dimensions[number]
+		}
+		if previous, ok := columns[column]; ok {
+			return nil, LayoutError{fmt.Sprintf("column %d (%s) duplicates column %d (%s)", i+1, header, previous+1, headers[previous])}
+		}
+		columns[column] = i
+	}
+
+	for _, column := range observationColumns {
+		if _, ok := found[column]; !ok {
+			return nil, LayoutError{fmt.Sprintf("no %s column", column)}
+		}
+	}
+	l.observation, l.dataMarking, l.observationType = found[ColumnObservation], found[ColumnDataMarking], found[ColumnObservationType]
+
+	numbers := make([]int, 0, len(dimensions))
+	for number := range dimensions {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	for i, number := range numbers {
+		if number != i+1 {
+			return nil, LayoutError{fmt.Sprintf("no columns for dimension %d (found columns for dimension %d)", i+1, number)}
+		}
+		columns := dimensions[number]
+		for _, column := range dimensionColumnNames {
+			if _, ok := columns[column]; !ok {
+				return nil, LayoutError{fmt.Sprintf("no %s_%d column for dimension %d", column, number, number)}
+			}
+		}
+		l.dimensions = append(l.dimensions, dimensionColumns{
+			hierarchy: columns[ColumnDimensionHierarchy],
+			name:      columns[ColumnDimensionName],
+			value:     columns[ColumnDimensionValue],
+		})
+	}
+	return l, nil
+}
+
+// matchColumn returns the column matching the lower case header name, with its dimension number for a dimension
+// column. An empty column is returned for a passthrough column.
+func matchColumn(name string, aliases HeaderAliases) (string, int, error) {
+	for _, column := range observationColumns {
+		for _, n := range aliases.names(column) {
+			if name == n {
+				return column, 0, nil
+			}
+		}
+	}
+	for _, column := range dimensionColumnNames {
+		for _, n := range aliases.names(column) {
+			if !strings.HasPrefix(name, n) {
+				continue
+			}
+			suffix := strings.TrimPrefix(name[len(n):], "_")
+			number, err := strconv.Atoi(suffix)
+			if err != nil {
+				continue
+			}
+			if number < 1 {
+				return "", 0, fmt.Errorf("has an invalid dimension number %s", suffix)
+			}
+			return column, number, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// observationValues returns the observation columns of the row.
+func (l *layout) observationValues(row []string) []string {
+	return []string{row[l.observation], row[l.dataMarking], row[l.observationType]}
+}
+
+// passthroughHeaders returns the headers of the passthrough columns.
+func (l *layout) passthroughHeaders() []string {
+	return l.passthroughValues(l.headers)
+}
+
+// passthroughValues returns the passthrough columns of the row.
+func (l *layout) passthroughValues(row []string) []string {
+	values := make([]string, len(l.passthrough))
+	for i, column := range l.passthrough {
+		values[i] = row[column]
+	}
+	return values
+}
@@ -0,0 +1,98 @@
+package transformer_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func transformString(t *transformer.Transformer, input string) (string, error) {
+	var output bytes.Buffer
+	_, err := t.Transform(context.Background(), strings.NewReader(input), &output, createMockHierarchyClient([]string{"time"}, []string{}, []string{}), "test", transformer.Options{})
+	return output.String(), err
+}
+
+func TestLayout(t *testing.T) {
+
+	Convey("Given a csv with reordered columns and a passthrough column", t, func() {
+		input := "Dimension_Value_2,Dimension_Name_2,Dimension_Hierarchy_2,Source,Observation,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1,Data_Marking,Observation_Type_Value\n" +
+			"Male,Sex,,census,42,2011STATH,Geography,K04000001,,\n"
+
+		Convey("Then the dimensions are identified from the header, and the passthrough column is kept", func() {
+			output, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldBeNil)
+			So(output, ShouldEqual, "Observation,Data_Marking,Observation_Type_Value,"+
+				"Dimension_1_Name,Dimension_1_Hierarchy,Dimension_1_Code,Dimension_1_Value,Dimension_2_Name,Dimension_2_Value,Source\n"+
+				"42,,,Geography,2011STATH,K04000001,Value for K04000001,Sex,Male,census\n")
+		})
+	})
+
+	Convey("Given a csv with aliased headers", t, func() {
+		input := "value,data_marking, observation_type_value ,Hierarchy1,Name1,Code1\n" +
+			"42,,,time,Year,2014\n"
+		aliases, err := transformer.ParseHeaderAliases("Observation=Value,Dimension_Hierarchy=Hierarchy,Dimension_Name=Name,Dimension_Value=Code|Label")
+		So(err, ShouldBeNil)
+
+		Convey("Then the aliases are matched ignoring case and spaces", func() {
+			output, err := transformString(&transformer.Transformer{HeaderAliases: aliases}, input)
+			So(err, ShouldBeNil)
+			So(output, ShouldEqual, "Observation,Data_Marking,Observation_Type_Value,Dimension_1_Name,Dimension_1_Hierarchy,Dimension_1_Code\n"+
+				"42,,,Year,time,2014\n")
+		})
+	})
+
+	Convey("Given csvs with malformed headers", t, func() {
+		header := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1"
+
+		Convey("Then a trailing partial dimension is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, header+",Dimension_Hierarchy_2,Dimension_Name_2\n1,,,,Sex,Male,,Age\n")
+			So(err, ShouldResemble, transformer.LayoutError{Reason: "no Dimension_Value_2 column for dimension 2"})
+		})
+
+		Convey("Then a duplicated column is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, header+",dimension_name_1\n1,,,,Sex,Male,Sex\n")
+			So(err, ShouldResemble, transformer.LayoutError{Reason: "column 7 (dimension_name_1) duplicates column 5 (Dimension_Name_1)"})
+		})
+
+		Convey("Then a missing observation column is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, strings.TrimPrefix(header, "Observation,")+"\n,,,Sex,Male\n")
+			So(err, ShouldResemble, transformer.LayoutError{Reason: "no Observation column"})
+		})
+
+		Convey("Then a gap in the dimension numbers is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, header+",Dimension_Hierarchy_3,Dimension_Name_3,Dimension_Value_3\n1,,,,Sex,Male,,Age,All\n")
+			So(err, ShouldResemble, transformer.LayoutError{Reason: "no columns for dimension 2 (found columns for dimension 3)"})
+		})
+
+		Convey("Then a dimension numbered 0 is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, header+",Dimension_Name_0\n")
+			So(err, ShouldResemble, transformer.LayoutError{Reason: "column 7 (Dimension_Name_0) has an invalid dimension number 0"})
+		})
+	})
+}
+
+func TestParseHeaderAliases(t *testing.T) {
+
+	Convey("Given aliases for a column", t, func() {
+		aliases, err := transformer.ParseHeaderAliases("Observation=Value|Count, Dimension_Hierarchy=Hierarchy")
+
+		Convey("Then the aliases are keyed by the column", func() {
+			So(err, ShouldBeNil)
+			So(aliases, ShouldResemble, transformer.HeaderAliases{"Observation": {"Value", "Count"}, "Dimension_Hierarchy": {"Hierarchy"}})
+		})
+	})
+
+	Convey("Given an alias for an unknown column, or without a name", t, func() {
+		_, columnErr := transformer.ParseHeaderAliases("Geography=Area")
+		_, aliasErr := transformer.ParseHeaderAliases("Observation=")
+
+		Convey("Then an error is returned", func() {
+			So(columnErr, ShouldNotBeNil)
+			So(aliasErr, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,212 @@
+package transformer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
+)
+
+// The types of Period.
+const (
+	PeriodYear          = "year"
+	PeriodQuarter       = "quarter"
+	PeriodMonth         = "month"
+	PeriodWeek          = "week"
+	PeriodFinancialYear = "financial-year"
+)
+
+// isoDate the format of the period start and end dates.
+const isoDate = "2006-01-02"
+
+// Period the period of time described by a time code, from the first day (Start) to the last day (End) inclusive.
+type Period struct {
+	Type  string
+	Start time.Time
+	End   time.Time
+}
+
+// periodFormat a format of time code, and how to convert its submatches to a Period.
+type periodFormat struct {
+	periodType string
+	pattern    *regexp.Regexp
+	parse      func(m []string) (Period, bool)
+	// hinted formats are ambiguous, so are only used if the expected type of period is known
+	hinted bool
+}
+
+var periodFormats = []periodFormat{
+	{PeriodYear, regexp.MustCompile(`^(\d{4})$`), func(m []string) (Period, bool) {
+		return months(PeriodYear, atoi(m[1]), 1, 12), true
+	}, false},
+	{PeriodQuarter, regexp.MustCompile(`^(\d{4})[ -]?q([1-4])$`), func(m []string) (Period, bool) {
+		return quarter(atoi(m[1]), atoi(m[2])), true
+	}, false},
+	{PeriodQuarter, regexp.MustCompile(`^q([1-4])[ -]?(\d{4})$`), func(m []string) (Period, bool) {
+		return quarter(atoi(m[2]), atoi(m[1])), true
+	}, false},
+	{PeriodMonth, regexp.MustCompile(`^(\d{4})(?:-|[ -]?m)(\d{2})$`), func(m []string) (Period, bool) {
+		return month(atoi(m[1]), atoi(m[2]))
+	}, false},
+	{PeriodMonth, regexp.MustCompile(`^(\d{4})[ -]([a-z]+)$`), func(m []string) (Period, bool) {
+		return month(atoi(m[1]), monthNumber(m[2]))
+	}, false},
+	{PeriodMonth, regexp.MustCompile(`^([a-z]+)[ -](\d{4})$`), func(m []string) (Period, bool) {
+		return month(atoi(m[2]), monthNumber(m[1]))
+	}, false},
+	{PeriodWeek, regexp.MustCompile(`^(\d{4})[ -]?w(\d{2})$`), func(m []string) (Period, bool) {
+		return week(atoi(m[1]), atoi(m[2]))
+	}, false},
+	{PeriodFinancialYear, regexp.MustCompile(`^(\d{4})/(\d{2}|\d{4})$`), func(m []string) (Period, bool) {
+		return financialYear(atoi(m[1]), m[2])
+	}, false},
+	{PeriodFinancialYear, regexp.MustCompile(`^(\d{4})-(\d{4})$`), func(m []string) (Period, bool) {
+		return financialYear(atoi(m[1]), m[2])
+	}, false},
+	// e.g. 2011-12 is December 2011 unless the code is known to be a financial year
+	{PeriodFinancialYear, regexp.MustCompile(`^(\d{4})-(\d{2})$`), func(m []string) (Period, bool) {
+		return financialYear(atoi(m[1]), m[2])
+	}, true},
+}
+
+// ParsePeriod parses a time code, e.g. "2014", "2014 Q1", "2014-03", "Mar 2014", "2014-W05" or "2014/15", into a Period.
+// If periodType is not empty the code must be that type of period.
+func ParsePeriod(code string, periodType string) (Period, error) {
+	normalised := strings.ToLower(strings.TrimSpace(code))
+	for _, format := range periodFormats {
+		if (len(periodType) > 0 && format.periodType != periodType) || (format.hinted && len(periodType) == 0) {
+			continue
+		}
+		if m := format.pattern.FindStringSubmatch(normalised); m != nil {
+			if period, ok := format.parse(m); ok {
+				return period, nil
+			}
+		}
+	}
+	if len(periodType) > 0 {
+		return Period{}, fmt.Errorf("Unable to parse time code '%s' as a %s", code, periodType)
+	}
+	return Period{}, fmt.Errorf("Unable to parse time code '%s'", code)
+}
+
+// periodTypeOfLevel returns the type of period of the codes at a level of a time hierarchy, or an empty string if it
+// is not known.
+func periodTypeOfLevel(level *hierarchy.HierarchyLevelType) string {
+	if level == nil {
+		return ""
+	}
+	name := strings.ToLower(level.Code + " " + level.Name)
+	switch {
+	case strings.Contains(name, "financial"):
+		return PeriodFinancialYear
+	case strings.Contains(name, "quarter"):
+		return PeriodQuarter
+	case strings.Contains(name, "month"):
+		return PeriodMonth
+	case strings.Contains(name, "week"):
+		return PeriodWeek
+	case strings.Contains(name, "year"):
+		return PeriodYear
+	}
+	return ""
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+// months returns the period from the start of the first month to the end of the last month of the year.
+func months(periodType string, year int, first int, last int) Period {
+	start := time.Date(year, time.Month(first), 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(year, time.Month(last+1), 0, 0, 0, 0, 0, time.UTC)
+	return Period{periodType, start, end}
+}
+
+func quarter(year int, q int) Period {
+	return months(PeriodQuarter, year, q*3-2, q*3)
+}
+
+func month(year int, m int) (Period, bool) {
+	if m < 1 || m > 12 {
+		return Period{}, false
+	}
+	return months(PeriodMonth, year, m, m), true
+}
+
+// monthNumber returns the number of the month with the given (lower case) name or abbreviation, or 0.
+func monthNumber(name string) int {
+	for m := time.January; m <= time.December; m++ {
+		full := strings.ToLower(m.String())
+		if name == full || name == full[:3] || (len(name) > 3 && strings.HasPrefix(full, name)) {
+			return int(m)
+		}
+	}
+	return 0
+}
+
+// week returns the ISO 8601 week, from Monday to Sunday.
+func week(year int, w int) (Period, bool) {
+	// week 1 is the week containing the 4th of January
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	start := jan4.AddDate(0, 0, -((int(jan4.Weekday())+6)%7)+(w-1)*7)
+	if y, isoWeek := start.ISOWeek(); w < 1 || y != year || isoWeek != w {
+		return Period{}, false
+	}
+	return Period{PeriodWeek, start, start.AddDate(0, 0, 6)}, true
+}
+
+// financialYear returns the UK financial year from the 1st of April, if the end year follows the start year.
+func financialYear(year int, endYear string) (Period, bool) {
+	next := strconv.Itoa(year + 1)
+	if endYear != next && endYear != next[2:] {
+		return Period{}, false
+	}
+	start := time.Date(year, time.April, 1, 0, 0, 0, 0, time.UTC)
+	return Period{PeriodFinancialYear, start, start.AddDate(1, 0, -1)}, true
+}
+
+// parsedPeriod the period columns of a time code, or the error parsing it.
+type parsedPeriod struct {
+	columns []string
+	err     error
+}
+
+// getPeriodColumns returns the period type, start date and end date of the time code in the row. If the code is in the
+// time hierarchy its level determines the expected type of period. A code that cannot be parsed is recorded in the
+// result of the row, and the columns left blank. An error is returned if the time hierarchy could not be looked up.
+func (d *Dimension) getPeriodColumns(ctx context.Context, row []string, result *rowResult) ([]string, error) {
+	hierarchyId := row[d.columns.hierarchy]
+	code := row[d.columns.value]
+	key := unresolvedKey{hierarchyId, code}
+	d.mutex.RLock()
+	period, ok := d.periods[key]
+	d.mutex.RUnlock()
+	if !ok {
+		periodType := ""
+		entry, err := d.hc.GetHierarchyEntry(ctx, hierarchyId, code)
+		if err == nil {
+			periodType = periodTypeOfLevel(entry.LevelType)
+		} else if _, ok := err.(hierarchy.EntryNotFoundError); !ok {
+			return nil, err
+		}
+		p, err := ParsePeriod(code, periodType)
+		if err != nil {
+			period = parsedPeriod{err: err}
+		} else {
+			period = parsedPeriod{columns: []string{p.Type, p.Start.Format(isoDate), p.End.Format(isoDate)}}
+		}
+		d.mutex.Lock()
+		d.periods[key] = period
+		d.mutex.Unlock()
+	}
+	if period.err != nil {
+		result.issues = append(result.issues, codeIssue{unparsed: true, hierarchyId: hierarchyId, code: code, err: period.err})
+		return make([]string, 3), nil
+	}
+	return period.columns, nil
+}
@@ -0,0 +1,58 @@
+package transformer_test
+
+import (
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParsePeriod(t *testing.T) {
+
+	Convey("Given time codes in the supported formats", t, func() {
+		periods := []struct {
+			code       string
+			periodType string
+			expected   []string
+		}{
+			{"2014", "", []string{transformer.PeriodYear, "2014-01-01", "2014-12-31"}},
+			{"2014 Q1", "", []string{transformer.PeriodQuarter, "2014-01-01", "2014-03-31"}},
+			{"Q4-2014", "", []string{transformer.PeriodQuarter, "2014-10-01", "2014-12-31"}},
+			{"2014-03", "", []string{transformer.PeriodMonth, "2014-03-01", "2014-03-31"}},
+			{"2016M02", "", []string{transformer.PeriodMonth, "2016-02-01", "2016-02-29"}},
+			{"Sept 2014", "", []string{transformer.PeriodMonth, "2014-09-01", "2014-09-30"}},
+			{"2014 DEC", "", []string{transformer.PeriodMonth, "2014-12-01", "2014-12-31"}},
+			{"2015-W01", "", []string{transformer.PeriodWeek, "2014-12-29", "2015-01-04"}},
+			{"2015 W53", "", []string{transformer.PeriodWeek, "2015-12-28", "2016-01-03"}},
+			{"2014/15", "", []string{transformer.PeriodFinancialYear, "2014-04-01", "2015-03-31"}},
+			{"1999-2000", "", []string{transformer.PeriodFinancialYear, "1999-04-01", "2000-03-31"}},
+			{"2011-12", "", []string{transformer.PeriodMonth, "2011-12-01", "2011-12-31"}},
+			{"2011-12", transformer.PeriodFinancialYear, []string{transformer.PeriodFinancialYear, "2011-04-01", "2012-03-31"}},
+		}
+
+		Convey("Then each code is parsed into a period", func() {
+			for _, p := range periods {
+				period, err := transformer.ParsePeriod(p.code, p.periodType)
+				So(err, ShouldBeNil)
+				So([]string{period.Type, period.Start.Format("2006-01-02"), period.End.Format("2006-01-02")}, ShouldResemble, p.expected)
+			}
+		})
+	})
+
+	Convey("Given time codes that are not valid periods", t, func() {
+		codes := []struct {
+			code       string
+			periodType string
+		}{
+			{"2014 Q5", ""}, {"2014-13", ""}, {"2014 W53", ""}, {"2014/16", ""}, {"Spring 2014", ""}, {"", ""},
+			{"2014", transformer.PeriodMonth},
+		}
+
+		Convey("Then an error is returned", func() {
+			for _, c := range codes {
+				_, err := transformer.ParsePeriod(c.code, c.periodType)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package transformer
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ONSdigital/go-ns/log"
+)
+
+// defaultBatchSize the number of rows passed to a worker at a time if the BatchSize is not set.
+const defaultBatchSize = 1000
+
+// rowBatch a batch of consecutive rows, transformed by one worker. done is closed when the results are ready.
+type rowBatch struct {
+	firstRow int64
+	rows     [][]string
+	results  []rowResult
+	// readErr the error reading the row after the batch, io.EOF at the end of the file
+	readErr error
+	done    chan struct{}
+}
+
+// transformRowsInParallel transforms the rows in a pipeline: the rows are read in batches, each batch is transformed by
+// one of the RowWorkers, and the results are applied (see apply) in row order. The output and stats are the same as if
+// the rows were transformed serially (see transformRows), including on error: no row is written after the row that
+// failed. The rows are read ahead of the writer, by up to RowWorkers*2 batches (plus the batch being read), so rows
+// after the one that failed may have been read, but the reader stops before its next read once the transform has
+// failed. The workers stop transforming rows, and the results are no longer applied, once the context is done.
+func (p *Transformer) transformRowsInParallel(ctx context.Context, csvReader *csv.Reader, csvWriter *csv.Writer, l *layout, dimensions []*Dimension, row []string, rowNumber int64, stats *Stats, requestId string) error {
+	batchSize := p.BatchSize
+	if batchSize < 1 {
+		batchSize = defaultBatchSize
+	}
+	// batches are queued in row order to be written, which limits the number of batches read ahead of the writer
+	queue := make(chan *rowBatch, p.RowWorkers*2)
+	work := make(chan *rowBatch, p.RowWorkers)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(quit)
+		wg.Wait()
+	}()
+
+	// read the batches
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(work)
+		defer close(queue)
+		for {
+			batch := &rowBatch{firstRow: rowNumber, rows: make([][]string, 0, batchSize), done: make(chan struct{})}
+			for batch.readErr == nil && len(batch.rows) < batchSize {
+				batch.rows = append(batch.rows, row)
+				rowNumber++
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				row, batch.readErr = csvReader.Read()
+			}
+			select {
+			case queue <- batch:
+			case <-quit:
+				return
+			}
+			select {
+			case work <- batch:
+			case <-quit:
+				return
+			}
+			if batch.readErr != nil {
+				return
+			}
+		}
+	}()
+
+	// transform the batches
+	for i := 0; i < p.RowWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range work {
+				select {
+				case <-quit:
+					// the writer has stopped, so the results are not needed
+				case <-ctx.Done():
+					// the writer will stop
+				default:
+					batch.results = make([]rowResult, len(batch.rows))
+					for j, r := range batch.rows {
+						batch.results[j] = p.transformRow(ctx, l, dimensions, r, batch.firstRow+int64(j))
+					}
+				}
+				close(batch.done)
+			}
+		}()
+	}
+
+	// write the results in row order
+	for batch := range queue {
+		select {
+		case <-batch.done:
+		case <-ctx.Done():
+		}
+		if err := p.checkContext(ctx, batch.firstRow, requestId); err != nil {
+			return err
+		}
+		for _, result := range batch.results {
+			if err := p.checkContext(ctx, result.rowNumber, requestId); err != nil {
+				return err
+			}
+			if err := p.apply(result, csvWriter, stats, requestId); err != nil {
+				return err
+			}
+		}
+		lastRow := batch.firstRow + int64(len(batch.rows)) - 1
+		if batch.readErr == io.EOF {
+			log.DebugC(requestId, "Finished transformation", log.Data{"rowsProcessed": lastRow})
+			return nil
+		}
+		if batch.readErr != nil {
+			log.ErrorC(requestId, batch.readErr, log.Data{"message": fmt.Sprintf("Unable to read row %d", lastRow+1)})
+			return batch.readErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package transformer_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// scaleSample returns the sample csv with its rows repeated the given number of times.
+func scaleSample(fileName string, copies int) string {
+	content, err := ioutil.ReadFile("../sample_csv/" + fileName)
+	if err != nil {
+		panic(err)
+	}
+	lines := strings.SplitAfterN(string(content), "\n", 2)
+	return lines[0] + strings.Repeat(lines[1], copies)
+}
+
+func transformWith(t *transformer.Transformer, input string, hc hierarchy.HierarchyClient, options transformer.Options) (string, *transformer.Stats, error) {
+	var output bytes.Buffer
+	stats, err := t.Transform(context.Background(), strings.NewReader(input), &output, hc, "test", options)
+	// the timings vary, and the parallel reader may have read ahead of a failed row
+	stats.Timings, stats.BytesIn = transformer.Timings{}, 0
+	return output.String(), stats, err
+}
+
+func TestParallelTransform(t *testing.T) {
+
+	Convey("Given the Open-Data-v3 sample scaled up, with unresolved codes", t, func() {
+		input := scaleSample("Open-Data-v3.csv", 4)
+		mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"CI_0021510", "CI_0008566"})
+		options := transformer.Options{HierarchyColumns: true, TimePeriodColumns: true}
+		serialOutput, serialStats, serialErr := transformWith(&transformer.Transformer{}, input, mockClient, options)
+		So(serialErr, ShouldBeNil)
+
+		Convey("Then the output and stats are the same for any number of workers and batch size", func() {
+			for _, p := range []*transformer.Transformer{
+				{RowWorkers: 2, BatchSize: 1},
+				{RowWorkers: 4, BatchSize: 7},
+				{RowWorkers: 8},
+			} {
+				output, stats, err := transformWith(p, input, mockClient, options)
+				So(err, ShouldBeNil)
+				So(output, ShouldEqual, serialOutput)
+				So(stats, ShouldResemble, serialStats)
+				So(stats.ValidationReport(), ShouldResemble, serialStats.ValidationReport())
+			}
+		})
+
+		Convey("Then the transform fails at the same row with the fail policy", func() {
+			policy := transformer.UnresolvedCodePolicy{Action: transformer.PolicyFail}
+			serialOutput, serialStats, serialErr := transformWith(&transformer.Transformer{UnresolvedCodePolicy: policy}, input, mockClient, options)
+			So(serialErr, ShouldResemble, transformer.UnresolvedCodeError{HierarchyID: "CL_0000737", Code: "CI_0021510", Row: 3})
+
+			output, stats, err := transformWith(&transformer.Transformer{UnresolvedCodePolicy: policy, RowWorkers: 4, BatchSize: 2}, input, mockClient, options)
+			So(err, ShouldResemble, serialErr)
+			So(output, ShouldEqual, serialOutput)
+			So(stats, ShouldResemble, serialStats)
+		})
+
+		Convey("Then the transform fails at the same row above a count threshold", func() {
+			policy, _ := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "40")
+			serialOutput, serialStats, serialErr := transformWith(&transformer.Transformer{UnresolvedCodePolicy: policy}, input, mockClient, options)
+			So(serialErr, ShouldHaveSameTypeAs, transformer.UnresolvedThresholdError{})
+
+			output, stats, err := transformWith(&transformer.Transformer{UnresolvedCodePolicy: policy, RowWorkers: 3, BatchSize: 10}, input, mockClient, options)
+			So(err, ShouldResemble, serialErr)
+			So(output, ShouldEqual, serialOutput)
+			So(stats, ShouldResemble, serialStats)
+		})
+	})
+
+	Convey("Given a csv with invalid rows in strict mode", t, func() {
+		input := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1\n" +
+			"1,,,,Sex,Male\n" +
+			",,,,Sex,Female\n" +
+			"3,,,,Age,All\n" +
+			"4,,,,Sex,All\n" +
+			"5,,,,Sex\n" +
+			"6,,,,Sex,Male\n"
+		mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{})
+
+		Convey("Then the same rows are rejected, and the transform fails at the same row above the limit", func() {
+			for _, limit := range []int64{3, 2} {
+				serialOutput, serialStats, serialErr := transformWith(&transformer.Transformer{StrictValidation: true, RejectedRowLimit: limit}, input, mockClient, transformer.Options{})
+				output, stats, err := transformWith(&transformer.Transformer{StrictValidation: true, RejectedRowLimit: limit, RowWorkers: 2, BatchSize: 1}, input, mockClient, transformer.Options{})
+				So(err, ShouldResemble, serialErr)
+				So(output, ShouldEqual, serialOutput)
+				So(stats, ShouldResemble, serialStats)
+			}
+		})
+	})
+}
+
+// cancellingHierarchyClient cancels the context of the transform after a number of hierarchy entries have been looked up.
+type cancellingHierarchyClient struct {
+	hierarchy.HierarchyClient
+	cancel  context.CancelFunc
+	after   int64
+	lookups *int64
+}
+
+func (c cancellingHierarchyClient) GetHierarchyEntry(ctx context.Context, hierarchyId string, entryCode string) (*hierarchy.HierarchyEntry, error) {
+	if atomic.AddInt64(c.lookups, 1) == c.after {
+		c.cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.HierarchyClient.GetHierarchyEntry(ctx, hierarchyId, entryCode)
+}
+
+func TestCancelledTransform(t *testing.T) {
+
+	Convey("Given a transform that is cancelled part way through", t, func() {
+		input := scaleSample("Open-Data-v3.csv", 20)
+		mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{})
+
+		Convey("Then the serial and parallel transforms stop with the context's error, without writing every row", func() {
+			for _, p := range []*transformer.Transformer{{}, {RowWorkers: 2, BatchSize: 10}} {
+				ctx, cancel := context.WithCancel(context.Background())
+				hc := cancellingHierarchyClient{HierarchyClient: mockClient, cancel: cancel, after: 50, lookups: new(int64)}
+				stats, err := p.Transform(ctx, strings.NewReader(input), ioutil.Discard, hc, "test", transformer.Options{})
+				So(err, ShouldEqual, context.Canceled)
+				So(stats.RowsWritten, ShouldBeLessThan, 50)
+				So(len(stats.UnresolvedCodes), ShouldEqual, 0)
+			}
+		})
+	})
+}
+
+func benchmarkTransform(b *testing.B, p *transformer.Transformer) {
+	input := scaleSample("Open-Data-v3.csv", 200)
+	mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{})
+	options := transformer.Options{HierarchyColumns: true, TimePeriodColumns: true}
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Transform(context.Background(), strings.NewReader(input), ioutil.Discard, mockClient, "benchmark", options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransformSerial(b *testing.B) {
+	benchmarkTransform(b, &transformer.Transformer{})
+}
+
+func BenchmarkTransformParallel2(b *testing.B) {
+	benchmarkTransform(b, &transformer.Transformer{RowWorkers: 2})
+}
+
+func BenchmarkTransformParallel4(b *testing.B) {
+	benchmarkTransform(b, &transformer.Transformer{RowWorkers: 4})
+}
+
+func BenchmarkTransformParallel8(b *testing.B) {
+	benchmarkTransform(b, &transformer.Transformer{RowWorkers: 8})
+}
@@ -0,0 +1,94 @@
+package transformer
+
+import (
+	"io"
+)
+
+// Stats describes a transform. If the transform failed the stats describe the rows processed before it failed.
+type Stats struct {
+	RowsRead               int64            `json:"rowsRead"`
+	RowsWritten            int64            `json:"rowsWritten"`
+	RowsRejected           int64            `json:"rowsRejected,omitempty"`
+	Dimensions             int              `json:"dimensions"`
+	HierarchicalDimensions int              `json:"hierarchicalDimensions"`
+	HierarchyLookups       int64            `json:"hierarchyLookups"`
+	UnresolvedCodes        map[string]int64 `json:"unresolvedCodes,omitempty"`
+	UnparsedTimeCodes      int64            `json:"unparsedTimeCodes,omitempty"`
+	BytesIn                int64            `json:"bytesIn"`
+	BytesOut               int64            `json:"bytesOut"`
+	Timings                Timings          `json:"timings"`
+	unresolved             *unresolvedCodes
+	unparsed               *unresolvedCodes
+	rejected               *rejectedRows
+	policy                 UnresolvedCodePolicy
+}
+
+// Timings the time spent in each phase of a transform. RowsNs includes the time spent looking up hierarchy values.
+type Timings struct {
+	ReadHeaderNs int64 `json:"readHeaderNs"`
+	DimensionsNs int64 `json:"dimensionsNs"`
+	RowsNs       int64 `json:"rowsNs"`
+	TotalNs      int64 `json:"totalNs"`
+}
+
+func newStats(policy UnresolvedCodePolicy) *Stats {
+	return &Stats{UnresolvedCodes: make(map[string]int64), unresolved: newUnresolvedCodes(), unparsed: newUnresolvedCodes(), rejected: &rejectedRows{}, policy: policy}
+}
+
+// HasUnresolvedCodes returns true if any code could not be found in its hierarchy.
+func (s *Stats) HasUnresolvedCodes() bool {
+	return s.unresolvedTotal() > 0
+}
+
+// HasValidationIssues returns true if any code could not be found in its hierarchy, or any time code could not be
+// parsed, so there is a ValidationReport to save.
+func (s *Stats) HasValidationIssues() bool {
+	return s.HasUnresolvedCodes() || s.UnparsedTimeCodes > 0
+}
+
+// Summary returns a copy of the counts in the stats, without the unresolved codes, unparsed codes and rejected rows
+// collected for the validation report and quarantine file, which may be large. A nil Stats returns nil.
+func (s *Stats) Summary() *Stats {
+	if s == nil {
+		return nil
+	}
+	summary := *s
+	summary.UnresolvedCodes = make(map[string]int64, len(s.UnresolvedCodes))
+	for hierarchyId, count := range s.UnresolvedCodes {
+		summary.UnresolvedCodes[hierarchyId] = count
+	}
+	summary.unresolved, summary.unparsed, summary.rejected = nil, nil, nil
+	return &summary
+}
+
+func (s *Stats) unresolvedTotal() int64 {
+	var total int64
+	for _, count := range s.UnresolvedCodes {
+		total += count
+	}
+	return total
+}
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	r     io.Reader
+	count int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.count += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to the wrapped writer.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
@@ -1,63 +1,115 @@
 package transformer
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/ONSdigital/dp-dd-csv-transformer/config"
 	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
 	"github.com/ONSdigital/go-ns/log"
 	"time"
 )
 
-const (
-	DIMENSION_START_INDEX  = 3
-	HIERARCHY_ID_OFFSET    = 0
-	DIMENSION_NAME_OFFSET  = 1
-	DIMENSION_VALUE_OFFSET = 2
-)
-
-// CSVTransformer defines the CSVTransformer interface.
+// CSVTransformer defines the CSVTransformer interface. Stats are always returned, describing the rows processed before
+// any error. Errors from the reader and HierarchyClient are returned unchanged, so a retryable error (see
+// retry.Retryable) remains retryable; all other errors are permanent. The transform stops once the context is done,
+// returning the context's error.
 type CSVTransformer interface {
-	Transform(r io.Reader, w io.Writer, hc hierarchy.HierarchyClient, requestId string) error
+	Transform(ctx context.Context, r io.Reader, w io.Writer, hc hierarchy.HierarchyClient, requestId string, options Options) (*Stats, error)
+}
+
+// Options the options that can be set for each transform.
+type Options struct {
+	// HierarchyColumns determines whether the level, parent and ancestors of each code are output for dimensions with
+	// a hierarchy (other than a time hierarchy).
+	HierarchyColumns bool
+	// TimePeriodColumns determines whether the period type, start date and end date of each code are output for
+	// dimensions with a time hierarchy.
+	TimePeriodColumns bool
 }
 
 // Transformer implementation of the CSVTransformer interface.
-type Transformer struct{}
+type Transformer struct {
+	UnresolvedCodePolicy UnresolvedCodePolicy
+	HeaderAliases        HeaderAliases
+	// StrictValidation determines whether every row is validated (see validate), and whether an invalid row is
+	// quarantined (see Stats.WriteRejectedRows), rather than failing the transform.
+	StrictValidation bool
+	// RejectedRowLimit the number of rows that can be quarantined in strict mode before the transform fails.
+	RejectedRowLimit int64
+	// RowWorkers the number of workers transforming rows in parallel (see transformRowsInParallel). The rows are read
+	// and written serially if it is less than 2.
+	RowWorkers int
+	// BatchSize the number of rows passed to a worker at a time.
+	BatchSize int
+}
 
-// NewTransformer create a new Transformer.
+// NewTransformer create a new Transformer, configured by the UnresolvedCodePolicy, UnresolvedCodeThreshold,
+// HeaderAliases, StrictValidation, RejectedRowLimit, RowWorkers and BatchSize.
 func NewTransformer() *Transformer {
-	return &Transformer{}
+	policy, err := ParseUnresolvedCodePolicy(config.UnresolvedCodePolicy, config.UnresolvedCodeThreshold)
+	if err != nil {
+		panic(err)
+	}
+	aliases, err := ParseHeaderAliases(config.HeaderAliases)
+	if err != nil {
+		panic(err)
+	}
+	return &Transformer{
+		UnresolvedCodePolicy: policy,
+		HeaderAliases:        aliases,
+		StrictValidation:     config.StrictValidation,
+		RejectedRowLimit:     config.RejectedRowLimit,
+		RowWorkers:           config.RowWorkers,
+		BatchSize:            config.RowBatchSize,
+	}
 }
 
 type Dimension struct {
-	name           string
-	dimensionIndex int
-	columnIndex    int
-	isHierarchical bool
-	hierarchyType  string
-	hc             hierarchy.HierarchyClient
+	name              string
+	dimensionIndex    int
+	columns           dimensionColumns
+	isHierarchical    bool
+	hierarchyColumns  bool
+	timePeriodColumns bool
+	hierarchyId       string
+	hierarchyType     string
+	hc                hierarchy.HierarchyClient
+	// mutex guards the caches, which are shared by the workers transforming rows in parallel
+	mutex          sync.RWMutex
+	hierarchyTypes map[string]string
+	periods        map[unresolvedKey]parsedPeriod
 }
 
-// getDimensions parses the dimensions from an input csv row
-func getDimensions(row []string, hc hierarchy.HierarchyClient) ([]*Dimension, error) {
+// getDimensions parses the dimensions in the layout from the first input csv row. Every other row is checked against
+// them (see Dimension.check), as they determine the output columns.
+func getDimensions(ctx context.Context, l *layout, row []string, hc hierarchy.HierarchyClient, options Options) ([]*Dimension, error) {
 	var result []*Dimension
-	for i := DIMENSION_START_INDEX; i < len(row); i = i + 3 {
+	for _, columns := range l.dimensions {
 		var dim Dimension
-		hierarchyId := strings.TrimSpace(row[i+HIERARCHY_ID_OFFSET])
+		hierarchyId := strings.TrimSpace(row[columns.hierarchy])
 		dim.isHierarchical = len(hierarchyId) > 0
-		dim.name = strings.TrimSpace(row[i+DIMENSION_NAME_OFFSET])
-		dim.columnIndex = i
+		dim.name = strings.TrimSpace(row[columns.name])
+		dim.columns = columns
 		dim.dimensionIndex = len(result) + 1
 		dim.hc = hc
 		if dim.isHierarchical {
 			// check the type of hierarchy
-			hierarchy, err := hc.GetHierarchy(hierarchyId)
+			hierarchy, err := hc.GetHierarchy(ctx, hierarchyId)
 			if err != nil {
 				return nil, err
 			}
+			dim.hierarchyId = hierarchyId
 			dim.hierarchyType = hierarchy.Type
+			dim.hierarchyTypes = map[string]string{hierarchyId: hierarchy.Type}
+			dim.hierarchyColumns = options.HierarchyColumns && hierarchy.Type != "time"
+			dim.timePeriodColumns = options.TimePeriodColumns && hierarchy.Type == "time"
+			dim.periods = make(map[unresolvedKey]parsedPeriod)
 		}
 		result = append(result, &dim)
 	}
@@ -75,6 +127,18 @@ func (d *Dimension) getHeaders() []string {
 		if d.hierarchyType != "time" {
 			h = append(h, fmt.Sprintf("Dimension_%d_Value", d.dimensionIndex))
 		}
+		if d.timePeriodColumns {
+			h = append(h, fmt.Sprintf("Dimension_%d_Period_Type", d.dimensionIndex))
+			h = append(h, fmt.Sprintf("Dimension_%d_Period_Start", d.dimensionIndex))
+			h = append(h, fmt.Sprintf("Dimension_%d_Period_End", d.dimensionIndex))
+		}
+		if d.hierarchyColumns {
+			h = append(h, fmt.Sprintf("Dimension_%d_Level_Code", d.dimensionIndex))
+			h = append(h, fmt.Sprintf("Dimension_%d_Level_Name", d.dimensionIndex))
+			h = append(h, fmt.Sprintf("Dimension_%d_Level", d.dimensionIndex))
+			h = append(h, fmt.Sprintf("Dimension_%d_Parent_Code", d.dimensionIndex))
+			h = append(h, fmt.Sprintf("Dimension_%d_Ancestors", d.dimensionIndex))
+		}
 	} else {
 		h = append(h, fmt.Sprintf("Dimension_%d_Value", d.dimensionIndex))
 	}
@@ -83,72 +147,169 @@ func (d *Dimension) getHeaders() []string {
 
 // getValues returns for hierarchical dimensions:
 //   dimension name, hierarchy id, code, value (value is excluded for time hierarchies)
+//   followed, with time period columns, by period type, start date and end date (for time hierarchies)
+//   or, with hierarchy columns, by level code, level name, level, parent code and ancestor codes
 // for non-hierarchical dimensions:
 //   dimension name, value
-func (d *Dimension) getValues(row []string, requestId string) []string {
+// Hierarchy lookups, and codes that cannot be resolved or parsed, are recorded in the result of the row. An error is
+// returned if a hierarchy could not be looked up.
+func (d *Dimension) getValues(ctx context.Context, row []string, result *rowResult) ([]string, error) {
 	var v []string
 	v = append(v, d.name)
 	if d.isHierarchical {
-		v = append(v, row[d.columnIndex+HIERARCHY_ID_OFFSET])
-		v = append(v, row[d.columnIndex+DIMENSION_VALUE_OFFSET])
+		v = append(v, row[d.columns.hierarchy])
+		v = append(v, row[d.columns.value])
 		if d.hierarchyType != "time" {
-			v = append(v, d.getHierarchyValue(row, requestId))
+			entry, err := d.getHierarchyEntry(ctx, row, result)
+			if err != nil {
+				return nil, err
+			}
+			if entry != nil {
+				v = append(v, entry.Name)
+			} else {
+				v = append(v, "")
+			}
+			if d.hierarchyColumns {
+				v = append(v, getHierarchyColumns(entry)...)
+			}
+		} else if d.timePeriodColumns {
+			columns, err := d.getPeriodColumns(ctx, row, result)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, columns...)
 		}
 	} else {
-		v = append(v, row[d.columnIndex+DIMENSION_VALUE_OFFSET])
+		v = append(v, row[d.columns.value])
 	}
-	return v
+	return v, nil
 }
 
-// getHierarchyValue
-func (d *Dimension) getHierarchyValue(row []string, requestId string) string {
-	hierarchyId := row[d.columnIndex+HIERARCHY_ID_OFFSET]
-	code := row[d.columnIndex+DIMENSION_VALUE_OFFSET]
-	value, err := d.hc.GetHierarchyValue(hierarchyId, code)
-	if err != nil {
-		log.ErrorC(requestId, err, log.Data{"hierarchyId": hierarchyId, "code": code, "row": row})
-		return ""
+// getHierarchyEntry returns the entry for the code in the row from its hierarchy. A code that cannot be found is
+// recorded in the result of the row, and a nil entry returned. Any other error, e.g. failing to get the hierarchy once
+// it has been evicted from the cache, is returned unchanged, as the code may not be missing.
+func (d *Dimension) getHierarchyEntry(ctx context.Context, row []string, result *rowResult) (*hierarchy.HierarchyEntry, error) {
+	hierarchyId := row[d.columns.hierarchy]
+	code := row[d.columns.value]
+	result.lookups++
+	entry, err := d.hc.GetHierarchyEntry(ctx, hierarchyId, code)
+	if _, ok := err.(hierarchy.EntryNotFoundError); ok {
+		result.issues = append(result.issues, codeIssue{hierarchyId: hierarchyId, code: code, lookups: result.lookups, err: err})
+		return nil, nil
 	}
-	return value
+	return entry, err
 }
 
-func (p *Transformer) Transform(r io.Reader, w io.Writer, hc hierarchy.HierarchyClient, requestId string) error {
+// getHierarchyColumns returns the level code, level name, level, parent code and ancestor codes (from the top of the
+// hierarchy, separated by /) of the entry. The values are blank for an entry that could not be found.
+func getHierarchyColumns(entry *hierarchy.HierarchyEntry) []string {
+	v := make([]string, 5)
+	if entry == nil {
+		return v
+	}
+	if entry.LevelType != nil {
+		v[0], v[1], v[2] = entry.LevelType.Code, entry.LevelType.Name, strconv.Itoa(entry.LevelType.Level)
+	}
+	if entry.Parent != nil {
+		v[3] = entry.Parent.Code
+	}
+	var ancestors []string
+	for _, ancestor := range entry.Ancestors() {
+		ancestors = append(ancestors, ancestor.Code)
+	}
+	v[4] = strings.Join(ancestors, "/")
+	return v
+}
+
+func (p *Transformer) Transform(ctx context.Context, r io.Reader, w io.Writer, hc hierarchy.HierarchyClient, requestId string, options Options) (stats *Stats, err error) {
 
-	lineCounter := 0
+	stats = newStats(p.UnresolvedCodePolicy)
 	startTime := time.Now()
+	phaseStart := startTime
+	// endPhase returns the duration of the current phase, and starts the next
+	endPhase := func() int64 {
+		now := time.Now()
+		d := now.Sub(phaseStart).Nanoseconds()
+		phaseStart = now
+		return d
+	}
+
+	in, out := &countingReader{r: r}, &countingWriter{w: w}
+	csvReader, csvWriter := csv.NewReader(in), csv.NewWriter(out)
+	// the number of columns in each row is checked by validate, so a row with the wrong number can be rejected
+	csvReader.FieldsPerRecord = -1
 	defer func() {
-		endTime := time.Now()
-		log.DebugC(requestId, fmt.Sprintf("Transform, duration_ns: %d", endTime.Sub(startTime).Nanoseconds()), log.Data{})
+		csvWriter.Flush()
+		if err == nil {
+			err = csvWriter.Error()
+		}
+		if err != nil && ctx.Err() != nil {
+			// e.g. reading the input failed because the download was abandoned
+			err = ctx.Err()
+		}
+		stats.BytesIn, stats.BytesOut = in.count, out.count
+		stats.Timings.TotalNs = time.Since(startTime).Nanoseconds()
+		log.DebugC(requestId, fmt.Sprintf("Transform, duration_ns: %d", stats.Timings.TotalNs), log.Data{"stats": stats})
 	}()
 
-	csvReader, csvWriter := csv.NewReader(r), csv.NewWriter(w)
-	defer csvWriter.Flush()
-
-
-	// ignore the headers in the first line
+	// map the columns from the headers in the first line
 	originalHeaders, err := csvReader.Read()
 	if err != nil {
 		log.ErrorC(requestId, err, log.Data{"message": "Unable to read header row"})
-		return err
-	}
-
-	// read the first row
-	row, err := csvReader.Read()
-	if err == io.EOF {
-		// no content - write the header row and quit
-		csvWriter.Write(originalHeaders)
-		return nil
+		return stats, err
 	}
+	layout, err := parseLayout(originalHeaders, p.HeaderAliases)
 	if err != nil {
-		log.ErrorC(requestId, err, log.Data{"message": "Unable to read first row"})
-		return err
+		log.ErrorC(requestId, err, log.Data{"headers": originalHeaders})
+		return stats, err
+	}
+
+	stats.rejected.headers = originalHeaders
+
+	// read the first row, skipping any rows rejected in strict mode
+	rowIndex := 1
+	var row []string
+	for row == nil {
+		rowIndex++
+		row, err = csvReader.Read()
+		if err == io.EOF {
+			// no content - write the header row and quit
+			csvWriter.Write(originalHeaders)
+			stats.Timings.ReadHeaderNs = endPhase()
+			return stats, nil
+		}
+		if err != nil {
+			log.ErrorC(requestId, err, log.Data{"message": "Unable to read first row"})
+			return stats, err
+		}
+		if err = p.validate(layout, row, int64(rowIndex)); err != nil {
+			stats.RowsRead++
+			if err = p.reject(stats, row, err); err != nil {
+				log.ErrorC(requestId, err, log.Data{"message": "Invalid row", "row": rowIndex})
+				return stats, err
+			}
+			row = nil
+		}
 	}
+	stats.Timings.ReadHeaderNs = endPhase()
+
 	// identify the dimensions
-	dimensions, err := getDimensions(row, hc)
+	dimensions, err := getDimensions(ctx, layout, row, hc, options)
 	if err != nil {
 		log.ErrorC(requestId, err, log.Data{"message": "Unable to get dimensions"})
-		return err
+		return stats, err
 	}
+	stats.Dimensions = len(dimensions)
+	for _, dim := range dimensions {
+		if dim.isHierarchical {
+			stats.HierarchicalDimensions++
+		}
+	}
+	stats.Timings.DimensionsNs = endPhase()
+	defer func() {
+		stats.Timings.RowsNs = endPhase()
+	}()
+
 	// write the headers
 	var headers []string
 	headers = append(headers, "Observation")
@@ -157,33 +318,153 @@ func (p *Transformer) Transform(r io.Reader, w io.Writer, hc hierarchy.Hierarchy
 	for _, dim := range dimensions {
 		headers = append(headers, dim.getHeaders()...)
 	}
-	csvWriter.Write(headers)
+	headers = append(headers, layout.passthroughHeaders()...)
+	if err = csvWriter.Write(headers); err != nil {
+		log.ErrorC(requestId, err, log.Data{"message": "Failed to write headers"})
+		return stats, err
+	}
 
 	// write each row
-	rowIndex := 2
-csvLoop:
+	if p.RowWorkers > 1 {
+		err = p.transformRowsInParallel(ctx, csvReader, csvWriter, layout, dimensions, row, int64(rowIndex), stats, requestId)
+	} else {
+		err = p.transformRows(ctx, csvReader, csvWriter, layout, dimensions, row, int64(rowIndex), stats, requestId)
+	}
+	if err != nil {
+		return stats, err
+	}
+	if p.UnresolvedCodePolicy.exceeded(stats.unresolvedTotal(), stats.HierarchyLookups, true) {
+		err = UnresolvedThresholdError{stats.unresolvedTotal(), stats.HierarchyLookups, p.UnresolvedCodePolicy}
+		log.ErrorC(requestId, err, nil)
+		return stats, err
+	}
+	return stats, nil
+}
+
+// transformRows transforms and writes each row, starting with the first row (which has already been read), until the
+// context is done.
+func (p *Transformer) transformRows(ctx context.Context, csvReader *csv.Reader, csvWriter *csv.Writer, l *layout, dimensions []*Dimension, row []string, rowNumber int64, stats *Stats, requestId string) error {
 	for {
-		// write the row
-		var output []string
-		output = append(output, row[:3]...)
-		for _, dim := range dimensions {
-			output = append(output, dim.getValues(row, requestId)...)
+		result := p.transformRow(ctx, l, dimensions, row, rowNumber)
+		if err := p.checkContext(ctx, rowNumber, requestId); err != nil {
+			return err
+		}
+		if err := p.apply(result, csvWriter, stats, requestId); err != nil {
+			return err
 		}
-		csvWriter.Write(output)
 		// get the next row
-		rowIndex++
+		rowNumber++
+		var err error
 		row, err = csvReader.Read()
+		if err == io.EOF {
+			log.DebugC(requestId, "Finished transformation", log.Data{"rowsProcessed": rowNumber - 1})
+			return nil
+		}
 		if err != nil {
-			if err == io.EOF {
-				log.DebugC(requestId, "Finished transformation", log.Data{"rowsProcessed": rowIndex - 1})
-				break csvLoop
-			} else {
-				log.ErrorC(requestId, err, log.Data{"message": fmt.Sprintf("Unable to read row %d", rowIndex)})
-				return err
-			}
+			log.ErrorC(requestId, err, log.Data{"message": fmt.Sprintf("Unable to read row %d", rowNumber)})
+			return err
 		}
+	}
+}
+
+// codeIssue a code in a row that could not be found in its hierarchy or, if unparsed, parsed as a time period.
+type codeIssue struct {
+	unparsed    bool
+	hierarchyId string
+	code        string
+	// lookups the number of hierarchy lookups made for the row, up to and including this one
+	lookups int64
+	err     error
+}
 
-		lineCounter++
+// rowResult the result of transforming a row: either the output, or the error that rejected the row. A rowResult only
+// depends on the row, so rows can be transformed in any order, but the results must be applied in row order.
+type rowResult struct {
+	rowNumber int64
+	row       []string
+	output    []string
+	err       error
+	lookups   int64
+	issues    []codeIssue
+}
+
+// transformRow validates the row and returns its output. It does not update the stats, so is safe to call from
+// multiple goroutines.
+func (p *Transformer) transformRow(ctx context.Context, l *layout, dimensions []*Dimension, row []string, rowNumber int64) rowResult {
+	result := rowResult{rowNumber: rowNumber, row: row}
+	result.err = p.validate(l, row, rowNumber)
+	for i := 0; result.err == nil && i < len(dimensions); i++ {
+		result.err = dimensions[i].check(ctx, row, rowNumber)
+	}
+	if result.err != nil {
+		return result
+	}
+	output := l.observationValues(row)
+	for _, dim := range dimensions {
+		values, err := dim.getValues(ctx, row, &result)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		output = append(output, values...)
+	}
+	result.output = append(output, l.passthroughValues(row)...)
+	return result
+}
+
+// checkContext returns the error of the context if it is done, in which case the result of the row must not be applied:
+// a hierarchy lookup abandoned because of the context would be taken for an unresolved code.
+func (p *Transformer) checkContext(ctx context.Context, rowNumber int64, requestId string) error {
+	err := ctx.Err()
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"message": "Transform stopped", "row": rowNumber})
+	}
+	return err
+}
+
+// apply writes the output of a row and adds it to the stats, returning an error if the row was rejected (and not
+// quarantined in strict mode), a code could not be found in its hierarchy and the UnresolvedCodePolicy is to fail, or
+// the output could not be written. Unresolved and unparsed codes are logged the first time they are found.
+func (p *Transformer) apply(result rowResult, csvWriter *csv.Writer, stats *Stats, requestId string) error {
+	stats.RowsRead++
+	if result.err != nil {
+		if err := p.reject(stats, result.row, result.err); err != nil {
+			log.ErrorC(requestId, err, log.Data{"message": "Invalid row", "row": result.rowNumber})
+			return err
+		}
+		return nil
+	}
+	lookups := stats.HierarchyLookups
+	for _, issue := range result.issues {
+		if issue.unparsed {
+			stats.UnparsedTimeCodes++
+			if stats.unparsed.add(issue.hierarchyId, issue.code, result.rowNumber) {
+				log.ErrorC(requestId, issue.err, log.Data{"hierarchyId": issue.hierarchyId, "code": issue.code, "row": result.rowNumber})
+			}
+			continue
+		}
+		stats.HierarchyLookups = lookups + issue.lookups
+		stats.UnresolvedCodes[issue.hierarchyId]++
+		if stats.unresolved.add(issue.hierarchyId, issue.code, result.rowNumber) {
+			log.ErrorC(requestId, issue.err, log.Data{"hierarchyId": issue.hierarchyId, "code": issue.code, "row": result.rowNumber})
+		}
+		var err error
+		if p.UnresolvedCodePolicy.Action == PolicyFail {
+			err = UnresolvedCodeError{issue.hierarchyId, issue.code, result.rowNumber}
+		} else if p.UnresolvedCodePolicy.exceeded(stats.unresolvedTotal(), stats.HierarchyLookups, false) {
+			err = UnresolvedThresholdError{stats.unresolvedTotal(), stats.HierarchyLookups, p.UnresolvedCodePolicy}
+		}
+		if err != nil {
+			log.ErrorC(requestId, err, log.Data{"message": "Failed to transform row", "row": result.rowNumber})
+			return err
+		}
+	}
+	stats.HierarchyLookups = lookups + result.lookups
+	if err := csvWriter.Write(result.output); err != nil {
+		// e.g. the upload of the output has failed, so there is no point transforming the rest of the rows
+		log.ErrorC(requestId, err, log.Data{"message": "Failed to write row", "row": result.rowNumber})
+		return err
 	}
+	stats.RowsWritten++
 	return nil
 }
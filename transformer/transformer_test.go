@@ -2,8 +2,14 @@ package transformer_test
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"encoding/csv"
@@ -11,6 +17,7 @@ import (
 	"io"
 
 	"github.com/ONSdigital/dp-dd-csv-transformer/hierarchy"
+	"github.com/ONSdigital/dp-dd-csv-transformer/retry"
 	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -19,6 +26,7 @@ type mockHierarchyClient struct {
 	timeHierarchies  map[string]bool
 	errorHierarchies map[string]bool
 	errorCodes       map[string]bool
+	hierarchyErr     error
 }
 
 func createMockHierarchyClient(timeHierarchies []string, errorHierarchies []string, errorCodes []string) mockHierarchyClient {
@@ -38,8 +46,11 @@ func createMockHierarchyClient(timeHierarchies []string, errorHierarchies []stri
 	return client
 }
 
-func (c mockHierarchyClient) GetHierarchy(hierarchyId string) (*hierarchy.Hierarchy, error) {
+func (c mockHierarchyClient) GetHierarchy(ctx context.Context, hierarchyId string) (*hierarchy.Hierarchy, error) {
 	if c.errorHierarchies[hierarchyId] {
+		if c.hierarchyErr != nil {
+			return nil, c.hierarchyErr
+		}
 		return nil, errors.New("Error getting hierarchy")
 	}
 	var h hierarchy.Hierarchy
@@ -52,11 +63,82 @@ func (c mockHierarchyClient) GetHierarchy(hierarchyId string) (*hierarchy.Hierar
 	return &h, nil
 }
 
-func (c mockHierarchyClient) GetHierarchyValue(hierarchyId string, entryCode string) (string, error) {
+func (c mockHierarchyClient) GetHierarchyValue(ctx context.Context, hierarchyId string, entryCode string) (string, error) {
+	entry, err := c.GetHierarchyEntry(ctx, hierarchyId, entryCode)
+	if err != nil {
+		return "", err
+	}
+	return entry.Name, nil
+}
+
+func (c mockHierarchyClient) GetHierarchyEntry(ctx context.Context, hierarchyId string, entryCode string) (*hierarchy.HierarchyEntry, error) {
 	if c.errorCodes[entryCode] {
-		return "", errors.New("Error getting entry")
+		return nil, hierarchy.EntryNotFoundError{HierarchyID: hierarchyId, Code: entryCode}
+	}
+	return &hierarchy.HierarchyEntry{Code: entryCode, Name: "Value for " + entryCode}, nil
+}
+
+// flakyHierarchyClient fails every hierarchy entry lookup after the first few with a retryable server error, as if the
+// hierarchy had been evicted from the cache and could not be fetched again.
+type flakyHierarchyClient struct {
+	mockHierarchyClient
+	failAfter int64
+	lookups   *int64
+}
+
+func (c flakyHierarchyClient) GetHierarchyEntry(ctx context.Context, hierarchyId string, entryCode string) (*hierarchy.HierarchyEntry, error) {
+	if atomic.AddInt64(c.lookups, 1) > c.failAfter {
+		return nil, hierarchy.ServerError{HierarchyID: hierarchyId, StatusCode: 503}
 	}
-	return "Value for " + entryCode, nil
+	return c.mockHierarchyClient.GetHierarchyEntry(ctx, hierarchyId, entryCode)
+}
+
+// failingWriter fails every write, as the output does once its upload has failed.
+type failingWriter struct {
+	writes *int
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	*w.writes++
+	return 0, errors.New("AccessDenied")
+}
+
+func TestOutputWriteFailure(t *testing.T) {
+
+	Convey("Given an output that cannot be written", t, func() {
+		input := scaleSample("Open-Data-v3.csv", 20)
+		client := createMockHierarchyClient([]string{"time"}, []string{}, []string{})
+
+		Convey("Then the transform stops at the first failed write, rather than reading the whole input", func() {
+			for _, p := range []*transformer.Transformer{{}, {RowWorkers: 2, BatchSize: 3}} {
+				writes := 0
+				stats, err := p.Transform(context.Background(), strings.NewReader(input), failingWriter{&writes}, client, "test", transformer.Options{})
+				So(err, ShouldResemble, errors.New("AccessDenied"))
+				So(writes, ShouldEqual, 1)
+				So(stats.RowsRead, ShouldBeLessThan, 200)
+			}
+		})
+	})
+}
+
+func TestHierarchyLookupFailure(t *testing.T) {
+
+	Convey("Given a hierarchy that cannot be fetched part way through a transform", t, func() {
+		input := scaleSample("Open-Data-v3.csv", 2)
+
+		Convey("Then the transform fails with the retryable error, rather than recording unresolved codes", func() {
+			for _, p := range []*transformer.Transformer{{}, {RowWorkers: 2, BatchSize: 3}} {
+				for _, options := range []transformer.Options{{}, {TimePeriodColumns: true}} {
+					client := flakyHierarchyClient{createMockHierarchyClient([]string{"time"}, []string{}, []string{}), 10, new(int64)}
+					_, stats, err := transformWith(p, input, client, options)
+					So(err, ShouldHaveSameTypeAs, hierarchy.ServerError{})
+					So(retry.IsRetryable(err), ShouldBeTrue)
+					So(len(stats.UnresolvedCodes), ShouldEqual, 0)
+					So(stats.RowsWritten, ShouldBeLessThan, 10)
+				}
+			}
+		})
+	})
 }
 
 func TestProcessor(t *testing.T) {
@@ -69,7 +151,7 @@ func TestProcessor(t *testing.T) {
 			mockClient := createMockHierarchyClient([]string{}, []string{}, []string{})
 			inputFile := openFile("../sample_csv/AF001EW_v3_small.csv", "Error loading input file. Does it exist? ")
 			outputFile := createFileInBuildDir("transformed-1.csv", "Error creating output file.")
-			err := Processor.Transform(inputFile, outputFile, mockClient, "test")
+			_, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
 			So(err, ShouldBeNil)
 			rows, columns := countLinesAndColumnsInFile(outputFile.Name())
 			So(rows, ShouldEqual, 13)
@@ -77,11 +159,40 @@ func TestProcessor(t *testing.T) {
 			So(columns, ShouldEqual, 13)
 		})
 
+		Convey("Should return the stats of the transform", func() {
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			inputInfo, _ := inputFile.Stat()
+			outputFile := createFileInBuildDir("transformed-stats.csv", "Error creating output file.")
+			stats, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(err, ShouldBeNil)
+			outputInfo, _ := outputFile.Stat()
+			So(stats.RowsRead, ShouldEqual, 276)
+			So(stats.RowsWritten, ShouldEqual, 276)
+			So(stats.Dimensions, ShouldEqual, 4)
+			So(stats.HierarchicalDimensions, ShouldEqual, 4)
+			// one lookup per row for each of the 3 hierarchies that are not time hierarchies
+			So(stats.HierarchyLookups, ShouldEqual, 3*276)
+			So(stats.UnresolvedCodes, ShouldResemble, map[string]int64{"2011STATH": 276})
+			So(stats.BytesIn, ShouldEqual, inputInfo.Size())
+			So(stats.BytesOut, ShouldEqual, outputInfo.Size())
+			So(stats.Timings.TotalNs, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Should return the stats of the rows processed before an error", func() {
+			mockClient := createMockHierarchyClient([]string{}, []string{"time"}, []string{})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-stats-error.csv", "Error creating output file.")
+			stats, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(err, ShouldNotBeNil)
+			So(stats.RowsWritten, ShouldEqual, 0)
+		})
+
 		Convey("When all hierarchies are found in Open-Data-v3", func() {
 			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{})
 			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
 			outputFile := createFileInBuildDir("transformed-2.csv", "Error creating output file.")
-			err := Processor.Transform(inputFile, outputFile, mockClient, "test")
+			_, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
 			So(err, ShouldBeNil)
 			rows, columns := countLinesAndColumnsInFile(outputFile.Name())
 			So(rows, ShouldEqual, 277)
@@ -93,15 +204,24 @@ func TestProcessor(t *testing.T) {
 			mockClient := createMockHierarchyClient([]string{}, []string{"time"}, []string{})
 			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
 			outputFile := createFileInBuildDir("transformed-3.csv", "Error creating output file.")
-			err := Processor.Transform(inputFile, outputFile, mockClient, "test")
+			_, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
 			So(err, ShouldNotBeNil)
 		})
 
+		Convey("Should return a retryable error unchanged if a hierarchy cannot be found", func() {
+			mockClient := createMockHierarchyClient([]string{}, []string{"time"}, []string{})
+			mockClient.hierarchyErr = retry.NewRetryableError(errors.New("Connection refused"))
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-3a.csv", "Error creating output file.")
+			_, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(retry.IsRetryable(err), ShouldBeTrue)
+		})
+
 		Convey("Should log and continue processing if a hierarchy entry cannot be found", func() {
 			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
 			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
 			outputFile := createFileInBuildDir("transformed-4.csv", "Error creating output file.")
-			err := Processor.Transform(inputFile, outputFile, mockClient, "test")
+			_, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
 			So(err, ShouldBeNil)
 			rows, columns := countLinesAndColumnsInFile(outputFile.Name())
 			So(rows, ShouldEqual, 277)
@@ -109,11 +229,132 @@ func TestProcessor(t *testing.T) {
 			So(columns, ShouldEqual, 18)
 		})
 
+		Convey("Should report each unresolved code once with its occurrences and sample rows", func() {
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-unresolved.csv", "Error creating output file.")
+			stats, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(err, ShouldBeNil)
+			report := stats.ValidationReport()
+			So(report.Unresolved, ShouldEqual, 276)
+			So(len(report.UnresolvedCodes), ShouldEqual, 1)
+			So(report.UnresolvedCodes[0].HierarchyID, ShouldEqual, "2011STATH")
+			So(report.UnresolvedCodes[0].Code, ShouldEqual, "K04000001")
+			So(report.UnresolvedCodes[0].Occurrences, ShouldEqual, 276)
+			So(report.UnresolvedCodes[0].SampleRows[:3], ShouldResemble, []int64{2, 3, 4})
+		})
+
+		Convey("Should fail on the first unresolved code with the fail policy", func() {
+			failProcessor := &transformer.Transformer{UnresolvedCodePolicy: transformer.UnresolvedCodePolicy{Action: transformer.PolicyFail}}
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-unresolved-fail.csv", "Error creating output file.")
+			stats, err := failProcessor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(err, ShouldResemble, transformer.UnresolvedCodeError{HierarchyID: "2011STATH", Code: "K04000001", Row: 2})
+			So(stats.RowsWritten, ShouldEqual, 0)
+		})
+
+		Convey("Should fail if the unresolved codes are above a percentage threshold", func() {
+			policy, _ := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "30%")
+			thresholdProcessor := &transformer.Transformer{UnresolvedCodePolicy: policy}
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-unresolved-threshold.csv", "Error creating output file.")
+			stats, err := thresholdProcessor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			// 276 of 828 lookups (33%) are unresolved
+			So(err, ShouldResemble, transformer.UnresolvedThresholdError{Unresolved: 276, Lookups: 828, Policy: policy})
+			So(stats.RowsWritten, ShouldEqual, 276)
+		})
+
+		Convey("Should not fail if the unresolved codes are within a percentage threshold", func() {
+			policy, _ := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "34%")
+			thresholdProcessor := &transformer.Transformer{UnresolvedCodePolicy: policy}
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-unresolved-within-threshold.csv", "Error creating output file.")
+			_, err := thresholdProcessor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Should fail as soon as the unresolved codes are above a count threshold", func() {
+			policy, _ := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "10")
+			thresholdProcessor := &transformer.Transformer{UnresolvedCodePolicy: policy}
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
+			inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
+			outputFile := createFileInBuildDir("transformed-unresolved-count.csv", "Error creating output file.")
+			stats, err := thresholdProcessor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
+			So(err, ShouldNotBeNil)
+			So(stats.RowsWritten, ShouldEqual, 10)
+		})
+
+		Convey("Should output the level, parent and ancestors of each code with hierarchy columns", func() {
+			dir, err := ioutil.TempDir("", "hierarchies")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "TESTGEOG.json"), []byte(`{"id": "TESTGEOG", "type": "geography", "options": [
+				{"code": "K04000001", "name": "England and Wales", "levelType": {"code": "CTRY", "name": "Country", "level": 0}, "options": [
+					{"code": "E92000001", "name": "England", "levelType": {"code": "NAT", "name": "Nation", "level": 1}, "options": [
+						{"code": "E12000001", "name": "North East", "levelType": {"code": "RGN", "name": "Region", "level": 2}}
+					]}
+				]}
+			]}`), 0644), ShouldBeNil)
+			input := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1\n" +
+				"1,,,TESTGEOG,Geography,E12000001\n" +
+				"2,,,TESTGEOG,Geography,K04000001\n" +
+				"3,,,TESTGEOG,Geography,unknown\n"
+			var output bytes.Buffer
+			_, err = Processor.Transform(context.Background(), strings.NewReader(input), &output, hierarchy.NewHierarchyClientForSource("file://"+dir), "test", transformer.Options{HierarchyColumns: true})
+			So(err, ShouldBeNil)
+			So(output.String(), ShouldEqual, "Observation,Data_Marking,Observation_Type_Value,Dimension_1_Name,Dimension_1_Hierarchy,Dimension_1_Code,Dimension_1_Value,"+
+				"Dimension_1_Level_Code,Dimension_1_Level_Name,Dimension_1_Level,Dimension_1_Parent_Code,Dimension_1_Ancestors\n"+
+				"1,,,Geography,TESTGEOG,E12000001,North East,RGN,Region,2,E92000001,K04000001/E92000001\n"+
+				"2,,,Geography,TESTGEOG,K04000001,England and Wales,CTRY,Country,0,,\n"+
+				"3,,,Geography,TESTGEOG,unknown,,,,,,\n")
+		})
+
+		Convey("Should output the period of each time code with time period columns", func() {
+			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{})
+			input := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1\n" +
+				"1,,,time,Time,2014 Q2\n" +
+				"2,,,time,Time,Spring 2014\n" +
+				"3,,,time,Time,Spring 2014\n"
+			var output bytes.Buffer
+			stats, err := Processor.Transform(context.Background(), strings.NewReader(input), &output, mockClient, "test", transformer.Options{TimePeriodColumns: true})
+			So(err, ShouldBeNil)
+			So(output.String(), ShouldEqual, "Observation,Data_Marking,Observation_Type_Value,Dimension_1_Name,Dimension_1_Hierarchy,Dimension_1_Code,"+
+				"Dimension_1_Period_Type,Dimension_1_Period_Start,Dimension_1_Period_End\n"+
+				"1,,,Time,time,2014 Q2,quarter,2014-04-01,2014-06-30\n"+
+				"2,,,Time,time,Spring 2014,,,\n"+
+				"3,,,Time,time,Spring 2014,,,\n")
+			So(stats.UnparsedTimeCodes, ShouldEqual, 2)
+			So(stats.HasValidationIssues(), ShouldBeTrue)
+			So(stats.ValidationReport().UnparsedTimeCodes, ShouldResemble, []transformer.UnresolvedCode{
+				{HierarchyID: "time", Code: "Spring 2014", Occurrences: 2, SampleRows: []int64{3, 4}},
+			})
+		})
+
+		Convey("Should use the level of a time code in its hierarchy to parse its period", func() {
+			dir, err := ioutil.TempDir("", "hierarchies")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "TESTTIME.json"), []byte(`{"id": "TESTTIME", "type": "time", "options": [
+				{"code": "2011-12", "name": "2011-12", "levelType": {"code": "FY", "name": "Financial Year", "level": 0}}
+			]}`), 0644), ShouldBeNil)
+			input := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1\n" +
+				"1,,,TESTTIME,Time,2011-12\n" +
+				"2,,,TESTTIME,Time,2012-01\n"
+			var output bytes.Buffer
+			_, err = Processor.Transform(context.Background(), strings.NewReader(input), &output, hierarchy.NewHierarchyClientForSource("file://"+dir), "test", transformer.Options{TimePeriodColumns: true})
+			So(err, ShouldBeNil)
+			So(output.String(), ShouldEndWith, "1,,,Time,TESTTIME,2011-12,financial-year,2011-04-01,2012-03-31\n"+
+				"2,,,Time,TESTTIME,2012-01,month,2012-01-01,2012-01-31\n")
+		})
+
 		Convey("Should handle a file containing only headers", func() {
 			mockClient := createMockHierarchyClient([]string{"time"}, []string{}, []string{"K04000001"})
 			inputFile := openFile("../sample_csv/AF001EW_v3_headers_only.csv", "Error loading input file. Does it exist? ")
 			outputFile := createFileInBuildDir("transformed-5.csv", "Error creating output file.")
-			err := Processor.Transform(inputFile, outputFile, mockClient, "test")
+			_, err := Processor.Transform(context.Background(), inputFile, outputFile, mockClient, "test", transformer.Options{})
 			So(err, ShouldBeNil)
 			rows, _ := countLinesAndColumnsInFile(outputFile.Name())
 			So(rows, ShouldEqual, 1)
@@ -176,10 +417,10 @@ func main() {
 
 	inputFile := openFile("../sample_csv/Open-Data-v3.csv", "Error loading input file. Does it exist? ")
 	outputFile := createFileInBuildDir("transformed-Open-Data-1.csv", "Error creating output file.")
-	Processor.Transform(inputFile, outputFile, hierarchy.NewHierarchyClient(), "test")
+	Processor.Transform(context.Background(), inputFile, outputFile, hierarchy.NewHierarchyClient(), "test", transformer.Options{})
 
 	inputFile = openFile("../sample_csv/AF001EW_v3_small.csv", "Error loading input file. Does it exist? ")
 	outputFile = createFileInBuildDir("transformed-AF001EW_v3_small_1.csv", "Error creating output file.")
-	Processor.Transform(inputFile, outputFile, hierarchy.NewHierarchyClient(), "test")
+	Processor.Transform(context.Background(), inputFile, outputFile, hierarchy.NewHierarchyClient(), "test", transformer.Options{})
 
 }
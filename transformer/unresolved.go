@@ -0,0 +1,212 @@
+package transformer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The actions of an UnresolvedCodePolicy.
+const (
+	PolicyBlank              = "blank"
+	PolicyFail               = "fail"
+	PolicyFailAboveThreshold = "fail-above-threshold"
+)
+
+// maxSampleRows the maximum number of row numbers recorded for each unresolved code.
+const maxSampleRows = 10
+
+// maxReportedCodes the maximum number of distinct unresolved codes recorded for the validation report. The counts per
+// hierarchy in Stats.UnresolvedCodes include every code.
+const maxReportedCodes = 10000
+
+// UnresolvedCodePolicy determines what happens when a code cannot be found in its hierarchy. With PolicyBlank the
+// value is left blank; with PolicyFail the transform fails; with PolicyFailAboveThreshold the transform fails if the
+// number of unresolved codes is more than Threshold, or more than Threshold percent of the hierarchy lookups if
+// ThresholdIsPercent.
+type UnresolvedCodePolicy struct {
+	Action             string  `json:"action"`
+	Threshold          float64 `json:"threshold,omitempty"`
+	ThresholdIsPercent bool    `json:"thresholdIsPercent,omitempty"`
+}
+
+// ParseUnresolvedCodePolicy parses the policy action and, for PolicyFailAboveThreshold, the threshold: a count (e.g.
+// "100") or a percentage of the hierarchy lookups (e.g. "0.5%").
+func ParseUnresolvedCodePolicy(action string, threshold string) (UnresolvedCodePolicy, error) {
+	policy := UnresolvedCodePolicy{Action: action}
+	switch action {
+	case PolicyBlank, PolicyFail:
+		return policy, nil
+	case PolicyFailAboveThreshold:
+		policy.ThresholdIsPercent = strings.HasSuffix(threshold, "%")
+		var err error
+		policy.Threshold, err = strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || policy.Threshold < 0 {
+			return policy, fmt.Errorf("Invalid unresolved code threshold '%s' (expected a count or a percentage)", threshold)
+		}
+		return policy, nil
+	}
+	return policy, fmt.Errorf("Invalid unresolved code policy '%s' (expected %s, %s or %s)", action, PolicyBlank, PolicyFail, PolicyFailAboveThreshold)
+}
+
+// exceeded returns true if the number of unresolved codes is above the threshold. A count threshold is checked as the
+// rows are processed, a percentage only once every row has been processed.
+func (p UnresolvedCodePolicy) exceeded(unresolved int64, lookups int64, complete bool) bool {
+	if p.Action != PolicyFailAboveThreshold {
+		return false
+	}
+	if !p.ThresholdIsPercent {
+		return float64(unresolved) > p.Threshold
+	}
+	return complete && lookups > 0 && float64(unresolved)*100/float64(lookups) > p.Threshold
+}
+
+// UnresolvedCodeError is returned when a code cannot be found in its hierarchy and the policy is PolicyFail.
+type UnresolvedCodeError struct {
+	HierarchyID string
+	Code        string
+	Row         int64
+}
+
+func (e UnresolvedCodeError) Error() string {
+	return fmt.Sprintf("Code %s not found in hierarchy %s (row %d)", e.Code, e.HierarchyID, e.Row)
+}
+
+// UnresolvedThresholdError is returned when more codes could not be found than allowed by a PolicyFailAboveThreshold.
+type UnresolvedThresholdError struct {
+	Unresolved int64
+	Lookups    int64
+	Policy     UnresolvedCodePolicy
+}
+
+func (e UnresolvedThresholdError) Error() string {
+	threshold := strconv.FormatFloat(e.Policy.Threshold, 'f', -1, 64)
+	if e.Policy.ThresholdIsPercent {
+		threshold += "%"
+	}
+	return fmt.Sprintf("%d of %d codes not found in their hierarchies, above the threshold of %s", e.Unresolved, e.Lookups, threshold)
+}
+
+// UnresolvedCode a code that could not be found in its hierarchy, with the number of times it occurred and the first
+// rows it occurred in.
+type UnresolvedCode struct {
+	HierarchyID string  `json:"hierarchyId"`
+	Code        string  `json:"code"`
+	Occurrences int64   `json:"occurrences"`
+	SampleRows  []int64 `json:"sampleRows"`
+}
+
+type unresolvedKey struct {
+	hierarchyID string
+	code        string
+}
+
+// unresolvedCodes collects the distinct unresolved codes.
+type unresolvedCodes struct {
+	codes     map[unresolvedKey]*UnresolvedCode
+	truncated bool
+}
+
+func newUnresolvedCodes() *unresolvedCodes {
+	return &unresolvedCodes{codes: make(map[unresolvedKey]*UnresolvedCode)}
+}
+
+// add records an occurrence of the code, returning true if it is the first.
+func (u *unresolvedCodes) add(hierarchyID string, code string, row int64) bool {
+	key := unresolvedKey{hierarchyID, code}
+	c, ok := u.codes[key]
+	if !ok {
+		if len(u.codes) >= maxReportedCodes {
+			u.truncated = true
+			return false
+		}
+		c = &UnresolvedCode{HierarchyID: hierarchyID, Code: code}
+		u.codes[key] = c
+	}
+	c.Occurrences++
+	if len(c.SampleRows) < maxSampleRows {
+		c.SampleRows = append(c.SampleRows, row)
+	}
+	return !ok
+}
+
+// list returns the codes ordered by hierarchy and code.
+func (u *unresolvedCodes) list() []UnresolvedCode {
+	list := make([]UnresolvedCode, 0, len(u.codes))
+	for _, c := range u.codes {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].HierarchyID != list[j].HierarchyID {
+			return list[i].HierarchyID < list[j].HierarchyID
+		}
+		return list[i].Code < list[j].Code
+	})
+	return list
+}
+
+// ValidationReport lists the codes that could not be found in their hierarchies, and the time codes that could not be
+// parsed into a Period.
+type ValidationReport struct {
+	Policy            UnresolvedCodePolicy `json:"policy"`
+	HierarchyLookups  int64                `json:"hierarchyLookups"`
+	Unresolved        int64                `json:"unresolved"`
+	UnresolvedCodes   []UnresolvedCode     `json:"unresolvedCodes"`
+	UnparsedTimeCodes []UnresolvedCode     `json:"unparsedTimeCodes,omitempty"`
+	// Truncated is true if there were more than maxReportedCodes distinct codes, so only the first are listed.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ValidationReport creates the ValidationReport for the transform.
+func (s *Stats) ValidationReport() ValidationReport {
+	report := ValidationReport{
+		Policy:           s.policy,
+		HierarchyLookups: s.HierarchyLookups,
+		Unresolved:       s.unresolvedTotal(),
+		UnresolvedCodes:  []UnresolvedCode{},
+	}
+	if s.unresolved != nil {
+		report.UnresolvedCodes = s.unresolved.list()
+		report.Truncated = s.unresolved.truncated
+	}
+	if s.unparsed != nil && len(s.unparsed.codes) > 0 {
+		report.UnparsedTimeCodes = s.unparsed.list()
+		report.Truncated = report.Truncated || s.unparsed.truncated
+	}
+	return report
+}
+
+// WriteJSON writes the report as json.
+func (r ValidationReport) WriteJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteCSV writes the unresolved codes, followed by the unparsed time codes, as csv, with the sample rows separated by
+// spaces and the Issue of each code either "unresolved" or "unparsed".
+func (r ValidationReport) WriteCSV(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{"Hierarchy_ID", "Code", "Occurrences", "Sample_Rows", "Issue"})
+	for _, codes := range []struct {
+		issue string
+		codes []UnresolvedCode
+	}{{"unresolved", r.UnresolvedCodes}, {"unparsed", r.UnparsedTimeCodes}} {
+		for _, c := range codes.codes {
+			rows := make([]string, len(c.SampleRows))
+			for i, row := range c.SampleRows {
+				rows[i] = strconv.FormatInt(row, 10)
+			}
+			csvWriter.Write([]string{c.HierarchyID, c.Code, strconv.FormatInt(c.Occurrences, 10), strings.Join(rows, " "), codes.issue})
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
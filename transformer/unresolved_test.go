@@ -0,0 +1,80 @@
+package transformer_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseUnresolvedCodePolicy(t *testing.T) {
+
+	Convey("Given a count threshold", t, func() {
+		policy, err := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "100")
+
+		Convey("Then the threshold is not a percentage", func() {
+			So(err, ShouldBeNil)
+			So(policy, ShouldResemble, transformer.UnresolvedCodePolicy{Action: transformer.PolicyFailAboveThreshold, Threshold: 100})
+		})
+	})
+
+	Convey("Given a percentage threshold", t, func() {
+		policy, err := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "0.5%")
+
+		Convey("Then the threshold is a percentage", func() {
+			So(err, ShouldBeNil)
+			So(policy, ShouldResemble, transformer.UnresolvedCodePolicy{Action: transformer.PolicyFailAboveThreshold, Threshold: 0.5, ThresholdIsPercent: true})
+		})
+	})
+
+	Convey("Given an invalid policy or threshold", t, func() {
+		_, actionErr := transformer.ParseUnresolvedCodePolicy("ignore", "")
+		_, thresholdErr := transformer.ParseUnresolvedCodePolicy(transformer.PolicyFailAboveThreshold, "lots")
+
+		Convey("Then an error is returned", func() {
+			So(actionErr, ShouldNotBeNil)
+			So(thresholdErr, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestValidationReport(t *testing.T) {
+
+	Convey("Given a validation report", t, func() {
+		report := transformer.ValidationReport{UnresolvedCodes: []transformer.UnresolvedCode{
+			{HierarchyID: "2011STATH", Code: "K04000001", Occurrences: 3, SampleRows: []int64{2, 5, 9}},
+		}}
+
+		Convey("Then it can be written as csv", func() {
+			var buf bytes.Buffer
+			So(report.WriteCSV(&buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "Hierarchy_ID,Code,Occurrences,Sample_Rows,Issue\n2011STATH,K04000001,3,2 5 9,unresolved\n")
+		})
+	})
+}
+
+func TestStatsSummary(t *testing.T) {
+
+	Convey("Given the stats of a transform with unresolved codes", t, func() {
+		client := createMockHierarchyClient([]string{"time"}, []string{}, []string{"CI_0021510"})
+		_, stats, err := transformWith(&transformer.Transformer{}, scaleSample("Open-Data-v3.csv", 1), client, transformer.Options{})
+		So(err, ShouldBeNil)
+		So(len(stats.ValidationReport().UnresolvedCodes), ShouldBeGreaterThan, 0)
+
+		Convey("Then the summary keeps the counts, but not the codes", func() {
+			summary := stats.Summary()
+			So(summary.RowsWritten, ShouldEqual, stats.RowsWritten)
+			So(summary.UnresolvedCodes, ShouldResemble, stats.UnresolvedCodes)
+			So(summary.HasUnresolvedCodes(), ShouldBeTrue)
+			So(summary.ValidationReport().Unresolved, ShouldEqual, stats.ValidationReport().Unresolved)
+			So(len(summary.ValidationReport().UnresolvedCodes), ShouldEqual, 0)
+		})
+
+		Convey("Then the summary does not share the unresolved counts", func() {
+			before := stats.UnresolvedCodes["CL_0000737"]
+			stats.Summary().UnresolvedCodes["CL_0000737"]++
+			So(stats.UnresolvedCodes["CL_0000737"], ShouldEqual, before)
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package transformer
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RowError is returned when a row of the input csv cannot be transformed.
+type RowError struct {
+	Row    int64
+	Reason string
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("Invalid csv row %d: %s", e.Row, e.Reason)
+}
+
+// check returns a RowError if the dimension in the row does not have the columns of the dimension in the first row: it
+// must have the same name, and a hierarchy (of any id) if and only if the first row does. A time hierarchy (which has
+// no value column) cannot be mixed with other types of hierarchy. Errors getting a hierarchy are returned unchanged.
+func (d *Dimension) check(ctx context.Context, row []string, rowNumber int64) error {
+	if name := strings.TrimSpace(row[d.columns.name]); name != d.name {
+		return RowError{rowNumber, fmt.Sprintf("dimension %d is named '%s', but '%s' in the first row", d.dimensionIndex, name, d.name)}
+	}
+	hierarchyId := strings.TrimSpace(row[d.columns.hierarchy])
+	if !d.isHierarchical {
+		if len(hierarchyId) > 0 {
+			return RowError{rowNumber, fmt.Sprintf("dimension %d has hierarchy %s, but no hierarchy in the first row", d.dimensionIndex, hierarchyId)}
+		}
+		return nil
+	}
+	if len(hierarchyId) == 0 {
+		return RowError{rowNumber, fmt.Sprintf("dimension %d has no hierarchy, but hierarchy %s in the first row", d.dimensionIndex, d.hierarchyId)}
+	}
+	d.mutex.RLock()
+	hierarchyType, ok := d.hierarchyTypes[hierarchyId]
+	d.mutex.RUnlock()
+	if !ok {
+		h, err := d.hc.GetHierarchy(ctx, hierarchyId)
+		if err != nil {
+			return err
+		}
+		hierarchyType = h.Type
+		d.mutex.Lock()
+		d.hierarchyTypes[hierarchyId] = hierarchyType
+		d.mutex.Unlock()
+	}
+	if (hierarchyType == "time") != (d.hierarchyType == "time") {
+		return RowError{rowNumber, fmt.Sprintf("dimension %d has %s hierarchy %s, but %s hierarchy %s in the first row", d.dimensionIndex, hierarchyType, hierarchyId, d.hierarchyType, d.hierarchyId)}
+	}
+	return nil
+}
+
+// RejectedRowLimitError is returned when more rows are rejected in strict mode than the RejectedRowLimit.
+type RejectedRowLimitError struct {
+	Rejected int64
+	Limit    int64
+}
+
+func (e RejectedRowLimitError) Error() string {
+	return fmt.Sprintf("%d rows rejected, above the limit of %d", e.Rejected, e.Limit)
+}
+
+// maxQuarantinedRows the maximum number of rejected rows kept for the quarantine file. Stats.RowsRejected counts every
+// rejected row.
+const maxQuarantinedRows = 100000
+
+type rejectedRow struct {
+	row    int64
+	reason string
+	values []string
+}
+
+// rejectedRows collects the rows rejected in strict mode.
+type rejectedRows struct {
+	headers []string
+	rows    []rejectedRow
+}
+
+// validate returns a RowError if the row does not have a column for each header or, in strict mode, has a column that
+// is not valid UTF-8, or has neither an observation nor a data marking.
+func (p *Transformer) validate(l *layout, row []string, rowNumber int64) error {
+	if len(row) != len(l.headers) {
+		return RowError{rowNumber, fmt.Sprintf("has %d columns, but the header has %d", len(row), len(l.headers))}
+	}
+	if !p.StrictValidation {
+		return nil
+	}
+	for i, value := range row {
+		if !utf8.ValidString(value) {
+			return RowError{rowNumber, fmt.Sprintf("column %d (%s) is not valid UTF-8", i+1, l.headers[i])}
+		}
+	}
+	if len(strings.TrimSpace(row[l.observation])) == 0 && len(strings.TrimSpace(row[l.dataMarking])) == 0 {
+		return RowError{rowNumber, "has neither an observation nor a data marking"}
+	}
+	return nil
+}
+
+// reject quarantines a row that failed with a RowError in strict mode, returning a RejectedRowLimitError if too many
+// rows have been rejected. Otherwise the error is returned unchanged.
+func (p *Transformer) reject(stats *Stats, row []string, err error) error {
+	rowErr, ok := err.(RowError)
+	if !ok || !p.StrictValidation {
+		return err
+	}
+	stats.RowsRejected++
+	if len(stats.rejected.rows) < maxQuarantinedRows {
+		stats.rejected.rows = append(stats.rejected.rows, rejectedRow{rowErr.Row, rowErr.Reason, row})
+	}
+	if stats.RowsRejected > p.RejectedRowLimit {
+		return RejectedRowLimitError{stats.RowsRejected, p.RejectedRowLimit}
+	}
+	return nil
+}
+
+// HasRejectedRows returns true if any row was rejected in strict mode.
+func (s *Stats) HasRejectedRows() bool {
+	return s.RowsRejected > 0
+}
+
+// WriteRejectedRows writes the quarantine file: a csv of the rows rejected in strict mode, each preceded by its row
+// number and the reason it was rejected.
+func (s *Stats) WriteRejectedRows(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	rejected := s.rejected
+	if rejected == nil {
+		rejected = &rejectedRows{}
+	}
+	csvWriter.Write(append([]string{"Row", "Reason"}, rejected.headers...))
+	for _, r := range rejected.rows {
+		csvWriter.Write(append([]string{strconv.FormatInt(r.row, 10), r.reason}, r.values...))
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,119 @@
+package transformer_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-dd-csv-transformer/transformer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRowDimensions(t *testing.T) {
+
+	header := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1,Dimension_Hierarchy_2,Dimension_Name_2,Dimension_Value_2\n"
+
+	Convey("Given rows with different hierarchies of the same type", t, func() {
+		input := header +
+			"1,,,2011STATH,Geography,K04000001,time,Year,2014\n" +
+			"2,,,2013ADMIN,Geography,E92000001,time,Year,2015\n"
+
+		Convey("Then every row is transformed", func() {
+			output, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldBeNil)
+			So(output, ShouldEqual, "Observation,Data_Marking,Observation_Type_Value,"+
+				"Dimension_1_Name,Dimension_1_Hierarchy,Dimension_1_Code,Dimension_1_Value,Dimension_2_Name,Dimension_2_Hierarchy,Dimension_2_Code\n"+
+				"1,,,Geography,2011STATH,K04000001,Value for K04000001,Year,time,2014\n"+
+				"2,,,Geography,2013ADMIN,E92000001,Value for E92000001,Year,time,2015\n")
+		})
+	})
+
+	Convey("Given a row without the hierarchy of the first row", t, func() {
+		input := header +
+			"1,,,2011STATH,Geography,K04000001,time,Year,2014\n" +
+			"2,,,,Geography,K04000001,time,Year,2014\n"
+
+		Convey("Then the row is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldResemble, transformer.RowError{Row: 3, Reason: "dimension 1 has no hierarchy, but hierarchy 2011STATH in the first row"})
+		})
+	})
+
+	Convey("Given a row with a hierarchy where the first row has none", t, func() {
+		input := header +
+			"1,,,,Sex,Male,time,Year,2014\n" +
+			"2,,,2011STATH,Sex,Male,time,Year,2014\n"
+
+		Convey("Then the row is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldResemble, transformer.RowError{Row: 3, Reason: "dimension 1 has hierarchy 2011STATH, but no hierarchy in the first row"})
+		})
+	})
+
+	Convey("Given a row mixing a time hierarchy with another type of hierarchy", t, func() {
+		input := header +
+			"1,,,2011STATH,Geography,K04000001,time,Year,2014\n" +
+			"2,,,2011STATH,Geography,K04000001,CL_0001480,Year,2014\n"
+
+		Convey("Then the row is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldResemble, transformer.RowError{Row: 3, Reason: "dimension 2 has other hierarchy CL_0001480, but time hierarchy time in the first row"})
+		})
+	})
+
+	Convey("Given a row with a different dimension name", t, func() {
+		input := header +
+			"1,,,2011STATH,Geography,K04000001,time,Year,2014\n" +
+			"2,,,2011STATH,Geography,K04000001,time,Month,2014-01\n"
+
+		Convey("Then the row is rejected", func() {
+			_, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldResemble, transformer.RowError{Row: 3, Reason: "dimension 2 is named 'Month', but 'Year' in the first row"})
+		})
+	})
+}
+
+func TestStrictValidation(t *testing.T) {
+
+	header := "Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1\n"
+	input := header +
+		"1,,,,Sex,Male,extra\n" +
+		",,,,Sex,Female\n" +
+		"3,,,,Sex,\xffMale\n" +
+		"4,,,,Sex,Female\n" +
+		",x,,,Sex,All\n"
+
+	Convey("Given a csv with invalid rows", t, func() {
+
+		Convey("Then the transform fails on the first invalid row without strict validation", func() {
+			_, err := transformString(&transformer.Transformer{}, input)
+			So(err, ShouldResemble, transformer.RowError{Row: 2, Reason: "has 7 columns, but the header has 6"})
+		})
+
+		Convey("Then the invalid rows are quarantined with strict validation", func() {
+			var output, rejected bytes.Buffer
+			strict := &transformer.Transformer{StrictValidation: true, RejectedRowLimit: 3}
+			stats, err := strict.Transform(context.Background(), strings.NewReader(input), &output, createMockHierarchyClient([]string{}, []string{}, []string{}), "test", transformer.Options{})
+			So(err, ShouldBeNil)
+			So(output.String(), ShouldEqual, "Observation,Data_Marking,Observation_Type_Value,Dimension_1_Name,Dimension_1_Value\n"+
+				"4,,,Sex,Female\n"+
+				",x,,Sex,All\n")
+			So(stats.RowsRead, ShouldEqual, 5)
+			So(stats.RowsWritten, ShouldEqual, 2)
+			So(stats.RowsRejected, ShouldEqual, 3)
+			So(stats.WriteRejectedRows(&rejected), ShouldBeNil)
+			So(rejected.String(), ShouldEqual, "Row,Reason,Observation,Data_Marking,Observation_Type_Value,Dimension_Hierarchy_1,Dimension_Name_1,Dimension_Value_1\n"+
+				"2,\"has 7 columns, but the header has 6\",1,,,,Sex,Male,extra\n"+
+				"3,has neither an observation nor a data marking,,,,,Sex,Female\n"+
+				"4,column 6 (Dimension_Value_1) is not valid UTF-8,3,,,,Sex,\xffMale\n")
+		})
+
+		Convey("Then the transform fails once more rows are rejected than the limit", func() {
+			strict := &transformer.Transformer{StrictValidation: true, RejectedRowLimit: 1}
+			stats, err := strict.Transform(context.Background(), strings.NewReader(input), &bytes.Buffer{}, createMockHierarchyClient([]string{}, []string{}, []string{}), "test", transformer.Options{})
+			So(err, ShouldResemble, transformer.RejectedRowLimitError{Rejected: 2, Limit: 1})
+			So(stats.HasRejectedRows(), ShouldBeTrue)
+		})
+	})
+}